@@ -0,0 +1,66 @@
+//go:build linux || darwin || netbsd || freebsd || openbsd || dragonfly
+
+package fdmom
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// AcceptAll accepts each connection pending on a listener, without blocking.
+// The file descriptor should come from ListenerFile, included in a Watch. Await
+// reports readiness once for any number of connections pending, which is why
+// AcceptAll continues until none are left. The return is empty when another
+// routine took the connections in between readiness and the accept. AcceptAll
+// puts the listener in non-blocking mode, as it would block otherwise.
+//
+// Connections accepted before an error are returned together with the error.
+func AcceptAll(fd int) ([]net.Conn, error) {
+	err := SetNonBlock(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	var conns []net.Conn
+	for {
+		conn, err := acceptOne(fd, "AcceptAll")
+		if err != nil {
+			return conns, err
+		}
+		if conn == nil {
+			return conns, nil
+		}
+		conns = append(conns, conn)
+	}
+}
+
+// AcceptOne accepts a connection pending on a listener in non-blocking mode.
+// The return is nil without error when none are pending. Op names the caller
+// in errors.
+func acceptOne(fd int, op string) (net.Conn, error) {
+	for {
+		syscall.ForkLock.RLock()
+		connFD, _, err := syscall.Accept(fd)
+		if err == nil {
+			syscall.CloseOnExec(connFD)
+		}
+		syscall.ForkLock.RUnlock()
+		switch err {
+		case nil:
+			break
+		case syscall.EAGAIN:
+			return nil, nil
+		case syscall.EINTR, syscall.ECONNABORTED:
+			continue // next in line
+		default:
+			return nil, fmt.Errorf("%s lost on accept(2) error %w", op, err)
+		}
+
+		f := os.NewFile(uintptr(connFD), "accepted connection")
+		conn, err := net.FileConn(f)
+		f.Close() // FileConn has a duplicate
+		return conn, err
+	}
+}
@@ -0,0 +1,68 @@
+//go:build linux || darwin || netbsd || freebsd || openbsd || dragonfly
+
+package fdmom
+
+import (
+	"net"
+	"testing"
+)
+
+func TestAcceptAll(t *testing.T) {
+	p := newPipe(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	f, err := ListenerFile(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fd := int(f.Fd())
+	err = p.Watch.IncludeFD(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const connCount = 3
+	for i := 0; i < connCount; i++ {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+	}
+
+	got, err := p.Watch.AwaitFDWithRead(holdupMax)
+	if err != nil || got != fd {
+		t.Fatalf("got FD %#x with error %v, want FD %#x of listener", got, err, fd)
+	}
+	var accepted []net.Conn
+	for len(accepted) < connCount {
+		conns, err := AcceptAll(fd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		accepted = append(accepted, conns...)
+		if len(conns) == 0 {
+			// handshakes may still be in progress
+			_, err = p.Watch.AwaitFDWithRead(holdupMax)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for _, conn := range accepted {
+		conn.Close()
+	}
+	if len(accepted) != connCount {
+		t.Errorf("got %d connections, want %d", len(accepted), connCount)
+	}
+
+	conns, err := AcceptAll(fd)
+	if err != nil || len(conns) != 0 {
+		t.Errorf("got %d connections with error %v after all accepted, want none", len(conns), err)
+	}
+}
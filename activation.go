@@ -0,0 +1,80 @@
+//go:build linux || darwin || netbsd || freebsd || openbsd || dragonfly
+
+package fdmom
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ListenFDsStart is the first file descriptor passed with socket activation, as
+// SD_LISTEN_FDS_START from <systemd/sd-daemon.h>.
+const listenFDsStart = 3
+
+// ActivationFiles returns the file descriptors passed with socket activation
+// from systemd, per sd_listen_fds(3), in order of LISTEN_FDS. Each file has its
+// name from LISTEN_FDNAMES, with "unknown" when absent, like systemd does. The
+// environment variables are unset on return, such that child processes do not
+// claim the same descriptors. The return is empty when the variables are absent,
+// or when they are meant for another process. Net.FileListener and
+// net.FilePacketConn can wrap the files.
+//
+// When w is not nil, then each file descriptor is included with IncludeFD, such
+// that AcceptAll can serve the stream sockets on Await. Files are returned
+// together with any errors from the inclusion, each as an *FDError.
+func ActivationFiles(w *Watch) ([]*os.File, error) {
+	return activationFiles(w, listenFDsStart)
+}
+
+// ActivationFiles implements ActivationFiles with the first file descriptor at
+// start.
+func activationFiles(w *Watch, start int) ([]*os.File, error) {
+	pid := os.Getenv("LISTEN_PID")
+	fds := os.Getenv("LISTEN_FDS")
+	names := os.Getenv("LISTEN_FDNAMES")
+	// passed once
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	if pid == "" || fds == "" {
+		return nil, nil
+	}
+	if n, err := strconv.Atoi(pid); err != nil {
+		return nil, fmt.Errorf("socket activation with malformed LISTEN_PID %q", pid)
+	} else if n != os.Getpid() {
+		return nil, nil // for another process
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("socket activation with malformed LISTEN_FDS %q", fds)
+	}
+	var nameList []string
+	if names != "" {
+		nameList = strings.Split(names, ":")
+	}
+
+	files := make([]*os.File, 0, n)
+	var errs []error
+	for i := 0; i < n; i++ {
+		fd := start + i
+		syscall.CloseOnExec(fd)
+		name := "unknown"
+		if i < len(nameList) {
+			name = nameList[i]
+		}
+		files = append(files, os.NewFile(uintptr(fd), name))
+
+		if w != nil {
+			err := w.IncludeFD(fd)
+			if err != nil {
+				errs = append(errs, &FDError{FD: fd, Err: err})
+			}
+		}
+	}
+	return files, errors.Join(errs...)
+}
@@ -0,0 +1,150 @@
+//go:build linux || darwin || netbsd || freebsd || openbsd || dragonfly
+
+package fdmom
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+// IncludeConn adds the file descriptor of the connection to the watch list, like
+// IncludeFD does, without any duplication as with ConnFile. The return is the
+// file descriptor as reported by Await. The file descriptor remains owned by the
+// connection, which means that it should be excluded, e.g., with ExcludeConn,
+// before the connection is closed. The Go runtime may reuse the number for any
+// new file otherwise.
+func (w *Watch) IncludeConn(conn net.Conn) (fd int, err error) {
+	c, err := syscallConn(conn)
+	if err != nil {
+		return -1, err
+	}
+	return controlFD(c, w.IncludeFD)
+}
+
+// ExcludeConn removes the file descriptor of the connection from the watch
+// list, like ExcludeFD does. Absence is ignored silently.
+func (w *Watch) ExcludeConn(conn net.Conn) error {
+	c, err := syscallConn(conn)
+	if err != nil {
+		return err
+	}
+	_, err = controlFD(c, w.ExcludeFD)
+	return err
+}
+
+// IncludeListener adds the file descriptor of the listener to the watch list,
+// like IncludeConn does for connections. AwaitAccept reports the listener once
+// a connection is pending. The typical use is to park many idle listeners on one
+// Watch, with an accept routine only for those which are busy.
+func (w *Watch) IncludeListener(l net.Listener) (fd int, err error) {
+	c, ok := l.(syscall.Conn)
+	if !ok {
+		return -1, fmt.Errorf("listener %T does not provide its file descriptor", l)
+	}
+	return controlFD(c, func(fd int) error {
+		w.mutex.Lock()
+		defer w.mutex.Unlock()
+		if w.closed {
+			return ErrClosed
+		}
+		err := w.counters.include("include", fd, Read, w.add(fd, Read|w.fds[fd]))
+		if err != nil {
+			return err
+		}
+		if w.listeners == nil {
+			w.listeners = make(map[int]net.Listener)
+		}
+		w.listeners[fd] = l
+		return nil
+	})
+}
+
+// ExcludeListener removes the file descriptor of the listener from the watch
+// list. Absence is ignored silently.
+func (w *Watch) ExcludeListener(l net.Listener) error {
+	c, ok := l.(syscall.Conn)
+	if !ok {
+		return fmt.Errorf("listener %T does not provide its file descriptor", l)
+	}
+	_, err := controlFD(c, func(fd int) error {
+		w.mutex.Lock()
+		defer w.mutex.Unlock()
+		if w.closed {
+			return ErrClosed
+		}
+		delete(w.listeners, fd)
+		return w.exclude(fd)
+	})
+	return err
+}
+
+// AwaitAccept blocks until a listener from IncludeListener has a connection
+// pending. The listener leaves the watch list on return, such that it is not
+// reported again while its connections are accepted. Use IncludeListener to
+// park the listener again. Other file descriptors ready come as an *FDError.
+// Timeouts apply as with AwaitFDWithRead.
+func (w *Watch) AwaitAccept(timeout time.Duration) (net.Listener, error) {
+	fd, err := w.AwaitFDWithRead(timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	l, ok := w.listeners[fd]
+	delete(w.listeners, fd)
+	if ok {
+		// numbers are reused once the listener is closed
+		c := l.(syscall.Conn)
+		got, err := controlFD(c, func(int) error { return nil })
+		ok = err == nil && got == fd
+	}
+	if !ok {
+		return nil, &FDError{FD: fd, Err: errors.New("not a listener from IncludeListener")}
+	}
+	if w.closed {
+		return nil, ErrClosed
+	}
+	err = w.exclude(fd)
+	if err != nil {
+		return nil, &FDError{FD: fd, Err: err}
+	}
+	return l, nil
+}
+
+// SyscallConn returns the underlying syscall.Conn, if any.
+func syscallConn(conn net.Conn) (syscall.Conn, error) {
+	nested, ok := conn.(netConner)
+	if ok {
+		conn = nested.NetConn()
+	}
+	c, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil, fmt.Errorf("connection %T does not provide its file descriptor", conn)
+	}
+	return c, nil
+}
+
+// ControlFD applies f to the file descriptor of c. The file descriptor remains
+// valid for the duration of f, even with a Close on c in the mean time.
+func controlFD(c syscall.Conn, f func(fd int) error) (fd int, err error) {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	var fErr error
+	err = raw.Control(func(u uintptr) {
+		fd = int(u)
+		fErr = f(fd)
+	})
+	if err != nil {
+		return -1, err
+	}
+	if fErr != nil {
+		return -1, fErr
+	}
+	return fd, nil
+}
@@ -0,0 +1,256 @@
+//go:build linux || darwin || netbsd || freebsd || openbsd || dragonfly
+
+package fdmom
+
+import (
+	"container/heap"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Deadlines schedule expiries per file descriptor with a single timer from
+// AddTimer, armed at the earliest deadline. The timer is internal, i.e., it is
+// not subject to RemoveTimer nor Drain. Changes to armed and timer require the
+// mutex of the Watch in addition, such that the Watch can tell the timer apart
+// without the deadline mutex.
+type deadlines struct {
+	inUse atomic.Bool // any SetFDDeadline since OpenWatch
+
+	mutex   sync.Mutex          // acquired before the mutex of the Watch
+	armed   bool                // timer in place, also with the Watch mutex
+	timer   int                 // AddTimer identifier when armed
+	at      time.Time           // expiry of the timer when armed
+	byFD    map[int]*fdDeadline // current deadline per file descriptor
+	queue   deadlineQueue       // may contain outdated entries
+	expired []int               // pending for Await
+}
+
+// FDDeadline is the deadline of a file descriptor. Readiness moves at forward
+// without a queue update. The queue entry of queued moves on when it comes up.
+type fdDeadline struct {
+	at     time.Time     // expiry
+	idle   time.Duration // silence permitted
+	queued time.Time     // current entry in queue
+}
+
+// DeadlineEntry is a deadline of a file descriptor.
+type deadlineEntry struct {
+	fd int
+	at time.Time
+}
+
+// DeadlineQueue is a min-heap on time.
+type deadlineQueue []deadlineEntry
+
+// Len implements the heap.Interface.
+func (q deadlineQueue) Len() int { return len(q) }
+
+// Less implements the heap.Interface.
+func (q deadlineQueue) Less(i, j int) bool { return q[i].at.Before(q[j].at) }
+
+// Swap implements the heap.Interface.
+func (q deadlineQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+// Push implements the heap.Interface.
+func (q *deadlineQueue) Push(x any) { *q = append(*q, x.(deadlineEntry)) }
+
+// Pop implements the heap.Interface.
+func (q *deadlineQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// IncludeFDWithDeadline adds the file descriptor to the watch list, like
+// IncludeFD does, with a deadline as with SetFDDeadline.
+func (w *Watch) IncludeFDWithDeadline(fd int, t time.Time) error {
+	err := w.IncludeFD(fd)
+	if err != nil {
+		return err
+	}
+	return w.SetFDDeadline(fd, t)
+}
+
+// SetFDDeadline schedules an expiry for a file descriptor on the watch list,
+// which replaces any deadline from before. The zero value for t removes the
+// deadline. Once t passes, an Await reports the file descriptor as an *FDError
+// with ErrTimeout. Each readiness reported by an Await pushes the deadline back
+// by the duration from now until t, such that only file descriptors which stay
+// silent for that long expire. Expiries apply once only.
+//
+// A single timer serves all deadlines of the Watch, as opposed to one per file
+// descriptor. AwaitFDs leaves the expiries for the next Await to report.
+func (w *Watch) SetFDDeadline(fd int, t time.Time) error {
+	if !w.IsWatched(fd) {
+		if w.isClosed() {
+			return ErrClosed
+		}
+		return ErrNotWatched
+	}
+
+	d := &w.deadlines
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.inUse.Store(true)
+	if t.IsZero() {
+		delete(d.byFD, fd)
+		return nil // outdated entry stays in queue
+	}
+	if d.byFD == nil {
+		d.byFD = make(map[int]*fdDeadline)
+	}
+	e, ok := d.byFD[fd]
+	if !ok {
+		e = new(fdDeadline)
+		d.byFD[fd] = e
+	}
+	e.at, e.idle = t, time.Until(t)
+	if ok && !t.Before(e.queued) {
+		return nil // queue entry moves on when it comes up
+	}
+	e.queued = t
+	heap.Push(&d.queue, deadlineEntry{fd: fd, at: t})
+
+	if d.armed && !t.Before(d.at) {
+		return nil // timer fires in time
+	}
+	return w.armDeadlines()
+}
+
+// DeadlineReady pushes any deadline of fd back on readiness.
+func (w *Watch) deadlineReady(fd int) {
+	if !w.deadlines.inUse.Load() {
+		return
+	}
+	d := &w.deadlines
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if e, ok := d.byFD[fd]; ok {
+		e.at = time.Now().Add(e.idle)
+	}
+}
+
+// Requeue returns whether the head of the queue is the current deadline of its
+// file descriptor. Outdated entries are discarded, and entries pushed back move
+// to their new position. The deadline mutex must be held.
+func (d *deadlines) requeue() bool {
+	head := d.queue[0]
+	e, ok := d.byFD[head.fd]
+	switch {
+	case !ok || !e.queued.Equal(head.at):
+		heap.Pop(&d.queue)
+		return false
+	case e.at.After(head.at):
+		e.queued = e.at
+		d.queue[0].at = e.at
+		heap.Fix(&d.queue, 0)
+		return false
+	}
+	return true
+}
+
+// ArmDeadlines replaces the timer, if any, with one for the earliest deadline.
+// The deadline mutex must be held.
+func (w *Watch) armDeadlines() error {
+	d := &w.deadlines
+	if d.armed {
+		w.mutex.Lock()
+		d.armed = false
+		w.mutex.Unlock()
+		err := w.RemoveTimer(d.timer)
+		if err != nil {
+			return err
+		}
+	}
+
+	// discard outdated entries
+	for len(d.queue) != 0 {
+		if d.requeue() {
+			break
+		}
+	}
+	if len(d.queue) == 0 {
+		return nil
+	}
+
+	at := d.queue[0].at
+	delay := time.Until(at)
+	if delay <= 0 {
+		delay = 1 // AddTimer needs positive
+	}
+	id, err := w.AddTimer(delay, false)
+	if err != nil {
+		return err
+	}
+	w.mutex.Lock()
+	d.armed, d.timer, d.at = true, id, at
+	w.mutex.Unlock()
+	return nil
+}
+
+// IsDeadlineTimer returns whether id is the timer of the deadlines. The mutex
+// of the Watch must be held.
+func (w *Watch) isDeadlineTimer(id int) bool {
+	return w.deadlines.armed && w.deadlines.timer == id
+}
+
+// ResetDeadlines drops all deadlines and expiries pending. The timer is for the
+// caller to remove. Both the deadline mutex and the mutex of the Watch must be
+// held.
+func (w *Watch) resetDeadlines() {
+	d := &w.deadlines
+	d.armed = false
+	for fd := range d.byFD {
+		delete(d.byFD, fd)
+	}
+	d.queue = d.queue[:0]
+	d.expired = d.expired[:0]
+}
+
+// DeadlineFired handles an expiry of timer id. The return is false when id is
+// not the timer of the deadlines.
+func (w *Watch) deadlineFired(id int) bool {
+	if !w.deadlines.inUse.Load() {
+		return false
+	}
+	d := &w.deadlines
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if !d.armed || id != d.timer {
+		return false
+	}
+
+	now := time.Now()
+	for len(d.queue) != 0 && !d.queue[0].at.After(now) {
+		if !d.requeue() {
+			continue // outdated or pushed back
+		}
+		e := heap.Pop(&d.queue).(deadlineEntry)
+		delete(d.byFD, e.fd)
+		d.expired = append(d.expired, e.fd)
+	}
+	// errors from the timer are for SetFDDeadline to report
+	w.armDeadlines()
+	return true
+}
+
+// TakeExpiry returns the next expiry pending as an *FDError, if any. File
+// descriptors which left the watch list in the mean time are discarded.
+func (w *Watch) takeExpiry() error {
+	if !w.deadlines.inUse.Load() {
+		return nil
+	}
+	d := &w.deadlines
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for len(d.expired) != 0 {
+		fd := d.expired[0]
+		d.expired = d.expired[1:]
+		if w.IsWatched(fd) {
+			return &FDError{FD: fd, Err: ErrTimeout}
+		}
+	}
+	return nil
+}
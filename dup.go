@@ -0,0 +1,43 @@
+//go:build linux || darwin || netbsd || freebsd || openbsd || dragonfly
+
+package fdmom
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// DupFile returns a duplicate of the file descriptor in conn, with the
+// close-on-exec flag set.
+func dupFile(conn syscall.Conn, name string) (*os.File, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var dupFD int
+	var dupErr error
+	err = raw.Control(func(fd uintptr) {
+		dupFD, dupErr = dupCloseOnExec(int(fd))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if dupErr != nil {
+		return nil, dupErr
+	}
+	return os.NewFile(uintptr(dupFD), name), nil
+}
+
+// DupCloseOnExec returns a duplicate of fd with the close-on-exec flag set.
+func dupCloseOnExec(fd int) (int, error) {
+	syscall.ForkLock.RLock()
+	defer syscall.ForkLock.RUnlock()
+	dupFD, err := syscall.Dup(fd)
+	if err != nil {
+		return -1, fmt.Errorf("file descriptor lost on dup(2) error %w", err)
+	}
+	syscall.CloseOnExec(dupFD)
+	return dupFD, nil
+}
@@ -0,0 +1,15 @@
+//go:build !(linux || darwin || netbsd || freebsd || openbsd || dragonfly)
+
+package fdmom
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// DupFile is not implemented.
+func dupFile(conn syscall.Conn, name string) (*os.File, error) {
+	return nil, fmt.Errorf("file descriptor duplication not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,99 @@
+//go:build linux || darwin || netbsd || freebsd || openbsd || dragonfly
+
+package fdmom
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// RawListener hides any File method.
+type rawListener struct {
+	net.Listener
+	syscall.Conn
+}
+
+func TestListenerFileSyscallConn(t *testing.T) {
+	w := newWatch(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	f, err := ListenerFile(rawListener{l, l.(*net.TCPListener)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	fd := int(f.Fd())
+	err = w.IncludeFD(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	got, err := w.AwaitFDWithRead(holdupMax)
+	if err != nil || got != fd {
+		t.Errorf("got FD %#x with error %v, want FD %#x of listener file",
+			got, err, fd)
+	}
+
+	// independent of listener
+	err = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.TCPListener).SetDeadline(time.Now().Add(holdupMax))
+	accepted, err := l.Accept()
+	if err != nil {
+		t.Fatal("accept after file close:", err)
+	}
+	accepted.Close()
+}
+
+// RawPacketConn hides any File method.
+type rawPacketConn struct {
+	net.PacketConn
+	syscall.Conn
+}
+
+func TestPacketConnFile(t *testing.T) {
+	w := newWatch(t)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	f, err := PacketConnFile(rawPacketConn{conn, conn.(*net.UDPConn)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	fd := int(f.Fd())
+	err = w.IncludeFD(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.WriteTo([]byte{'x'}, conn.LocalAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := w.AwaitFDWithRead(holdupMax)
+	if err != nil || got != fd {
+		t.Errorf("got FD %#x with error %v, want FD %#x of packet connection file",
+			got, err, fd)
+	}
+}
@@ -5,31 +5,241 @@ package fdmom
 import (
 	"errors"
 	"fmt"
+	"math"
+	"net"
+	"os"
+	"sync"
 	"syscall"
 	"time"
+	"unsafe"
 )
 
-// ErrWatchable is only available on Linux. The event notification facility with
-// epoll(7) can not operate on regular files or directories.
+// ErrWatchable is only available on Linux and Windows. The event notification
+// facility with epoll(7) can not operate on regular files or directories. Watch
+// has a fallback for regular files, which leaves directories to ErrWatchable.
 var ErrWatchable = errors.New("file type not suitable for Watch with epoll(7)")
 
 // Watch monitors a list of files for read availability.
 type Watch struct {
-	epollFD int // epoll(7)
+	epollFD int    // epoll(7)
+	wakeFD  int    // eventfd(2)
+	ring    *uring // IOURing option
+	config  config
+
+	mutex     sync.Mutex
+	closed    bool
+	done      chan struct{}        // closed on Close
+	waiters   int                  // number of Awaits in progress
+	fds       map[int]Interest     // watch list
+	gens      map[int]int32        // registration generation per file descriptor
+	genNext   int32                // last generation issued
+	tokens    map[int]uint64       // from IncludeFDToken
+	ranks     map[int]int          // from IncludeFDRank
+	hungUp    map[int]uint64       // tokens for the terminal event from ExcludeOnHangup
+	children  map[int]*Watch       // from IncludeWatch
+	listeners map[int]net.Listener // from IncludeListener
+	timers    map[int]struct{}     // timerfd(2) descriptors
+	regular   map[int]*regularFile // fallback for regular files
+	paths     map[int]PathOp       // inotify(7) from WatchPath
+	procs     map[int]int          // pidfd_open(2) with pid from IncludeProcess
+	signals   map[int]struct{}     // signalfd(2) from IncludeSignals
+	posted    []uint64             // from Post, pending for AwaitEvent
+	readAhead []syscall.EpollEvent // from EventBatch, pending for AwaitFD
+	ringErr   error                // from a batch, pending for the next Await
+
+	counters  counters
+	deadlines deadlines // from SetFDDeadline
+
+	eventsOnce sync.Once
+	events     chan int
 }
 
 // OpenWatch starts with an empty file list.
-func OpenWatch() (*Watch, error) {
-	const noFlags = 0
-	epollFD, err := syscall.EpollCreate1(noFlags)
+func OpenWatch(opts ...Option) (*Watch, error) {
+	var c config
+	for _, o := range opts {
+		o(&c)
+	}
+
+	createFlags := syscall.EPOLL_CLOEXEC
+	if c.keepOnExec {
+		createFlags = 0
+	}
+	epollFD, err := syscall.EpollCreate1(createFlags)
 	if err != nil {
 		return nil, fmt.Errorf("no Watch due epoll_create1(2) error %w", err)
 	}
-	return &Watch{epollFD: epollFD}, nil
+
+	const flags = syscall.O_NONBLOCK | syscall.O_CLOEXEC // EFD_NONBLOCK | EFD_CLOEXEC
+	r1, _, errno := syscall.RawSyscall(syscall.SYS_EVENTFD2, 0, flags, 0)
+	if errno != 0 {
+		syscall.Close(epollFD)
+		return nil, fmt.Errorf("no Watch due eventfd(2) error %w", errno)
+	}
+	wakeFD := int(r1)
+
+	event := syscall.EpollEvent{
+		Fd:     int32(wakeFD),
+		Events: syscall.EPOLLIN,
+	}
+	err = syscall.EpollCtl(epollFD, syscall.EPOLL_CTL_ADD, wakeFD, &event)
+	if err != nil {
+		syscall.Close(wakeFD)
+		syscall.Close(epollFD)
+		return nil, fmt.Errorf("no Watch due epoll_ctl(2) error %w", err)
+	}
+
+	w := &Watch{
+		epollFD: epollFD,
+		wakeFD:  wakeFD,
+		done:    make(chan struct{}),
+		fds:     make(map[int]Interest),
+		gens:    make(map[int]int32),
+		timers:  make(map[int]struct{}),
+		config:  c,
+	}
+	if w.config.countPerFD {
+		w.counters.perFD = make(map[int]uint64)
+	}
+	w.counters.diag = w.config.diagnostics
+	if w.config.trackLatency {
+		w.counters.latency = &latencyTracker{
+			slowGap:   w.config.slowGap,
+			slowEvent: w.config.slowEvent,
+		}
+	}
+	if w.config.ioURing {
+		w.ring, err = openRing()
+		if err == nil {
+			err = w.ring.pollAdd(wakeFD, syscall.EPOLLIN, true)
+			if err != nil {
+				w.ring.close()
+				err = fmt.Errorf("no Watch due io_uring_enter(2) error %w", err)
+			}
+		}
+		if err != nil {
+			syscall.Close(wakeFD)
+			syscall.Close(epollFD)
+			return nil, err
+		}
+	}
+	if w.config.sweepInterval > 0 {
+		go w.sweep(w.config.sweepInterval)
+	}
+	return w, nil
 }
 
-// Close implements the io.Closer interface.
+// Close implements the io.Closer interface. Any Awaits in progress return with
+// ErrClosed, including those with results from the same instant. The epoll(7)
+// descriptor is released on return of the last Await, in which case any error
+// from close(2) is lost.
 func (w *Watch) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	close(w.done)
+	w.posted = nil
+	w.readAhead = nil
+
+	for fd := range w.timers {
+		syscall.Close(fd)
+		delete(w.timers, fd)
+	}
+	for fd, f := range w.regular {
+		syscall.Close(f.eventFD)
+		delete(w.regular, fd)
+	}
+	w.closePaths()
+	w.closeProcesses()
+	w.closeSignals()
+	for fd := range w.fds {
+		delete(w.fds, fd)
+		delete(w.tokens, fd)
+		delete(w.ranks, fd)
+	}
+
+	if w.waiters != 0 {
+		// The eventfd(2) is never read after Close, which means
+		// that it wakes each Await in progress.
+		w.wake()
+		return nil
+	}
+	return w.release()
+}
+
+// File returns a duplicate of the epoll(7) descriptor. Closing the file does not
+// affect the Watch, and vise versa. The descriptor can be included in another
+// Watch, which reports it as readable while any events are pending.
+func (w *Watch) File() (*os.File, error) {
+	if !w.enter() {
+		return nil, ErrClosed
+	}
+	defer w.leave()
+
+	facility, kernelFD := w.kernelFD()
+	fd, err := dupCloseOnExec(kernelFD)
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), facility), nil
+}
+
+// SyscallConn provides access to the epoll(7) descriptor without ownership.
+// The descriptor remains valid for the duration of Control, even with Close in
+// the mean time. Use of the descriptor concurrent to Await is undefined. Read
+// waits for pending events, and Write is not supported.
+func (w *Watch) SyscallConn() (syscall.RawConn, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return nil, ErrClosed
+	}
+	_, fd := w.kernelFD()
+	return rawConn{w: w, fd: fd}, nil
+}
+
+// FD returns the epoll(7) descriptor, or the io_uring(7) descriptor, for an
+// outer event loop to include, such as GLib, libev or another Watch. Readable
+// means that an Await has results. Events read ahead with EventBatch do not
+// count, which is why an outer loop should Await with a zero timeout until
+// ErrTimeout. The Watch keeps ownership, i.e., the number is void after Close.
+// The return is -1 once closed.
+func (w *Watch) FD() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return -1
+	}
+	_, fd := w.kernelFD()
+	return fd
+}
+
+// String returns a summary of the state, including the watch list, for
+// debugging. It is safe for use during an Await.
+func (w *Watch) String() string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	facility, fd := w.kernelFD()
+	return describe(facility, fd, w.closed, []int{w.wakeFD}, w.fds, w.timers)
+}
+
+// KernelFD returns the descriptor which Await waits on.
+func (w *Watch) kernelFD() (facility string, fd int) {
+	if w.ring != nil {
+		return "io_uring(7)", w.ring.fd
+	}
+	return "epoll(7)", w.epollFD
+}
+
+// Release frees the kernel resources of a closed Watch.
+func (w *Watch) release() error {
+	if w.ring != nil {
+		w.ring.close()
+	}
+	syscall.Close(w.wakeFD)
 	err := syscall.Close(w.epollFD)
 	if err != nil && err != syscall.EBADF {
 		return fmt.Errorf("Watch stuck on close(2) of epoll(7) error %w", err)
@@ -37,72 +247,1065 @@ func (w *Watch) Close() error {
 	return nil
 }
 
+// Wake interrupts an Await in progress, if any, or the next one otherwise.
+func (w *Watch) wake() {
+	one := uint64(1)
+	syscall.Write(w.wakeFD, (*[8]byte)(unsafe.Pointer(&one))[:])
+}
+
+// WakeReadFD returns the descriptor which becomes readable on wakeup.
+func (w *Watch) wakeReadFD() int { return w.wakeFD }
+
+// Woken handles a wakeup event. The return is either ErrClosed or ErrWoken, or
+// nil when another routine took the wakeup already.
+func (w *Watch) woken() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+
+	var buf [8]byte // counter value
+	for {
+		_, err := syscall.Read(w.wakeFD, buf[:])
+		switch err {
+		case nil:
+			return ErrWoken
+		case syscall.EINTR:
+			continue
+		}
+		return nil
+	}
+}
+
+// Enter registers an Await in progress. The return is false when closed.
+func (w *Watch) enter() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return false
+	}
+	w.waiters++
+	return true
+}
+
+// Leave unregisters an Await in progress, and it releases the kernel resources
+// when Close is pending on its completion.
+func (w *Watch) leave() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.waiters--
+	if w.closed && w.waiters == 0 {
+		w.release()
+	}
+}
+
 // AwaitFDWithRead blocks until it finds a file descriptor with read available
 // per direct. Positive timeout values, including zero for non-blocking, cause
 // an ErrTimeout on expiry. Negative timeouts block indefinitely.
 func (w *Watch) AwaitFDWithRead(timeout time.Duration) (fd int, err error) {
-	// timeout rounds up as they are a minimum guarantee
-	msec := int((timeout + time.Millisecond - 1) / time.Millisecond)
-	if timeout < 0 {
-		msec = -1 // indefinite
+	fd, _, err = w.AwaitFD(timeout)
+	return fd, err
+}
+
+// AwaitFD is like AwaitFDWithRead, yet it also reports which conditions were
+// met on the file descriptor.
+func (w *Watch) AwaitFD(timeout time.Duration) (fd int, ready Interest, err error) {
+	return w.await(timeout, nil)
+}
+
+// AwaitFDWithReadMasked is like AwaitFDWithRead, yet it replaces the signal mask
+// of the thread with the signals in mask for the duration of the wait, as an
+// atomic operation, like epoll_pwait(2) does. Signals blocked outside of the
+// wait, such as those for IncludeSignalFD, get unblocked when omitted. Any such
+// signal arriving before the wait interrupts the wait rather than getting lost
+// in between.
+//
+// AwaitFDWithReadMasked is available on Linux only. Kqueue(2) has no signal mask
+// argument, and pselect(2)-style masking is not supported on the BSDs.
+func (w *Watch) AwaitFDWithReadMasked(timeout time.Duration, mask ...syscall.Signal) (fd int, err error) {
+	sigset, err := sigsetOf(mask)
+	if err != nil {
+		return 0, fmt.Errorf("Watch AwaitFDWithReadMasked got %w", err)
+	}
+	fd, _, err = w.await(timeout, &sigset)
+	return fd, err
+}
+
+// Await is the implementation of AwaitFD with an optional signal mask.
+func (w *Watch) await(timeout time.Duration, sigmask *sigset) (fd int, ready Interest, err error) {
+	w.counters.await()
+	if !w.enter() {
+		return 0, 0, ErrClosed
+	}
+	defer w.leave()
+	if err := w.takeExpiry(); err != nil {
+		return 0, 0, err
+	}
+	if fd := w.pendingChild(); fd >= 0 {
+		w.counters.event(fd)
+		w.deadlineReady(fd)
+		return fd, Read, nil
+	}
+	if w.ring != nil {
+		return w.ringAwait(timeout, sigmask)
+	}
+
+	// restarts continue with the remainder
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
 	}
 
-	// epoll_wait(2) goes round robin on multiple matches
-	var buf [1]syscall.EpollEvent
-ReadEvents:
+	// epoll_wait(2) goes round robin on multiple matches. The stack
+	// keeps the buffer off the heap, without locking for concurrent
+	// Awaits, as a buffer on the Watch would need.
+	var stack [64]syscall.EpollEvent
+	buf := stack[:1]
+	if n := w.config.eventBatch; n > len(stack) {
+		buf = make([]syscall.EpollEvent, n)
+	} else if n > 1 {
+		buf = stack[:n]
+	} else if w.config.ordering == RankOrder {
+		// choose from a batch
+		buf = stack[:]
+	}
+	var staleSeen map[int32]bool // non-blocking only
 	for {
-		n, err := syscall.EpollWait(w.epollFD, buf[:], msec)
-		switch err {
-		case nil:
+		n := w.takeReadAhead(buf[:1])
+		if n == 0 {
+			n, err = epollWait(w.epollFD, buf, timeout, sigmask)
+			if err != nil {
+				switch err {
+				case syscall.EINTR:
+					w.counters.restart()
+					timeout = remaining(timeout, deadline)
+					continue
+				case syscall.EBADF:
+					return 0, 0, ErrClosed
+				}
+				return 0, 0, w.counters.unavailable(fmt.Errorf("Watch unavailable due epoll_wait(2) error %w", err))
+			}
+			if w.isClosed() {
+				// Close wins from any results in the same instant.
+				return 0, 0, ErrClosed
+			}
 			if n == 0 {
+				if timeout > epollMsecMax*time.Millisecond {
+					// wait was capped; continue with the remainder
+					timeout = remaining(timeout, deadline)
+					if timeout > 0 {
+						continue
+					}
+				}
+				w.counters.timeouts.Add(1)
+				return 0, 0, ErrTimeout
+			}
+			if w.config.ordering == RankOrder {
+				w.rankSort(buf[:n])
+			}
+			w.keepReadAhead(buf[1:n])
+		}
+
+		fd = int(buf[0].Fd)
+		if fd == w.wakeFD {
+			err := w.woken()
+			if err != nil {
+				return 0, 0, err
+			}
+			// wakeup taken by another routine
+			timeout = remaining(timeout, deadline)
+			continue
+		}
+		if w.isTimer(fd) && !readTimer(fd) {
+			// expiry taken by another routine
+			timeout = remaining(timeout, deadline)
+			continue
+		}
+		if w.stale(&buf[0]) {
+			// Level-triggered registrations keep reporting. The
+			// kernel rotates its ready list, which means that a
+			// repeat leaves nothing else ready.
+			if timeout == 0 {
+				if staleSeen[buf[0].Fd] {
+					w.counters.timeouts.Add(1)
+					return 0, 0, ErrTimeout
+				}
+				if staleSeen == nil {
+					staleSeen = make(map[int32]bool)
+				}
+				staleSeen[buf[0].Fd] = true
+			}
+			timeout = remaining(timeout, deadline)
+			continue
+		}
+		if w.deadlineFired(fd) {
+			if err := w.takeExpiry(); err != nil {
+				return 0, 0, err
+			}
+			timeout = remaining(timeout, deadline)
+			continue
+		}
+		ready = readyOf(buf[0].Events)
+		if ready&Hangup != 0 && w.config.excludeOnHangup {
+			w.excludeHungUp(fd)
+		}
+		w.counters.event(fd)
+		w.deadlineReady(fd)
+		return fd, ready, nil
+	}
+}
+
+// AwaitFDs is like AwaitFDWithRead, yet it fills buf with the file descriptors
+// ready from a single epoll_wait(2), up to len(buf) of them. The return is the
+// number of file descriptors in buf, which is at least one on success. The
+// kernel rotates over the ready list when more are ready than buf can hold.
+func (w *Watch) AwaitFDs(buf []int, timeout time.Duration) (n int, err error) {
+	return w.awaitBatch(awaitBuf{fds: buf}, timeout)
+}
+
+// AwaitBatch implements AwaitFDs and AwaitEvents.
+func (w *Watch) awaitBatch(buf awaitBuf, timeout time.Duration) (n int, err error) {
+	w.counters.await()
+	if buf.len() == 0 {
+		return 0, ErrEmptyBuffer
+	}
+	if !w.enter() {
+		return 0, ErrClosed
+	}
+	defer w.leave()
+	if err := w.takeExpiry(); err != nil {
+		return 0, err
+	}
+	if fd := w.pendingChild(); fd >= 0 {
+		w.counters.event(fd)
+		w.deadlineReady(fd)
+		w.put(buf, 0, fd, Read, -1)
+		return 1, nil
+	}
+	if w.ring != nil {
+		return w.ringAwaitFDs(buf, timeout)
+	}
+
+	// restarts continue with the remainder
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	// allocation only for large buffers
+	var stack [64]syscall.EpollEvent
+	events := stack[:]
+	if buf.len() > len(events) {
+		events = make([]syscall.EpollEvent, buf.len())
+	}
+	// ranks choose from a batch beyond capacity
+	wait := events
+	if !w.ranked() {
+		wait = events[:buf.len()]
+	}
+	for {
+		eventN := w.takeReadAhead(events[:buf.len()])
+		if eventN == 0 {
+			eventN, err = epollWait(w.epollFD, wait, timeout, nil)
+			if err != nil {
+				switch err {
+				case syscall.EINTR:
+					w.counters.restart()
+					timeout = remaining(timeout, deadline)
+					continue
+				case syscall.EBADF:
+					return 0, ErrClosed
+				}
+				return 0, w.counters.unavailable(fmt.Errorf("Watch unavailable due epoll_wait(2) error %w", err))
+			}
+			if w.isClosed() {
+				// Close wins from any results in the same instant.
+				return 0, ErrClosed
+			}
+			if eventN == 0 {
+				if timeout > epollMsecMax*time.Millisecond {
+					// wait was capped; continue with the remainder
+					timeout = remaining(timeout, deadline)
+					if timeout > 0 {
+						continue
+					}
+				}
+				w.counters.timeouts.Add(1)
 				return 0, ErrTimeout
 			}
-			break ReadEvents
-		case syscall.EINTR:
+		}
+		w.rankSort(events[:eventN])
+		if eventN > buf.len() {
+			w.keepReadAhead(events[buf.len():eventN])
+			eventN = buf.len()
+		}
+
+		for i := range events[:eventN] {
+			fd := int(events[i].Fd)
+			if fd == w.wakeFD {
+				err := w.woken()
+				if err != nil {
+					return 0, err
+				}
+				continue // wakeup taken by another routine
+			}
+			if w.isTimer(fd) && !readTimer(fd) {
+				continue // expiry taken by another routine
+			}
+			if w.stale(&events[i]) {
+				continue
+			}
+			if w.deadlineFired(fd) {
+				continue // for the next Await to report
+			}
+			ready := readyOf(events[i].Events)
+			if ready&Hangup != 0 && w.config.excludeOnHangup {
+				w.excludeHungUp(fd)
+			}
+			w.counters.event(fd)
+			w.deadlineReady(fd)
+			w.putReadable(buf, n, fd, ready)
+			n++
+		}
+		if n != 0 {
+			return n, nil
+		}
+		if timeout == 0 {
+			// stale registrations may report each time
+			w.counters.timeouts.Add(1)
+			return 0, ErrTimeout
+		}
+		timeout = remaining(timeout, deadline)
+	}
+}
+
+// Stale returns whether event is from a registration which left the watch list,
+// regardless of whether its file descriptor was included again. Wakeups and
+// timers have no generation.
+func (w *Watch) stale(event *syscall.EpollEvent) bool {
+	if event.Pad == 0 {
+		return false
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	_, ok := w.fds[int(event.Fd)]
+	return !ok || w.gens[int(event.Fd)] != event.Pad
+}
+
+// KeepReadAhead queues the events read beyond the first for the next Awaits.
+// Wakeups are level-triggered, i.e., they come back by themselves. The same
+// goes for any level-triggered registration, unless EventBatch reads ahead.
+func (w *Watch) keepReadAhead(events []syscall.EpollEvent) {
+	if len(events) == 0 {
+		return
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for i := range events {
+		fd := int(events[i].Fd)
+		if fd == w.wakeFD {
 			continue
-		case syscall.EBADF:
-			return 0, ErrClosed
 		}
-		return 0, fmt.Errorf("Watch unavailable due epoll_wait(2) error %w", err)
+		if w.config.eventBatch <= 1 && w.comesBack(fd) {
+			continue
+		}
+		w.readAhead = append(w.readAhead, events[i])
+	}
+}
+
+// ComesBack returns whether epoll(7) reports fd again by itself, i.e., whether
+// its registration is level-triggered. The mutex must be held.
+func (w *Watch) comesBack(fd int) bool {
+	if _, ok := w.timers[fd]; ok {
+		return true
+	}
+	interest, ok := w.fds[fd]
+	return ok && !w.config.oneShot && !w.edgeTriggered(interest)
+}
+
+// RankSort orders events by their rank from IncludeFDRank, highest first. Equal
+// ranks keep the order of the kernel.
+func (w *Watch) rankSort(events []syscall.EpollEvent) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if len(w.ranks) == 0 {
+		return
+	}
+	// insertion sort is stable, and it does not allocate
+	for i := 1; i < len(events); i++ {
+		e := events[i]
+		rank := w.ranks[int(e.Fd)]
+		j := i
+		for ; j > 0 && w.ranks[int(events[j-1].Fd)] < rank; j-- {
+			events[j] = events[j-1]
+		}
+		events[j] = e
+	}
+}
+
+// DropReadAhead discards any events pending for fd, such that a reuse of the
+// number does not get them. The mutex must be held.
+func (w *Watch) dropReadAhead(fd int) {
+	n := 0
+	for i := range w.readAhead {
+		if int(w.readAhead[i].Fd) != fd {
+			w.readAhead[n] = w.readAhead[i]
+			n++
+		}
+	}
+	w.readAhead = w.readAhead[:n]
+}
+
+// HasReadAhead returns whether any events are pending from keepReadAhead.
+func (w *Watch) hasReadAhead() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return len(w.readAhead) != 0
+}
+
+// TakeReadAhead fills events with those pending from keepReadAhead, if any. The
+// return is the number of events set. File descriptors which left the watch list
+// in the mean time are skipped.
+func (w *Watch) takeReadAhead(events []syscall.EpollEvent) (n int) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	i := 0
+	for ; i < len(w.readAhead) && n < len(events); i++ {
+		fd := int(w.readAhead[i].Fd)
+		_, onList := w.fds[fd]
+		_, isTimer := w.timers[fd]
+		if onList || isTimer {
+			events[n] = w.readAhead[i]
+			n++
+		}
+	}
+	w.readAhead = w.readAhead[:copy(w.readAhead, w.readAhead[i:])]
+	return n
+}
+
+// PutReadable is put with the Available hint from ioctl(2), as epoll(7) has no
+// byte count in its events.
+func (w *Watch) putReadable(buf awaitBuf, i, fd int, ready Interest) {
+	available := -1
+	if buf.fds == nil {
+		available = w.readable(fd, ready)
+	}
+	w.put(buf, i, fd, ready, available)
+}
+
+// Readable returns the number of bytes available for read on fd, or -1 when
+// unknown, as reported by the TIOCINQ ioctl(2), a.k.a. FIONREAD. Each of the
+// identifiers on epoll(7) is a file descriptor, including the timers.
+func (w *Watch) readable(fd int, ready Interest) int {
+	if ready&Read == 0 {
+		return -1
+	}
+	var n int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TIOCINQ, uintptr(unsafe.Pointer(&n)))
+	if errno != 0 {
+		return -1 // not supported by the file type
+	}
+	return int(n)
+}
+
+// EpollMsecMax is the limit of the epoll_wait(2) timeout, which is a C int.
+const epollMsecMax = math.MaxInt32
+
+// EpollMsec returns the epoll_wait(2) equivalent of timeout. Timeouts beyond
+// epollMsecMax are capped, which makes the wait return early.
+func epollMsec(timeout time.Duration) int {
+	if timeout < 0 {
+		return -1 // indefinite
+	}
+	// timeout rounds up as they are a minimum guarantee
+	msec := timeout / time.Millisecond
+	if timeout%time.Millisecond != 0 {
+		msec++
+	}
+	if msec > epollMsecMax {
+		return epollMsecMax
 	}
-	return int(buf[0].Fd), nil
+	return int(msec)
+}
+
+// ReadyOf maps epoll(7) events to their respective conditions.
+func readyOf(events uint32) Interest {
+	var ready Interest
+	// hang-ups and errors make read return without blocking
+	if events&(syscall.EPOLLIN|syscall.EPOLLHUP|syscall.EPOLLERR) != 0 {
+		ready |= Read
+	}
+	// EPOLLRDHUP only with DetectHalfClose
+	if events&(syscall.EPOLLHUP|syscall.EPOLLRDHUP) != 0 {
+		ready |= Hangup
+	}
+	if events&syscall.EPOLLPRI != 0 {
+		ready |= Priority
+	}
+	if events&syscall.EPOLLOUT != 0 {
+		ready |= Write
+	}
+	if events&syscall.EPOLLERR != 0 {
+		ready |= Error
+	}
+	return ready
 }
 
 // IncludeFD adds the file descriptor to the watch list. Duplicates are ignored
-// silently.
+// silently. Registrations in epoll(7) are for the file, rather than for the file
+// descriptor, which means that they outlive a close(2) without ExcludeFD when a
+// dup(2) keeps the file open. Their events are dropped with a generation number
+// in the user data, such that recycled file descriptors do not get them. Such
+// registrations keep reporting until the file is closed, which costs a wakeup on
+// each occasion.
 func (w *Watch) IncludeFD(fd int) error {
-	event := syscall.EpollEvent{
-		Fd:     int32(fd),
-		Events: syscall.EPOLLIN,
+	return w.include(fd, Read)
+}
+
+// IncludeFDForPriority adds the file descriptor to the watch list for both read
+// and Priority availability. Descriptors already on the watch list get their
+// Priority interest added.
+func (w *Watch) IncludeFDForPriority(fd int) error {
+	return w.include(fd, Read|Priority)
+}
+
+// IncludeFDForWrite adds the file descriptor to the watch list for Write
+// availability. Descriptors already on the watch list get their Write interest
+// added. Write availability is level-triggered too, i.e., a file descriptor
+// reports for as long as its buffer has space, which is most of the time.
+func (w *Watch) IncludeFDForWrite(fd int) error {
+	return w.include(fd, Write)
+}
+
+// Include adds interest to the watch list.
+func (w *Watch) include(fd int, interest Interest) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	return w.counters.include("include", fd, interest, w.add(fd, interest|w.fds[fd]))
+}
+
+// IncludeFDInterest sets the conditions of interest for the file descriptor,
+// which replaces any interest from before. Hangup arms EPOLLRDHUP, as with the
+// DetectHalfClose option. The Edge and Level modes select EPOLLET for the file
+// descriptor, over the EdgeTriggered option. The modes have no effect with
+// IOURing. Zero interest is equivalent to ExcludeFD.
+func (w *Watch) IncludeFDInterest(fd int, interest Interest) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	if interest&(Read|Priority|Hangup|Write) == 0 {
+		return w.exclude(fd)
+	}
+	return w.counters.include("include", fd, interest, w.add(fd, interest|w.fds[fd]&paused))
+}
+
+// IncludeFDs adds each file descriptor to the watch list like IncludeFD does.
+// Failures do not stop the other file descriptors from inclusion. The errors
+// are joined, with an *FDError for each file descriptor rejected.
+func (w *Watch) IncludeFDs(fds ...int) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+
+	// epoll_ctl(2) has no batch option
+	var errs []error
+	for _, fd := range fds {
+		err := w.counters.include("include", fd, Read, w.add(fd, Read|w.fds[fd]))
+		if err != nil {
+			errs = append(errs, &FDError{FD: fd, Err: err})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Add applies interest to the watch list, which replaces any interest from
+// before. The mutex must be held.
+func (w *Watch) add(fd int, interest Interest) error {
+	if interest&paused != 0 {
+		// registration is up to ResumeFD
+		w.fds[fd] = interest
+		return nil
 	}
+	if w.ring != nil {
+		return w.ringAdd(fd, interest)
+	}
+	event := w.epollEvent(fd, interest)
+	// New registrations get a new generation, as events from any
+	// registration before are stale.
+	w.genNext++
+	if w.genNext == 0 {
+		w.genNext++ // zero is for wakeups and timers
+	}
+	event.Pad = w.genNext
 	err := syscall.EpollCtl(w.epollFD, syscall.EPOLL_CTL_ADD, fd, &event)
+	if err == syscall.EEXIST {
+		if w.config.exclusiveWakeup {
+			// EPOLLEXCLUSIVE is not allowed with EPOLL_CTL_MOD
+			var ignored syscall.EpollEvent
+			syscall.EpollCtl(w.epollFD, syscall.EPOLL_CTL_DEL, fd, &ignored)
+			err = syscall.EpollCtl(w.epollFD, syscall.EPOLL_CTL_ADD, fd, &event)
+		} else {
+			event.Pad = w.gens[fd] // same registration
+			err = syscall.EpollCtl(w.epollFD, syscall.EPOLL_CTL_MOD, fd, &event)
+		}
+	}
 	switch err {
-	case nil, syscall.EEXIST:
+	case nil:
+		w.gens[fd] = event.Pad
+		w.fds[fd] = interest
 		return nil
 	case syscall.EPERM:
-		return ErrWatchable
+		return w.addRegular(fd, interest)
 	case syscall.EBADF:
+		return ErrBadFD
+	case syscall.ENOSPC, syscall.ENOMEM:
+		// ENOSPC is the limit of max_user_watches
+		return ErrTooManyWatches
+	}
+	return opError("IncludeFD", fd, err)
+}
+
+// ModifyFD sets the conditions of interest for a file descriptor on the watch
+// list, which replaces any interest from before, like IncludeFDInterest does.
+// EPOLL_CTL_MOD applies the change, such that the file descriptor does not
+// leave the watch list in between. The ExclusiveWakeup option is an exception,
+// because EPOLLEXCLUSIVE is not allowed with EPOLL_CTL_MOD. Absence from the
+// watch list gets ErrNotWatched. Zero interest is equivalent to ExcludeFD.
+func (w *Watch) ModifyFD(fd int, interest Interest) error {
+	return w.counters.include("modify", fd, interest, w.modify(fd, interest))
+}
+
+// Modify is ModifyFD without accounting.
+func (w *Watch) modify(fd int, interest Interest) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	before, ok := w.fds[fd]
+	if !ok {
+		return ErrNotWatched
+	}
+	if interest&(Read|Priority|Hangup|Write) == 0 {
+		return w.exclude(fd)
+	}
+	if before&paused != 0 {
+		// registration is up to ResumeFD
+		w.fds[fd] = interest | paused
+		return nil
+	}
+	if w.config.exclusiveWakeup || w.ring != nil || w.regular[fd] != nil {
+		return w.add(fd, interest)
+	}
+
+	event := w.epollEvent(fd, interest)
+	err := syscall.EpollCtl(w.epollFD, syscall.EPOLL_CTL_MOD, fd, &event)
+	switch err {
+	case nil:
+		w.fds[fd] = interest
+		return nil
+	case syscall.ENOENT:
+		// closed files leave epoll(7) automatically
+		delete(w.fds, fd)
+		delete(w.tokens, fd)
+		delete(w.ranks, fd)
+		return ErrNotWatched
+	case syscall.EBADF:
+		delete(w.fds, fd)
+		delete(w.tokens, fd)
+		delete(w.ranks, fd)
+		return ErrBadFD
+	case syscall.ENOMEM:
+		return ErrTooManyWatches
+	}
+	return opError("ModifyFD", fd, err)
+}
+
+// EpollEvent returns the registration of fd with interest, including the flags
+// from config.
+func (w *Watch) epollEvent(fd int, interest Interest) syscall.EpollEvent {
+	event := syscall.EpollEvent{
+		Fd:     int32(fd),
+		Pad:    w.gens[fd], // second half of the user data
+		Events: epollEvents(interest),
+	}
+	if w.config.detectHalfClose {
+		event.Events |= syscall.EPOLLRDHUP
+	}
+	if w.config.exclusiveWakeup {
+		event.Events |= epollExclusive
+	}
+	if w.edgeTriggered(interest) {
+		event.Events |= epollET
+	}
+	if w.config.oneShot {
+		event.Events |= syscall.EPOLLONESHOT
+	}
+	return event
+}
+
+// EpollExclusive is EPOLLEXCLUSIVE from Linux 4.5, which is missing in package
+// syscall.
+const epollExclusive = 1 << 28
+
+// EpollET is EPOLLET, which package syscall has as a negative number on some
+// platforms.
+const epollET = 1 << 31
+
+// EpollEvents maps conditions to their respective epoll(7) events.
+func epollEvents(interest Interest) uint32 {
+	var events uint32
+	if interest&Read != 0 {
+		events |= syscall.EPOLLIN
+	}
+	if interest&Priority != 0 {
+		events |= syscall.EPOLLPRI
+	}
+	if interest&Write != 0 {
+		events |= syscall.EPOLLOUT
+	}
+	if interest&Hangup != 0 {
+		events |= syscall.EPOLLRDHUP
+	}
+	return events
+}
+
+// PauseFD mutes a file descriptor on the watch list until ResumeFD, without loss
+// of its interest, e.g., for backpressure on a chatty peer. Inclusion and
+// ModifyFD on a paused file descriptor apply with ResumeFD. EPOLL_CTL_MOD can not
+// mute hang-ups and errors, which is why the registration leaves epoll(7) in the
+// mean time. Absence from the watch list gets ErrNotWatched.
+func (w *Watch) PauseFD(fd int) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	interest, ok := w.fds[fd]
+	if !ok {
+		return ErrNotWatched
+	}
+	if interest&paused != 0 {
+		return nil
+	}
+
+	if w.ring != nil {
+		err := w.ring.pollRemove(fd)
+		if err != nil {
+			return opError("PauseFD", fd, err)
+		}
+	} else {
+		target := fd
+		if f, ok := w.regular[fd]; ok {
+			target = f.eventFD
+		}
+		// event is ignored, yet it may not be nil on old kernels
+		var event syscall.EpollEvent
+		err := syscall.EpollCtl(w.epollFD, syscall.EPOLL_CTL_DEL, target, &event)
+		switch err {
+		case nil:
+			break
+		case syscall.ENOENT:
+			// closed files leave epoll(7) automatically
+			delete(w.fds, fd)
+			delete(w.tokens, fd)
+			delete(w.ranks, fd)
+			return ErrNotWatched
+		case syscall.EBADF:
+			delete(w.fds, fd)
+			delete(w.tokens, fd)
+			delete(w.ranks, fd)
+			return ErrBadFD
+		default:
+			return opError("PauseFD", fd, err)
+		}
+		w.dropReadAhead(fd)
+	}
+	w.fds[fd] = interest | paused
+	return nil
+}
+
+// ResumeFD undoes PauseFD. File descriptors which are not paused are ignored
+// silently. Absence from the watch list gets ErrNotWatched.
+func (w *Watch) ResumeFD(fd int) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
 		return ErrClosed
 	}
-	return fmt.Errorf("Watch include of file lost on epoll_ctl(2) error %w", err)
+	interest, ok := w.fds[fd]
+	if !ok {
+		return ErrNotWatched
+	}
+	if interest&paused == 0 {
+		return nil
+	}
+	interest &^= paused
+
+	if f, ok := w.regular[fd]; ok && w.ring == nil {
+		// the eventfd(2) keeps its count in the mean time
+		event := w.epollEvent(fd, interest)
+		err := syscall.EpollCtl(w.epollFD, syscall.EPOLL_CTL_ADD, f.eventFD, &event)
+		if err != nil {
+			return opError("ResumeFD", fd, err)
+		}
+		w.fds[fd] = interest
+		return nil
+	}
+	return w.add(fd, interest)
+}
+
+// ExcludeFDs removes each file descriptor from the watch list like ExcludeFD
+// does. Failures do not stop the other file descriptors from exclusion. The
+// errors are joined, with an *FDError for each file descriptor rejected.
+func (w *Watch) ExcludeFDs(fds ...int) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+
+	// epoll_ctl(2) has no batch option
+	var errs []error
+	for _, fd := range fds {
+		err := w.exclude(fd)
+		if err != nil {
+			errs = append(errs, &FDError{FD: fd, Err: err})
+		}
+	}
+	return errors.Join(errs...)
 }
 
 // ExcludeFD removes the file descriptor from the watch list. Absence is ignored
 // silently.
 func (w *Watch) ExcludeFD(fd int) error {
-	event := syscall.EpollEvent{
-		Fd:     int32(fd),
-		Events: syscall.EPOLLIN,
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	return w.counters.exclude(fd, w.exclude(fd))
+}
+
+// Exclude removes fd from the watch list. The mutex must be held.
+func (w *Watch) exclude(fd int) error {
+	if w.ring != nil {
+		return w.ringExclude(fd)
 	}
+	w.dropReadAhead(fd)
+	if w.excludeRegular(fd) {
+		return nil
+	}
+	// event is ignored, yet it may not be nil on old kernels
+	var event syscall.EpollEvent
 	err := syscall.EpollCtl(w.epollFD, syscall.EPOLL_CTL_DEL, fd, &event)
 	switch err {
 	case nil, syscall.ENOENT:
+		delete(w.fds, fd)
+		delete(w.tokens, fd)
+		delete(w.ranks, fd)
 		return nil
 	case syscall.EPERM:
 		// not documented whether this can happen
 		return ErrWatchable
 	case syscall.EBADF:
+		// closed files leave epoll(7) automatically
+		delete(w.fds, fd)
+		delete(w.tokens, fd)
+		delete(w.ranks, fd)
+		return ErrBadFD
+	}
+	return opError("ExcludeFD", fd, err)
+}
+
+// Forget removes fd from the watch list after close(2). The mutex must be held.
+func (w *Watch) forget(fd int) {
+	// EPOLL_CTL_DEL gets EBADF, and the regular files and io_uring(7)
+	// have resources of their own
+	w.exclude(fd)
+	delete(w.fds, fd)
+	delete(w.tokens, fd)
+	delete(w.ranks, fd)
+}
+
+// InternalFD returns whether fd on the watch list belongs to the Watch, rather
+// than to the application. The mutex must be held.
+func (w *Watch) internalFD(fd int) bool {
+	_, isPath := w.paths[fd]
+	_, isProc := w.procs[fd]
+	_, isSignals := w.signals[fd]
+	_, isChild := w.children[fd]
+	return isPath || isProc || isSignals || isChild
+}
+
+// Reset removes all file descriptors and timers from the watch list, which is
+// equivalent to a new OpenWatch, only cheaper. Any deadlines from SetFDDeadline,
+// children from IncludeWatch, listeners from IncludeListener, and tokens pending
+// from ExcludeOnHangup go too.
+func (w *Watch) Reset() error {
+	w.deadlines.mutex.Lock()
+	defer w.deadlines.mutex.Unlock()
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
 		return ErrClosed
 	}
-	return fmt.Errorf("Watch exclude of file lost on epoll_ctl(2) error %w", err)
+	w.resetDeadlines()
+
+	var firstErr error
+	for fd := range w.fds {
+		if w.ring != nil {
+			err := w.ringExclude(fd)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if w.excludeRegular(fd) {
+			continue
+		}
+		var event syscall.EpollEvent
+		err := syscall.EpollCtl(w.epollFD, syscall.EPOLL_CTL_DEL, fd, &event)
+		switch err {
+		case nil, syscall.ENOENT, syscall.EBADF:
+			// closed files leave epoll(7) automatically
+			delete(w.fds, fd)
+			delete(w.tokens, fd)
+			delete(w.ranks, fd)
+		default:
+			if firstErr == nil {
+				firstErr = opError("Reset", fd, err)
+			}
+		}
+	}
+	for fd := range w.timers {
+		if w.ring != nil {
+			w.ring.pollRemove(fd)
+		}
+		// close(2) removes the descriptor from epoll(7) too
+		syscall.Close(fd)
+		delete(w.timers, fd)
+	}
+	w.resetAssociations()
+	w.closePaths()
+	w.closeProcesses()
+	w.closeSignals()
+	w.readAhead = w.readAhead[:0]
+	w.ringErr = nil
+	return firstErr
+}
+
+// AddTimer arms a new timer. Expiry is reported by the Await methods with the
+// timer identifier in place of a file descriptor. Periodic timers repeat with
+// d as their interval. Multiple expiries in between Awaits are reported only
+// once. Use RemoveTimer to release the timer, including the one-shot kind.
+func (w *Watch) AddTimer(d time.Duration, periodic bool) (id int, err error) {
+	if d <= 0 {
+		return -1, fmt.Errorf("Watch AddTimer got non-positive duration %s", d)
+	}
+
+	const flags = syscall.O_NONBLOCK | syscall.O_CLOEXEC // TFD_NONBLOCK | TFD_CLOEXEC
+	r1, _, errno := syscall.RawSyscall(syscall.SYS_TIMERFD_CREATE, 1 /* CLOCK_MONOTONIC */, flags, 0)
+	if errno != 0 {
+		return -1, fmt.Errorf("Watch AddTimer lost on timerfd_create(2) error %w", errno)
+	}
+	fd := int(r1)
+
+	// struct itimerspec
+	spec := [2]syscall.Timespec{1: syscall.NsecToTimespec(int64(d))}
+	if periodic {
+		spec[0] = spec[1]
+	}
+	_, _, errno = syscall.RawSyscall6(syscall.SYS_TIMERFD_SETTIME, uintptr(fd), 0,
+		uintptr(unsafe.Pointer(&spec)), 0, 0, 0)
+	if errno != 0 {
+		syscall.Close(fd)
+		return -1, fmt.Errorf("Watch AddTimer lost on timerfd_settime(2) error %w", errno)
+	}
+
+	event := syscall.EpollEvent{
+		Fd:     int32(fd),
+		Events: syscall.EPOLLIN,
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		syscall.Close(fd)
+		return -1, ErrClosed
+	}
+	if w.ring != nil {
+		err = w.ring.pollAdd(fd, syscall.EPOLLIN, true)
+	} else {
+		err = syscall.EpollCtl(w.epollFD, syscall.EPOLL_CTL_ADD, fd, &event)
+	}
+	if err != nil {
+		syscall.Close(fd)
+		if err == syscall.EBADF {
+			return -1, ErrClosed
+		}
+		facility, _ := w.kernelFD()
+		return -1, fmt.Errorf("Watch AddTimer lost on %s error %w", facility, err)
+	}
+	w.timers[fd] = struct{}{}
+	return fd, nil
+}
+
+// RemoveTimer disarms and releases a timer from AddTimer. Absence is ignored
+// silently.
+func (w *Watch) RemoveTimer(id int) error {
+	w.mutex.Lock()
+	if w.closed {
+		w.mutex.Unlock()
+		return ErrClosed
+	}
+	_, ok := w.timers[id]
+	if w.isDeadlineTimer(id) {
+		ok = false // internal to SetFDDeadline
+	}
+	if ok {
+		delete(w.timers, id)
+	}
+	if ok && w.ring != nil {
+		// polls hold on to the file
+		w.ring.pollRemove(id)
+	}
+	w.mutex.Unlock()
+	if !ok {
+		return nil
+	}
+
+	// close(2) removes the descriptor from epoll(7) too
+	err := syscall.Close(id)
+	if err != nil {
+		return fmt.Errorf("Watch RemoveTimer stuck on close(2) of timerfd(2) error %w", err)
+	}
+	return nil
+}
+
+// IsTimer returns whether the file descriptor is one from AddTimer.
+func (w *Watch) isTimer(fd int) bool {
+	w.mutex.Lock()
+	_, ok := w.timers[fd]
+	w.mutex.Unlock()
+	return ok
+}
+
+// ReadTimer acknowledges any expiries on a timerfd(2). The return is false when
+// no expiry was pending.
+func readTimer(fd int) bool {
+	var buf [8]byte // expiry count
+	for {
+		_, err := syscall.Read(fd, buf[:])
+		if err != syscall.EINTR {
+			return err == nil
+		}
+	}
 }
@@ -0,0 +1,67 @@
+//go:build linux
+
+package fdmom
+
+import (
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Pwait2Missing caches the absence of epoll_pwait2(2), which needs Linux 5.11.
+var pwait2Missing atomic.Bool
+
+// KernelTimespec is struct __kernel_timespec from <linux/time_types.h>, which
+// has 64-bit fields on each architecture, as opposed to syscall.Timespec.
+type kernelTimespec struct {
+	Sec  int64
+	Nsec int64
+}
+
+// NsecToKernelTimespec converts a number of nanoseconds into a kernelTimespec.
+func nsecToKernelTimespec(nsec int64) kernelTimespec {
+	return kernelTimespec{Sec: nsec / 1e9, Nsec: nsec % 1e9}
+}
+
+// EpollWait is epoll_wait(2) with nanosecond precision on the timeout when
+// epoll_pwait2(2) is available. Negative timeouts block indefinitely. A signal
+// mask other than nil applies for the duration of the wait.
+func epollWait(epollFD int, events []syscall.EpollEvent, timeout time.Duration, sigmask *sigset) (n int, err error) {
+	var sigsetSize uintptr
+	if sigmask != nil {
+		sigsetSize = unsafe.Sizeof(*sigmask)
+	}
+
+	if !pwait2Missing.Load() {
+		var tsp *kernelTimespec // indefinite
+		var ts kernelTimespec
+		if timeout >= 0 {
+			ts = nsecToKernelTimespec(int64(timeout))
+			tsp = &ts
+		}
+		r1, _, errno := syscall.Syscall6(sysEpollPwait2, uintptr(epollFD),
+			uintptr(unsafe.Pointer(&events[0])), uintptr(len(events)),
+			uintptr(unsafe.Pointer(tsp)), uintptr(unsafe.Pointer(sigmask)), sigsetSize)
+		switch errno {
+		case 0:
+			return int(r1), nil
+		default:
+			return 0, errno
+		case syscall.ENOSYS, syscall.EPERM:
+			// EPERM comes from seccomp(2) filters unaware of the call
+			pwait2Missing.Store(true)
+		}
+	}
+
+	if sigmask == nil {
+		return syscall.EpollWait(epollFD, events, epollMsec(timeout))
+	}
+	r1, _, errno := syscall.Syscall6(syscall.SYS_EPOLL_PWAIT, uintptr(epollFD),
+		uintptr(unsafe.Pointer(&events[0])), uintptr(len(events)),
+		uintptr(epollMsec(timeout)), uintptr(unsafe.Pointer(sigmask)), sigsetSize)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(r1), nil
+}
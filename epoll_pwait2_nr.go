@@ -0,0 +1,6 @@
+//go:build linux && !(mips || mipsle || mips64 || mips64le)
+
+package fdmom
+
+// SysEpollPwait2 is the system call number, which is missing in package syscall.
+const sysEpollPwait2 = 441
@@ -0,0 +1,7 @@
+//go:build linux && (mips64 || mips64le)
+
+package fdmom
+
+// SysEpollPwait2 is the system call number for the n64 ABI, which is missing in
+// package syscall.
+const sysEpollPwait2 = 5441
@@ -0,0 +1,7 @@
+//go:build linux && (mips || mipsle)
+
+package fdmom
+
+// SysEpollPwait2 is the system call number for the o32 ABI, which is missing in
+// package syscall.
+const sysEpollPwait2 = 4441
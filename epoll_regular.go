@@ -0,0 +1,140 @@
+//go:build linux
+
+package fdmom
+
+import (
+	"fmt"
+	"io"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// RegularCheckInterval is the delay between checks of regular files.
+const regularCheckInterval = 10 * time.Millisecond
+
+// RegularFile is the fallback for a regular file, which epoll(7) rejects with
+// EPERM. An eventfd(2) takes its place in epoll(7), with the file descriptor of
+// the regular file as its event data. Thus, Await reports the regular file
+// directly. Regular files are always ready for Write. They are ready for Read
+// when the file offset is before the end of the file, like kqueue(2) does, as
+// polled by checkRegulars.
+type regularFile struct {
+	eventFD  int  // eventfd(2) on the watch list
+	readable bool // eventfd(2) counter is non-zero
+}
+
+// AddRegular includes fd with the fallback when it is a regular file. The
+// return is ErrWatchable for other types of file. The mutex must be held.
+func (w *Watch) addRegular(fd int, interest Interest) error {
+	var stat syscall.Stat_t
+	err := syscall.Fstat(fd, &stat)
+	if err != nil {
+		if err == syscall.EBADF {
+			return ErrBadFD
+		}
+		return fmt.Errorf("Watch include of file lost on fstat(2) error %w", err)
+	}
+	if stat.Mode&syscall.S_IFMT != syscall.S_IFREG {
+		return ErrWatchable
+	}
+
+	event := w.epollEvent(fd, interest)
+	if f, ok := w.regular[fd]; ok {
+		err := syscall.EpollCtl(w.epollFD, syscall.EPOLL_CTL_MOD, f.eventFD, &event)
+		if err != nil {
+			return fmt.Errorf("Watch include of regular file lost on epoll_ctl(2) error %w", err)
+		}
+		w.fds[fd] = interest
+		return nil
+	}
+
+	const flags = syscall.O_NONBLOCK | syscall.O_CLOEXEC // EFD_NONBLOCK | EFD_CLOEXEC
+	r1, _, errno := syscall.RawSyscall(syscall.SYS_EVENTFD2, 0, flags, 0)
+	if errno != 0 {
+		return fmt.Errorf("Watch include of regular file lost on eventfd(2) error %w", errno)
+	}
+	f := &regularFile{eventFD: int(r1)}
+	err = syscall.EpollCtl(w.epollFD, syscall.EPOLL_CTL_ADD, f.eventFD, &event)
+	if err != nil {
+		syscall.Close(f.eventFD)
+		if err == syscall.ENOSPC || err == syscall.ENOMEM {
+			return ErrTooManyWatches
+		}
+		return fmt.Errorf("Watch include of regular file lost on epoll_ctl(2) error %w", err)
+	}
+	f.check(fd)
+
+	if w.regular == nil {
+		w.regular = make(map[int]*regularFile)
+		go w.checkRegulars()
+	}
+	w.regular[fd] = f
+	w.fds[fd] = interest
+	return nil
+}
+
+// ExcludeRegular removes fd from the fallback. The return is false when fd was
+// not on the fallback. The mutex must be held.
+func (w *Watch) excludeRegular(fd int) bool {
+	f, ok := w.regular[fd]
+	if !ok {
+		return false
+	}
+	// close(2) removes the descriptor from epoll(7) too
+	syscall.Close(f.eventFD)
+	delete(w.regular, fd)
+	delete(w.fds, fd)
+	delete(w.tokens, fd)
+	delete(w.ranks, fd)
+	return true
+}
+
+// CheckRegulars updates the fallback for regular files until Close. A single
+// routine serves all regular files of the Watch, with a check each interval.
+// Blocking reader threads are of no use, as read(2) on a regular file never
+// blocks, not even at the end of the file, and they would consume the data on
+// top of that. Polling does delay readiness from growth by up to one interval,
+// and it costs a wakeup per interval for as long as any regular file is on the
+// watch list.
+func (w *Watch) checkRegulars() {
+	ticker := time.NewTicker(regularCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			break
+		}
+
+		w.mutex.Lock()
+		for fd, f := range w.regular {
+			f.check(fd)
+		}
+		w.mutex.Unlock()
+	}
+}
+
+// Check sets the eventfd(2) to the readability of the regular file.
+func (f *regularFile) check(fd int) {
+	readable := true // errors are for read(2) to report
+	offset, err := syscall.Seek(fd, 0, io.SeekCurrent)
+	if err == nil {
+		var stat syscall.Stat_t
+		if syscall.Fstat(fd, &stat) == nil {
+			readable = offset < stat.Size
+		}
+	}
+
+	var counter uint64
+	buf := (*[8]byte)(unsafe.Pointer(&counter))[:]
+	switch {
+	case readable && !f.readable:
+		counter = 1
+		syscall.Write(f.eventFD, buf)
+	case !readable && f.readable:
+		syscall.Read(f.eventFD, buf)
+	}
+	f.readable = readable
+}
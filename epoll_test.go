@@ -0,0 +1,375 @@
+//go:build linux
+
+package fdmom
+
+import (
+	"errors"
+	"math"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// InterruptThreads sends SIGURG to each thread of the process.
+func interruptThreads(t *testing.T) {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		t.Error("thread listing from procfs:", err)
+		return
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			t.Error("thread ID from procfs:", err)
+			continue
+		}
+		// thread may have exited in the mean time
+		syscall.Tgkill(os.Getpid(), tid, syscall.SIGURG)
+	}
+}
+
+func TestWatchCloseOnExec(t *testing.T) {
+	w, err := OpenWatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	for _, fd := range []int{w.epollFD, w.wakeFD} {
+		flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_GETFD, 0)
+		if errno != 0 {
+			t.Fatal("fcntl(2) error:", errno)
+		}
+		if flags&syscall.FD_CLOEXEC == 0 {
+			t.Errorf("FD %#x has no FD_CLOEXEC", fd)
+		}
+	}
+}
+
+func TestWatchKeepOnExec(t *testing.T) {
+	w, err := OpenWatch(KeepOnExec())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(w.epollFD), syscall.F_GETFD, 0)
+	if errno != 0 {
+		t.Fatal("fcntl(2) error:", errno)
+	}
+	if flags&syscall.FD_CLOEXEC != 0 {
+		t.Error("queue FD has FD_CLOEXEC")
+	}
+	for _, fd := range []int{w.wakeFD} {
+		flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_GETFD, 0)
+		if errno != 0 {
+			t.Fatal("fcntl(2) error:", errno)
+		}
+		if flags&syscall.FD_CLOEXEC == 0 {
+			t.Errorf("wakeup FD %#x has no FD_CLOEXEC", fd)
+		}
+	}
+}
+
+func TestEpollMsec(t *testing.T) {
+	tests := []struct {
+		timeout time.Duration
+		want    int
+	}{
+		{-1, -1},
+		{0, 0},
+		{1, 1},
+		{time.Millisecond, 1},
+		{time.Millisecond + 1, 2},
+		{24 * time.Hour, 86400000},
+		{30 * 24 * time.Hour, math.MaxInt32},
+		{math.MaxInt64, math.MaxInt32},
+	}
+	for _, test := range tests {
+		if got := epollMsec(test.timeout); got != test.want {
+			t.Errorf("epollMsec(%s) got %d, want %d", test.timeout, got, test.want)
+		}
+	}
+}
+
+func TestWatchSubMillisecond(t *testing.T) {
+	p := newPipe(t)
+
+	const timeout = 100 * time.Microsecond
+	var best time.Duration = math.MaxInt64
+	for i := 0; i < 5; i++ {
+		_, waited, err := p.Watch.AwaitFDWithReadTimed(timeout)
+		if err != ErrTimeout {
+			t.Fatalf("got error %v, want ErrTimeout", err)
+		}
+		if waited < timeout {
+			t.Errorf("waited %s, want at least %s", waited, timeout)
+		}
+		if waited < best {
+			best = waited
+		}
+	}
+	if pwait2Missing.Load() {
+		t.Skip("no epoll_pwait2(2) on this system")
+	}
+	if best >= time.Millisecond {
+		t.Errorf("best wait of %s exceeds a millisecond with epoll_pwait2(2)", best)
+	}
+}
+
+// TestEpollWaitFallback runs sequentially, as it flips the detection of
+// epoll_pwait2(2) for the entire process.
+func TestEpollWaitFallback(t *testing.T) {
+	epollFD, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		t.Fatal("epoll_create1(2) error:", err)
+	}
+	defer syscall.Close(epollFD)
+
+	missing := pwait2Missing.Load()
+	pwait2Missing.Store(true)
+	defer pwait2Missing.Store(missing)
+
+	var events [1]syscall.EpollEvent
+	const timeout = 100 * time.Microsecond
+	start := time.Now()
+	n, err := epollWait(epollFD, events[:], timeout, nil)
+	waited := time.Since(start)
+	if err != nil {
+		t.Fatal("epoll wait error:", err)
+	}
+	if n != 0 {
+		t.Fatalf("got %d events, want none", n)
+	}
+	// rounds up to a millisecond without epoll_pwait2(2)
+	if waited < time.Millisecond {
+		t.Errorf("waited %s, want at least a millisecond", waited)
+	}
+
+	var sigmask sigset
+	n, err = epollWait(epollFD, events[:], 0, &sigmask)
+	if err != nil {
+		t.Fatal("epoll wait with signal mask error:", err)
+	}
+	if n != 0 {
+		t.Fatalf("got %d events with signal mask, want none", n)
+	}
+}
+
+func TestIncludeTimerSubMillisecond(t *testing.T) {
+	p := newPipe(t)
+	const interval = 250 * time.Microsecond
+	id, err := p.Watch.IncludeTimer(interval, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Watch.RemoveTimer(id)
+
+	var best time.Duration = math.MaxInt64
+	last := time.Now()
+	for i := 0; i < 5; i++ {
+		got, err := p.Watch.AwaitFDWithRead(holdupMax)
+		if err != nil || got != id {
+			t.Fatalf("got FD %#x with error %v, want timer %#x", got, err, id)
+		}
+		now := time.Now()
+		if d := now.Sub(last); d < best {
+			best = d
+		}
+		last = now
+	}
+	if best >= time.Millisecond {
+		t.Errorf("best interval of %s exceeds a millisecond", best)
+	}
+}
+
+// A registration which outlives its file descriptor number, by means of a
+// duplicate, keeps reporting. Non-blocking Awaits must look past it.
+func TestWatchStaleNonBlocking(t *testing.T) {
+	p := newPipe(t)
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	_, err = w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+
+	staleFD, err := syscall.Dup(int(r.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Watch.IncludeFD(staleFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ready before the pipe of p
+	fd, err := p.Watch.AwaitFDWithRead(0)
+	if err != nil || fd != staleFD {
+		t.Fatalf("got FD %#x with error %v, want FD %#x", fd, err, staleFD)
+	}
+	// registration stays with the file of r
+	syscall.Close(staleFD)
+	p.Watch.ExcludeFD(staleFD)
+
+	err = p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	for i := 0; i < 3; i++ {
+		fd, err = p.Watch.AwaitFDWithRead(0)
+		if err != nil || fd != p.rFD {
+			t.Errorf("got FD %#x with error %v next to a stale registration, want FD %#x",
+				fd, err, p.rFD)
+		}
+	}
+}
+
+func TestWatchRegularFile(t *testing.T) {
+	p := newPipe(t)
+	f, err := os.CreateTemp(t.TempDir(), "regular")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fd := int(f.Fd())
+	err = p.Watch.IncludeFDInterest(fd, Read|Write)
+	if err != nil {
+		t.Fatal("include of regular file:", err)
+	}
+
+	got, ready, err := p.Watch.AwaitFD(holdupMax)
+	if err != nil || got != fd || ready != Write {
+		t.Fatalf("got FD %#x ready %s with error %v when empty, want FD %#x ready Write",
+			got, ready, err, fd)
+	}
+	err = p.Watch.ModifyFD(fd, Read)
+	if err != nil {
+		t.Fatal("modify of regular file:", err)
+	}
+	got, err = p.Watch.AwaitFDWithRead(4 * regularCheckInterval)
+	if err != ErrTimeout {
+		t.Errorf("got FD %#x with error %v at end of file, want ErrTimeout", got, err)
+	}
+
+	// append with a distinct offset
+	other, err := os.OpenFile(f.Name(), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	_, err = other.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	got, err = p.Watch.AwaitFDWithRead(holdupMax)
+	if err != nil || got != fd {
+		t.Fatalf("got FD %#x with error %v after append, want FD %#x", got, err, fd)
+	}
+
+	err = p.Watch.IncludeFDRank(fd, 7)
+	if err != nil {
+		t.Fatal("rank of regular file:", err)
+	}
+	err = p.Watch.ExcludeFD(fd)
+	if err != nil {
+		t.Fatal("exclude of regular file:", err)
+	}
+	got, err = p.Watch.AwaitFDWithRead(0)
+	if err != ErrTimeout {
+		t.Errorf("got FD %#x with error %v after exclude, want ErrTimeout", got, err)
+	}
+	p.Watch.mutex.Lock()
+	_, hasRank := p.Watch.ranks[fd]
+	p.Watch.mutex.Unlock()
+	if hasRank {
+		t.Error("rank of regular file remains after exclude")
+	}
+
+	dir, err := os.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Close()
+	err = p.Watch.IncludeFD(int(dir.Fd()))
+	if err != ErrWatchable {
+		t.Errorf("include of directory got error %v, want ErrWatchable", err)
+	}
+}
+
+func TestWatchRecycledFD(t *testing.T) {
+	p := newPipe(t)
+	err := p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// keep the file of the read end open
+	dup, err := syscall.Dup(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(dup)
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+
+	// recycle the number without ExcludeFD
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	err = syscall.Dup3(int(r.Fd()), p.rFD, syscall.O_CLOEXEC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := p.Watch.AwaitFDWithRead(10 * time.Millisecond)
+	if err != ErrTimeout {
+		t.Errorf("got FD %#x with error %v from the file before, want ErrTimeout", got, err)
+	}
+	_, err = w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	got, err = p.Watch.AwaitFDWithRead(holdupMax)
+	if err != nil || got != p.rFD {
+		t.Errorf("got FD %#x with error %v, want FD %#x", got, err, p.rFD)
+	}
+}
+
+func TestWatchError(t *testing.T) {
+	p := newPipe(t)
+
+	// epoll(7) can not watch itself
+	err := p.Watch.IncludeFD(p.Watch.epollFD)
+	var watchErr *WatchError
+	if !errors.As(err, &watchErr) {
+		t.Fatalf("got error %v, want a *WatchError", err)
+	}
+	if watchErr.Op != "IncludeFD" || watchErr.FD != p.Watch.epollFD || watchErr.Errno != syscall.EINVAL {
+		t.Errorf("got %+v, want IncludeFD of file descriptor %d with EINVAL", *watchErr, p.Watch.epollFD)
+	}
+	if !errors.Is(err, syscall.EINVAL) {
+		t.Error("errors.Is does not match EINVAL")
+	}
+	want := "fdmom Watch IncludeFD of file descriptor " + strconv.Itoa(p.Watch.epollFD) + ": invalid argument"
+	if got := err.Error(); got != want {
+		t.Errorf("got message %q, want %q", got, want)
+	}
+}
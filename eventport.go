@@ -0,0 +1,400 @@
+//go:build solaris
+
+package fdmom
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Event port functions are missing in package syscall.
+//
+//go:cgo_import_dynamic libc_port_create port_create "libc.so"
+//go:cgo_import_dynamic libc_port_associate port_associate "libc.so"
+//go:cgo_import_dynamic libc_port_dissociate port_dissociate "libc.so"
+//go:cgo_import_dynamic libc_port_get port_get "libc.so"
+//go:cgo_import_dynamic libc_port_send port_send "libc.so"
+//go:cgo_import_dynamic libc_port_alert port_alert "libc.so"
+
+//go:linkname libc_port_create libc_port_create
+//go:linkname libc_port_associate libc_port_associate
+//go:linkname libc_port_dissociate libc_port_dissociate
+//go:linkname libc_port_get libc_port_get
+//go:linkname libc_port_send libc_port_send
+//go:linkname libc_port_alert libc_port_alert
+
+var (
+	libc_port_create,
+	libc_port_associate,
+	libc_port_dissociate,
+	libc_port_get,
+	libc_port_send,
+	libc_port_alert uintptr
+)
+
+// Sysvicall6 calls a libc function from package syscall.
+func sysvicall6(trap, nargs, a1, a2, a3, a4, a5, a6 uintptr) (r1, r2 uintptr, err syscall.Errno)
+
+// PortEvent is port_event_t from <port.h>.
+type portEvent struct {
+	events int32   // portev_events
+	source uint16  // portev_source
+	_      uint16  // portev_pad
+	object uintptr // portev_object
+	user   uintptr // portev_user
+}
+
+// Event port sources and flags from <port.h>.
+const (
+	portSourceUser  = 3
+	portSourceFD    = 4
+	portSourceAlert = 5
+	portAlertSet    = 2
+)
+
+// Poll(2) flags from <poll.h>.
+const (
+	portPollIn   = 0x0001
+	portPollPri  = 0x0002
+	portPollOut  = 0x0004
+	portPollErr  = 0x0008
+	portPollHup  = 0x0010
+	portPollNVal = 0x0020
+)
+
+// Watch monitors a list of files for read availability. Event ports associate
+// each file descriptor for one event only. Watch associates the file descriptor
+// again before Await returns it, which gives the same level-triggered semantics
+// as epoll(7) and kqueue(2). Only the portable part, as defined by the Watcher
+// interface, plus a few is available. Options other than ExcludeOnHangup have
+// no effect.
+type Watch struct {
+	portFD int // port_create(3C)
+	config config
+
+	mutex       sync.Mutex
+	closed      bool
+	waiters     int              // number of Awaits in progress
+	fds         map[int]Interest // watch list
+	wakePending bool             // PORT_SOURCE_USER event in queue
+}
+
+// OpenWatch starts with an empty file list.
+func OpenWatch(opts ...Option) (*Watch, error) {
+	syscall.ForkLock.RLock()
+	r1, _, errno := sysvicall6(uintptr(unsafe.Pointer(&libc_port_create)), 0, 0, 0, 0, 0, 0, 0)
+	if errno == 0 {
+		syscall.CloseOnExec(int(r1))
+	}
+	syscall.ForkLock.RUnlock()
+	if errno != 0 {
+		return nil, fmt.Errorf("no Watch due port_create(3C) error %w", errno)
+	}
+
+	w := &Watch{
+		portFD: int(r1),
+		fds:    make(map[int]Interest),
+	}
+	for _, o := range opts {
+		o(&w.config)
+	}
+	return w, nil
+}
+
+// Close implements the io.Closer interface. Any Awaits in progress return with
+// ErrClosed. The event port is released on return of the last Await, in which
+// case any error from close(2) is lost.
+func (w *Watch) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	for fd := range w.fds {
+		delete(w.fds, fd)
+	}
+
+	if w.waiters != 0 {
+		// PORT_ALERT_SET wakes each Await in progress, and it
+		// stays in place until the port is closed.
+		sysvicall6(uintptr(unsafe.Pointer(&libc_port_alert)), 4, uintptr(w.portFD), portAlertSet, 1, 0, 0, 0)
+		return nil
+	}
+	return w.release()
+}
+
+// Release frees the kernel resources.
+func (w *Watch) release() error {
+	err := syscall.Close(w.portFD)
+	if err != nil && err != syscall.EBADF {
+		return fmt.Errorf("Watch stuck on close(2) of event port error %w", err)
+	}
+	return nil
+}
+
+// Enter registers an Await in progress. The return is false when closed.
+func (w *Watch) enter() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return false
+	}
+	w.waiters++
+	return true
+}
+
+// Leave unregisters an Await in progress, and it releases the kernel resources
+// when Close is pending on its completion.
+func (w *Watch) leave() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.waiters--
+	if w.closed && w.waiters == 0 {
+		w.release()
+	}
+}
+
+// Wakeup interrupts an Await in progress with ErrWoken, or the next Await when
+// none is in progress. Wakeups pending coalesce into one.
+func (w *Watch) Wakeup() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	if w.wakePending {
+		return nil
+	}
+	_, _, errno := sysvicall6(uintptr(unsafe.Pointer(&libc_port_send)), 3, uintptr(w.portFD), 0, 0, 0, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("Watch Wakeup lost on port_send(3C) error %w", errno)
+	}
+	w.wakePending = true
+	return nil
+}
+
+// AwaitFDWithRead blocks until it finds a file descriptor with read available
+// per direct. Positive timeout values, including zero for non-blocking, cause
+// an ErrTimeout on expiry. Negative timeouts block indefinitely.
+func (w *Watch) AwaitFDWithRead(timeout time.Duration) (fd int, err error) {
+	fd, _, err = w.AwaitFD(timeout)
+	return fd, err
+}
+
+// AwaitFD is like AwaitFDWithRead, yet it also reports which conditions were
+// met on the file descriptor.
+func (w *Watch) AwaitFD(timeout time.Duration) (fd int, ready Interest, err error) {
+	if !w.enter() {
+		return 0, 0, ErrClosed
+	}
+	defer w.leave()
+
+	ts := syscall.NsecToTimespec(int64(timeout))
+	var tsp *syscall.Timespec
+	if timeout >= 0 {
+		tsp = &ts
+	}
+	// restarts continue with the remainder
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	var event portEvent
+	for {
+		_, _, errno := sysvicall6(uintptr(unsafe.Pointer(&libc_port_get)), 3, uintptr(w.portFD), uintptr(unsafe.Pointer(&event)), uintptr(unsafe.Pointer(tsp)), 0, 0, 0)
+		switch errno {
+		case 0:
+			break
+		case syscall.EINTR:
+			timeout = remaining(timeout, deadline)
+			ts = syscall.NsecToTimespec(int64(timeout))
+			continue
+		case syscall.ETIME:
+			return 0, 0, ErrTimeout
+		case syscall.EBADF:
+			return 0, 0, ErrClosed
+		default:
+			return 0, 0, fmt.Errorf("Watch unavailable due port_get(3C) error %w", errno)
+		}
+
+		switch event.source {
+		case portSourceAlert:
+			return 0, 0, ErrClosed
+		case portSourceUser:
+			w.mutex.Lock()
+			w.wakePending = false
+			closed := w.closed
+			w.mutex.Unlock()
+			if closed {
+				return 0, 0, ErrClosed
+			}
+			return 0, 0, ErrWoken
+		case portSourceFD:
+			break
+		default:
+			continue // not ours
+		}
+
+		fd = int(event.object)
+		ready = portReadyOf(event.events)
+		w.mutex.Lock()
+		interest, ok := w.fds[fd]
+		if ok {
+			if ready&Hangup != 0 && w.config.excludeOnHangup {
+				delete(w.fds, fd)
+			} else {
+				err = w.associate(fd, interest)
+			}
+		}
+		w.mutex.Unlock()
+		if !ok {
+			// excluded during the wait
+			timeout = remaining(timeout, deadline)
+			ts = syscall.NsecToTimespec(int64(timeout))
+			continue
+		}
+		if err != nil {
+			return 0, 0, &FDError{FD: fd, Err: err}
+		}
+		if event.events&portPollNVal != 0 {
+			return 0, 0, &FDError{FD: fd, Err: ErrBadFD}
+		}
+		return fd, ready, nil
+	}
+}
+
+// PortReadyOf maps poll(2) events to their respective conditions.
+func portReadyOf(events int32) Interest {
+	var ready Interest
+	// hang-ups and errors make read return without blocking
+	if events&(portPollIn|portPollHup|portPollErr) != 0 {
+		ready |= Read
+	}
+	if events&portPollHup != 0 {
+		ready |= Hangup
+	}
+	if events&portPollPri != 0 {
+		ready |= Priority
+	}
+	if events&portPollOut != 0 {
+		ready |= Write
+	}
+	if events&portPollErr != 0 {
+		ready |= Error
+	}
+	return ready
+}
+
+// IncludeFD adds the file descriptor to the watch list. Duplicates are ignored
+// silently.
+func (w *Watch) IncludeFD(fd int) error {
+	return w.include(fd, Read)
+}
+
+// IncludeFDForPriority is like IncludeFD, yet it also reports Priority data.
+func (w *Watch) IncludeFDForPriority(fd int) error {
+	return w.include(fd, Read|Priority)
+}
+
+// IncludeFDForWrite adds the file descriptor to the watch list for Write
+// availability.
+func (w *Watch) IncludeFDForWrite(fd int) error {
+	return w.include(fd, Write)
+}
+
+// Include adds interest to the watch list.
+func (w *Watch) include(fd int, interest Interest) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	interest |= w.fds[fd]
+	err := w.associate(fd, interest)
+	if err != nil {
+		return err
+	}
+	w.fds[fd] = interest
+	return nil
+}
+
+// Associate arms the file descriptor for one event, which replaces any
+// association from before. The mutex must be held.
+func (w *Watch) associate(fd int, interest Interest) error {
+	var events uintptr
+	if interest&(Read|Hangup) != 0 {
+		events |= portPollIn
+	}
+	if interest&Priority != 0 {
+		events |= portPollPri
+	}
+	if interest&Write != 0 {
+		events |= portPollOut
+	}
+	_, _, errno := sysvicall6(uintptr(unsafe.Pointer(&libc_port_associate)), 5, uintptr(w.portFD), portSourceFD, uintptr(fd), events, 0, 0)
+	switch errno {
+	case 0:
+		return nil
+	case syscall.EBADF:
+		return ErrBadFD
+	case syscall.EAGAIN:
+		// maximum number of associations reached
+		return ErrTooManyWatches
+	}
+	return opError("IncludeFD", fd, errno)
+}
+
+// ModifyFD sets the conditions of interest for a file descriptor on the watch
+// list, which replaces any interest from before. Port_associate(3C) replaces the
+// association in place, such that the file descriptor does not leave the watch
+// list in between. Absence from the watch list gets ErrNotWatched. Zero interest
+// is equivalent to ExcludeFD.
+func (w *Watch) ModifyFD(fd int, interest Interest) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	if _, ok := w.fds[fd]; !ok {
+		return ErrNotWatched
+	}
+	if interest&(Read|Priority|Hangup|Write) == 0 {
+		return w.exclude(fd)
+	}
+	err := w.associate(fd, interest)
+	if err != nil {
+		return err
+	}
+	w.fds[fd] = interest
+	return nil
+}
+
+// ExcludeFD removes the file descriptor from the watch list. Absence is ignored
+// silently.
+func (w *Watch) ExcludeFD(fd int) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	return w.exclude(fd)
+}
+
+// Exclude removes fd from the watch list. The mutex must be held.
+func (w *Watch) exclude(fd int) error {
+	delete(w.fds, fd)
+	_, _, errno := sysvicall6(uintptr(unsafe.Pointer(&libc_port_dissociate)), 3, uintptr(w.portFD), portSourceFD, uintptr(fd), 0, 0, 0)
+	switch errno {
+	case 0, syscall.ENOENT:
+		// ENOENT while an event is in flight
+		return nil
+	case syscall.EBADF:
+		return ErrBadFD
+	}
+	return opError("ExcludeFD", fd, errno)
+}
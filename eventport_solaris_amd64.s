@@ -0,0 +1,5 @@
+#include "textflag.h"
+
+// Sysvicall6 is implemented in package syscall.
+TEXT ·sysvicall6(SB),NOSPLIT,$0-88
+	JMP	syscall·sysvicall6(SB)
@@ -0,0 +1,114 @@
+//go:build solaris
+
+package fdmom
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+// PortPipe returns the read end of a new pipe on the watch list of w, and the
+// write end.
+func portPipe(t *testing.T, w *Watch) (r, wr int) {
+	var fds [2]int
+	if err := syscall.Pipe(fds[:]); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		syscall.Close(fds[0])
+		syscall.Close(fds[1])
+	})
+	if err := w.IncludeFD(fds[0]); err != nil {
+		t.Fatal("include error:", err)
+	}
+	return fds[0], fds[1]
+}
+
+// Each event dissociates the file descriptor from the port. AwaitFD must
+// associate again, for as long as the condition remains.
+func TestEventPortReassociate(t *testing.T) {
+	w := newWatch(t)
+	r, wr := portPipe(t, w)
+
+	if _, err := syscall.Write(wr, []byte{'x'}); err != nil {
+		t.Fatal("write error:", err)
+	}
+	for i := 0; i < 3; i++ {
+		fd, ready, err := w.AwaitFD(testTimeout)
+		if err != nil {
+			t.Fatalf("await #%d error: %s", i+1, err)
+		}
+		if fd != r || ready&Read == 0 {
+			t.Errorf("await #%d got file descriptor %d with %s, want %d with read", i+1, fd, ready, r)
+		}
+	}
+
+	// read drains the condition
+	var buf [1]byte
+	if _, err := syscall.Read(r, buf[:]); err != nil {
+		t.Fatal("read error:", err)
+	}
+	_, err := w.AwaitFDWithRead(0)
+	if err != ErrTimeout {
+		t.Errorf("await after read got error %v, want ErrTimeout", err)
+	}
+}
+
+// The ETIME from port_get(3C) must map to ErrTimeout.
+func TestEventPortTimeout(t *testing.T) {
+	w := newWatch(t)
+	portPipe(t, w)
+
+	for _, timeout := range []time.Duration{0, time.Millisecond} {
+		start := time.Now()
+		_, err := w.AwaitFDWithRead(timeout)
+		if err != ErrTimeout {
+			t.Errorf("await with timeout %s got error %v, want ErrTimeout", timeout, err)
+		}
+		if d := time.Since(start); d < timeout {
+			t.Errorf("await with timeout %s returned after %s", timeout, d)
+		}
+	}
+}
+
+// PORT_ALERT_SET from Close must release each Await in progress.
+func TestEventPortCloseDuringAwait(t *testing.T) {
+	w := newWatch(t)
+	portPipe(t, w)
+
+	done := make(chan error, 2)
+	for i := 0; i < cap(done); i++ {
+		go func() {
+			_, err := w.AwaitFDWithRead(testTimeout)
+			done <- err
+		}()
+	}
+	time.Sleep(holdupMax / 2)
+	if err := w.Close(); err != nil {
+		t.Fatal("close error:", err)
+	}
+	for i := 0; i < cap(done); i++ {
+		if err := <-done; err != ErrClosed {
+			t.Errorf("await got error %v, want ErrClosed", err)
+		}
+	}
+}
+
+func TestEventPortWakeupCoalesce(t *testing.T) {
+	w := newWatch(t)
+	for i := 0; i < 3; i++ {
+		if err := w.Wakeup(); err != nil {
+			t.Fatal("wakeup error:", err)
+		}
+	}
+
+	_, err := w.AwaitFDWithRead(testTimeout)
+	if err != ErrWoken {
+		t.Errorf("await got error %v, want ErrWoken", err)
+	}
+	_, err = w.AwaitFDWithRead(0)
+	if err != ErrTimeout {
+		t.Errorf("second await got error %v, want ErrTimeout", err)
+	}
+}
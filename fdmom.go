@@ -1,4 +1,12 @@
 // Package fdmom provides supervision over file descriptors.
+//
+// A Watch is safe for concurrent use by multiple goroutines, with any method at
+// any time, including Close. Changes to the watch list apply to Awaits already
+// in progress. The kernel holds the watch list with epoll(7), kqueue(2),
+// io_uring(7) and event ports. Windows has no such facility, in which case the
+// Awaits in progress get woken to restart with the new watch list. Concurrent
+// Awaits each get a result of their own. A file descriptor may be reported to
+// multiple Awaits when it remains ready, unless with EdgeTriggered or OneShot.
 package fdmom
 
 import (
@@ -6,13 +14,416 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"syscall"
+	"time"
 )
 
 // ErrClosed signals use after Close.
 var ErrClosed = errors.New("use of closed file")
 
-// ErrTimeout is a reason for no results.
-var ErrTimeout = errors.New("fdmom interrupted by timeout")
+// ErrBadFD signals a file descriptor which is not open. The Watch itself remains
+// operational, as opposed to ErrClosed.
+var ErrBadFD = errors.New("bad file descriptor for Watch")
+
+// ErrTooManyWatches signals a resource limit from the kernel on inclusion, such
+// as max_user_watches from epoll(7) on Linux. Load shedding may resolve the
+// condition, as opposed to most other errors.
+var ErrTooManyWatches = errors.New("too many watches for the kernel")
+
+// ErrNotWatched signals absence from the watch list.
+var ErrNotWatched = errors.New("file descriptor not on watch list")
+
+// ErrTimeout is a reason for no results. It satisfies net.Error, with both
+// Timeout and Temporary true, for code which branches on network errors.
+var ErrTimeout error = timeoutError{}
+
+// TimeoutError is the type of ErrTimeout. The value is comparable, which keeps
+// both == and errors.Is working.
+type timeoutError struct{}
+
+// Error implements the error interface.
+func (timeoutError) Error() string { return "fdmom interrupted by timeout" }
+
+// Timeout implements net.Error.
+func (timeoutError) Timeout() bool { return true }
+
+// Temporary implements net.Error.
+func (timeoutError) Temporary() bool { return true }
+
+// ErrWoken signals an interruption by Wakeup. The package wakes Awaits for
+// internal purposes too, such as on context expiry with Run, which may reach
+// concurrent Awaits on the same Watch.
+var ErrWoken = errors.New("fdmom interrupted by wakeup")
+
+// ErrEmptyBuffer denies the batch methods, AwaitFDs and AwaitEvents, a buffer
+// without room for any results.
+var ErrEmptyBuffer = errors.New("fdmom got an empty buffer")
+
+// WatchError is a failure from the kernel on a file descriptor in particular.
+// The message reads the same on each platform, regardless of the facility in
+// use. Errors.Is matches the Errno.
+type WatchError struct {
+	Op    string        // method, e.g., IncludeFD for any of the inclusions
+	FD    int           // file descriptor, or -1 for multiple
+	Errno syscall.Errno // cause from the kernel
+}
+
+// Error implements the error interface.
+func (e *WatchError) Error() string {
+	if e.FD < 0 {
+		return fmt.Sprintf("fdmom Watch %s: %s", e.Op, e.Errno)
+	}
+	return fmt.Sprintf("fdmom Watch %s of file descriptor %d: %s", e.Op, e.FD, e.Errno)
+}
+
+// Unwrap returns the cause.
+func (e *WatchError) Unwrap() error { return e.Errno }
+
+// OpError returns err as a *WatchError when it is an errno, or wrapped in the
+// same format otherwise.
+func opError(op string, fd int, err error) error {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return &WatchError{Op: op, FD: fd, Errno: errno}
+	}
+	if fd < 0 {
+		return fmt.Errorf("fdmom Watch %s: %w", op, err)
+	}
+	return fmt.Errorf("fdmom Watch %s of file descriptor %d: %w", op, fd, err)
+}
+
+// FDError is an error for a file descriptor in particular.
+type FDError struct {
+	FD  int
+	Err error
+}
+
+// Error implements the error interface.
+func (e *FDError) Error() string {
+	return fmt.Sprintf("file descriptor %d: %s", e.FD, e.Err)
+}
+
+// Unwrap returns the cause.
+func (e *FDError) Unwrap() error { return e.Err }
+
+// Remaining returns the timeout left at deadline. Zero and negative timeouts
+// have no deadline.
+func remaining(timeout time.Duration, deadline time.Time) time.Duration {
+	if timeout <= 0 {
+		return timeout
+	}
+	left := time.Until(deadline)
+	if left < 0 {
+		return 0 // last non-blocking attempt
+	}
+	return left
+}
+
+// Watcher is the portable part of Watch. Code which depends on the interface
+// instead of the Watch type directly can run with a substitute in tests. Each
+// implementation must be safe for concurrent use, like Watch is.
+type Watcher interface {
+	// IncludeFD adds the file descriptor to the watch list.
+	IncludeFD(fd int) error
+	// ExcludeFD removes the file descriptor from the watch list.
+	ExcludeFD(fd int) error
+	// AwaitFDWithRead blocks until it finds a file descriptor with read
+	// available, or until timeout with ErrTimeout.
+	AwaitFDWithRead(timeout time.Duration) (fd int, err error)
+	// Close releases the watch list. Await calls in progress get ErrClosed.
+	Close() error
+}
+
+// Interest is a set of readiness conditions. IncludeFDInterest registers a file
+// descriptor for any combination, and AwaitFD reports which of them were met.
+type Interest uint
+
+// Readiness conditions are bit flags.
+const (
+	// Read is available without blocking.
+	Read Interest = 1 << iota
+	// Priority data is available, such as TCP urgent (out-of-band) data.
+	Priority
+	// Hangup of the peer, or the write end of a pipe, has happened.
+	Hangup
+	// Write is possible without blocking.
+	Write
+	// Error pending on the file descriptor, such as a failed connect(2).
+	// SocketError takes the cause from sockets.
+	Error
+	// Edge selects edge-triggered delivery for the file descriptor, as
+	// the EdgeTriggered option does for all of them. It is a mode rather
+	// than a condition, i.e., AwaitFD never reports it.
+	Edge
+	// Level selects level-triggered delivery for the file descriptor,
+	// regardless of the EdgeTriggered option. Edge wins when both are set.
+	Level
+)
+
+// String returns the names of the conditions separated by a vertical bar, like
+// "Read|Write", or "0" for none.
+func (i Interest) String() string {
+	if i == 0 {
+		return "0"
+	}
+	var buf []byte
+	for bit, name := range [...]string{"Read", "Priority", "Hangup", "Write", "Error", "Edge", "Level"} {
+		if i&(1<<bit) == 0 {
+			continue
+		}
+		if len(buf) != 0 {
+			buf = append(buf, '|')
+		}
+		buf = append(buf, name...)
+		i &^= 1 << bit
+	}
+	if i != 0 {
+		if len(buf) != 0 {
+			buf = append(buf, '|')
+		}
+		buf = fmt.Appendf(buf, "%#x", uint(i))
+	}
+	return string(buf)
+}
+
+// Event is the readiness of a file descriptor, or a token from Post.
+type Event struct {
+	FD     int      // file descriptor, or -1 when Posted
+	Ready  Interest // conditions met
+	Token  uint64   // from IncludeFDToken or Post, if any
+	Posted bool     // from Post
+
+	// Excluded is the terminal event of a file descriptor which left the
+	// watch list with ExcludeOnHangup. No more events follow, and the Token
+	// remains.
+	Excluded bool
+
+	// Expired is the expiry of a deadline from SetFDDeadline, without any
+	// conditions in Ready.
+	Expired bool
+
+	// Available is a hint on the number of bytes readable when Ready has
+	// Read, or -1 when unknown. Zero with Read means end of file, or a
+	// wakeup without any data. Kqueue(2) reports the length of the listen
+	// queue instead for sockets which accept connections.
+	Available int
+}
+
+// An Option applies to OpenWatch.
+type Option func(*config)
+
+// Config is the result of options.
+type config struct {
+	excludeOnHangup bool
+	ordering        Ordering
+	countPerFD      bool
+	detectHalfClose bool
+	coalesceChanges bool
+	exclusiveWakeup bool
+	edgeTriggered   bool
+	oneShot         bool
+	ioURing         bool
+	eventBatch      int // EventBatch size, if any
+	keepOnExec      bool
+	trackLatency    bool
+	slowGap         time.Duration            // SlowEvent threshold
+	slowEvent       func(int, time.Duration) // SlowEvent callback
+	diagnostics     func(Diagnostic)
+	sweepInterval   time.Duration // SweepDeadFDs period, if any
+	deadFD          func(int)     // SweepDeadFDs callback
+}
+
+// ExcludeOnHangup removes file descriptors from the watch list once they report
+// a Hangup. Such file descriptors are returned one last time, which prevents
+// busy loops on dead peers that are not closed right away. Any data left must
+// be read without notification. AwaitEvent and AwaitEvents mark the last time
+// with Excluded on the Event. Combine with DetectHalfClose for end of file on
+// stream sockets.
+func ExcludeOnHangup() Option {
+	return func(c *config) { c.excludeOnHangup = true }
+}
+
+// DetectHalfClose reports a Hangup once the peer shuts down its writing half of
+// a stream socket, with shutdown(2), even when the other half remains open. The
+// BSDs do so regardless with EV_EOF. Linux needs the option to arm EPOLLRDHUP,
+// which applies to file descriptors included after OpenWatch.
+func DetectHalfClose() Option {
+	return func(c *config) { c.detectHalfClose = true }
+}
+
+// CoalesceChanges defers IncludeFD, IncludeFDForPriority and ExcludeFD until the
+// next Await, which then submits them in the same kevent(2) as it waits with.
+// The methods return before the change applies. Any failures come from the next
+// Await instead, as *FDError entries joined. Other methods submit the pending
+// changes first, which may hold back ready events until the next Await. The
+// option has no effect on Linux, as epoll(7) has no such list of changes.
+func CoalesceChanges() Option {
+	return func(c *config) { c.coalesceChanges = true }
+}
+
+// ExclusiveWakeup arms EPOLLEXCLUSIVE on Linux, such that an event wakes one
+// Watch only, instead of each Watch with the file descriptor on its watch list.
+// The typical use is one Watch per worker, each of them with the same listener
+// included. EPOLLEXCLUSIVE can not be combined with the Priority interest, nor
+// with DetectHalfClose. The option has no effect on the BSDs.
+//
+// Concurrent Awaits on the same Watch are woken one per event by the kernel,
+// on both epoll(7) and kqueue(2). A file descriptor which remains ready after
+// its return passes on to the next Await, as level-triggered goes. Combine with
+// EdgeTriggered to get exactly one Await per event, such as for workers which
+// share a Watch in an accept loop.
+func ExclusiveWakeup() Option {
+	return func(c *config) { c.exclusiveWakeup = true }
+}
+
+// EdgeTriggered arms EPOLLET on Linux, and EV_CLEAR on the BSDs, such that file
+// descriptors are reported on each change in readiness only, instead of for as
+// long as they remain ready. Consumers must read (or write) until EAGAIN before
+// they can expect another report. The option applies to file descriptors, not
+// to timers. The Edge and Level modes of IncludeFDInterest select per file
+// descriptor instead, such as level for a listener with edge for connections.
+func EdgeTriggered() Option {
+	return func(c *config) { c.edgeTriggered = true }
+}
+
+// OneShot arms EPOLLONESHOT on Linux, and EV_ONESHOT on the BSDs, such that file
+// descriptors are reported once only, until RearmFD. The typical use is handing
+// file descriptors to worker routines, without duplicate dispatch while a worker
+// is busy. File descriptors remain on the watch list when disarmed. Read and
+// Write disarm independently on the BSDs. EPOLLONESHOT can not be combined with
+// ExclusiveWakeup. The option applies to file descriptors, not to timers.
+func OneShot() Option {
+	return func(c *config) { c.oneShot = true }
+}
+
+// IOURing replaces epoll(7) with io_uring(7) on Linux 5.13 and later, with a
+// multishot poll for each file descriptor on the watch list. Multishot polls
+// report on each change in readiness only, as with EdgeTriggered. OneShot
+// submits single-shot polls instead. File descriptors must be excluded before
+// they are closed, because polls hold on to the file. ExclusiveWakeup has no
+// effect. OpenWatch fails when io_uring(7) is not available. The option has no
+// effect on the other platforms.
+func IOURing() Option {
+	return func(c *config) { c.ioURing = true }
+}
+
+// EventBatch sets the number of events to read from the kernel at once with
+// AwaitFD, and with the methods built on it, i.e., the maxevents of epoll_wait(2)
+// on Linux, and the nevents of kevent(2) on the BSDs. Events beyond the first are
+// kept for the next Awaits, which saves system calls under load. Such events may
+// be stale by the time they are returned, i.e., a file descriptor drained in the
+// mean time gets reported nonetheless, which non-blocking reads handle just fine.
+// Linux reads one event at a time by default. The BSDs read up to 64 events by
+// default, yet only EdgeTriggered and OneShot keep the excess. AwaitFDs and
+// AwaitEvents read as many events as their buffer holds regardless. The option
+// has no effect with IOURing, nor on Windows and Solaris.
+func EventBatch(n int) Option {
+	return func(c *config) { c.eventBatch = n }
+}
+
+// KeepOnExec leaves close-on-exec off for the epoll(7) or kqueue(2) descriptor,
+// such that exec(2) passes it on to the new program, e.g., for embedding in a
+// child process with SyscallConn or File. The descriptors for internal use keep
+// close-on-exec regardless, and so does io_uring(7) with IOURing. Note that the
+// BSDs, including Darwin, do not inherit kqueue(2) descriptors on fork(2) at all.
+// The option has no effect on Windows and Solaris.
+func KeepOnExec() Option {
+	return func(c *config) { c.keepOnExec = true }
+}
+
+// CountPerFD enables EventCount and EventCounts. The accounting takes a lock on
+// each file descriptor returned, which is why it is off by default.
+func CountPerFD() Option {
+	return func(c *config) { c.countPerFD = true }
+}
+
+// TrackLatency enables Latencies. Each Await measures the time since the last
+// file descriptor returned by the Watch, i.e., the gap in which the Watch went
+// unattended. Readiness during such gap waits until the next Await, which makes
+// the gaps an upper bound for the delay between readiness and delivery. Long
+// gaps point to slow consumers. The measure suits one consumer per Watch best,
+// as concurrent Awaits can not tell which of them returned last. The option has
+// no effect on Windows and Solaris.
+func TrackLatency() Option {
+	return func(c *config) { c.trackLatency = true }
+}
+
+// SlowEvent is like TrackLatency, with f invoked on each gap longer than the
+// threshold. The file descriptor passed to f is the one returned before the gap,
+// i.e., the one with the slow consumer. Any of the Await methods invokes f on
+// the routine of the call, before it waits.
+func SlowEvent(threshold time.Duration, f func(fd int, gap time.Duration)) Option {
+	return func(c *config) {
+		c.trackLatency = true
+		c.slowGap = threshold
+		c.slowEvent = f
+	}
+}
+
+// Diagnostic is a structured record from the Diagnostics option.
+type Diagnostic struct {
+	// Op is either "include", "modify", "exclude", "restart" or
+	// "unavailable". Restarts are waits interrupted by a signal (EINTR).
+	// Unavailable are kernel errors which fail the Await.
+	Op string
+
+	FD       int      // file descriptor, or -1 when not applicable
+	Interest Interest // conditions requested with include and modify
+	Err      error    // failure, if any
+}
+
+// Diagnostics passes a record to f on each registration, exclusion, kernel error
+// and restart. Adapters for structured logging are a one-liner, such as f with
+// logger.Debug(d.Op, "fd", d.FD, "interest", d.Interest, "err", d.Err) for an
+// *slog.Logger. Calls to f are synchronous, possibly with a lock of the Watch
+// held, which means that f must not call any methods on the Watch. The option
+// has no effect on Windows and Solaris.
+func Diagnostics(f func(Diagnostic)) Option {
+	return func(c *config) { c.diagnostics = f }
+}
+
+// SweepDeadFDs runs SweepFDs every interval, for as long as the Watch is open,
+// and it passes each file descriptor removed to f, including the ones from any
+// explicit SweepFDs. Intervals of zero and less only apply f. Calls to f are
+// synchronous with the sweep, without any lock of the Watch. The option has no
+// effect on Windows and Solaris.
+func SweepDeadFDs(interval time.Duration, f func(fd int)) Option {
+	return func(c *config) {
+		c.sweepInterval = interval
+		c.deadFD = f
+	}
+}
+
+// Ordering is a policy for which file descriptor to return when multiple are
+// ready at the same time.
+type Ordering int
+
+const (
+	// RoundRobin rotates over the ready file descriptors, such that one
+	// busy file descriptor can not starve the others. It is the default.
+	RoundRobin Ordering = iota
+	// KernelOrder returns file descriptors in the order the kernel reports
+	// them, without any bookkeeping on top.
+	KernelOrder
+	// ReadyOrder returns file descriptors first in, first out, by the time
+	// they were found ready. File descriptors which remain ready after their
+	// return get in line again.
+	ReadyOrder
+	// RankOrder returns the file descriptors with the highest rank from
+	// IncludeFDRank first, e.g., control-plane sockets before bulk-transfer
+	// sockets. Equal ranks go round robin.
+	RankOrder
+)
+
+// WithOrdering sets the policy for multiple file descriptors ready at the same
+// time. The ordering of epoll(7) on Linux is defined by the kernel regardless,
+// which moves returned file descriptors to the end of its ready list already.
+// That makes RoundRobin, KernelOrder and ReadyOrder the same on Linux. RankOrder
+// reads a batch of events with each epoll_wait(2) to choose from instead.
+// The policy applies to AwaitFD and its derivatives, with io_uring(7) excluded.
+func WithOrdering(o Ordering) Option {
+	return func(c *config) { c.ordering = o }
+}
 
 // A Filer grants its file (descriptor).
 type filer interface {
@@ -23,13 +434,17 @@ type filer interface {
 // listener. The representative has a different file descriptor. Closing the
 // file does not affect the listener, and vise versa. Attempting to change
 // properties of the listener with the return may or may not have the desired
-// effect.
+// effect. Listeners without a File method may provide a syscall.Conn instead.
 func ListenerFile(l net.Listener) (*os.File, error) {
 	withFile, ok := l.(filer)
-	if !ok {
-		return nil, fmt.Errorf("listener %T does not provide its file", l)
+	if ok {
+		return withFile.File()
 	}
-	return withFile.File()
+	withConn, ok := l.(syscall.Conn)
+	if ok {
+		return dupFile(withConn, "listener "+l.Addr().String())
+	}
+	return nil, fmt.Errorf("listener %T does not provide its file", l)
 }
 
 // A NetConner grants the underlying connection such as *tls.Conn does.
@@ -41,7 +456,7 @@ type netConner interface {
 // connection. The representative has a different file descriptor. Closing the
 // file does not affect the connection, and vise versa. Attempting to change
 // properties of the connection with the return may or may not have the desired
-// effect.
+// effect. Connections without a File method may provide a syscall.Conn instead.
 func ConnFile(conn net.Conn) (*os.File, error) {
 	nested, ok := conn.(netConner)
 	if ok {
@@ -49,8 +464,27 @@ func ConnFile(conn net.Conn) (*os.File, error) {
 	}
 
 	withFile, ok := conn.(filer)
-	if !ok {
-		return nil, fmt.Errorf("connection %T does not provide its file", conn)
+	if ok {
+		return withFile.File()
+	}
+	withConn, ok := conn.(syscall.Conn)
+	if ok {
+		return dupFile(withConn, "connection "+conn.LocalAddr().String())
+	}
+	return nil, fmt.Errorf("connection %T does not provide its file", conn)
+}
+
+// PacketConnFile returns a new representative of the underlying file from the
+// connection, like ConnFile does, yet for packet-oriented connections such as
+// *net.UDPConn, *net.IPConn and *net.UnixConn in datagram mode.
+func PacketConnFile(conn net.PacketConn) (*os.File, error) {
+	withFile, ok := conn.(filer)
+	if ok {
+		return withFile.File()
+	}
+	withConn, ok := conn.(syscall.Conn)
+	if ok {
+		return dupFile(withConn, "packet connection "+conn.LocalAddr().String())
 	}
-	return withFile.File()
+	return nil, fmt.Errorf("packet connection %T does not provide its file", conn)
 }
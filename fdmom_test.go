@@ -0,0 +1,61 @@
+package fdmom
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestListenerFileUnsupported(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	_, err = ListenerFile(struct{ net.Listener }{l})
+	if err == nil {
+		t.Error("no error for listener without file")
+	}
+}
+
+func TestInterestString(t *testing.T) {
+	tests := []struct {
+		interest Interest
+		want     string
+	}{
+		{0, "0"},
+		{Read, "Read"},
+		{Read | Write, "Read|Write"},
+		{Priority | Hangup | Error, "Priority|Hangup|Error"},
+		{Read | Edge, "Read|Edge"},
+		{Write | 1<<7, "Write|0x80"},
+	}
+	for _, test := range tests {
+		if got := test.interest.String(); got != test.want {
+			t.Errorf("got %q for %#x, want %q", got, uint(test.interest), test.want)
+		}
+	}
+}
+
+func TestErrTimeoutNetError(t *testing.T) {
+	var netErr net.Error
+	if !errors.As(ErrTimeout, &netErr) {
+		t.Fatal("ErrTimeout is not a net.Error")
+	}
+	if !netErr.Timeout() || !netErr.Temporary() {
+		t.Errorf("got Timeout %t and Temporary %t, want both true", netErr.Timeout(), netErr.Temporary())
+	}
+
+	wrapped := fmt.Errorf("await: %w", ErrTimeout)
+	if !errors.Is(wrapped, ErrTimeout) {
+		t.Error("wrapped ErrTimeout does not match errors.Is")
+	}
+	if !errors.As(wrapped, &netErr) || !netErr.Timeout() {
+		t.Error("wrapped ErrTimeout is not a net.Error with Timeout")
+	}
+	if errors.Is(ErrWoken, ErrTimeout) {
+		t.Error("ErrWoken matches ErrTimeout")
+	}
+}
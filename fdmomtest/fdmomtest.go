@@ -0,0 +1,145 @@
+// Package fdmomtest provides a substitute for fdmom.Watch in tests.
+package fdmomtest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pascaldekloe/fdmom"
+)
+
+// Watch is an in-memory implementation of fdmom.Watcher. File descriptors get
+// ready with MarkReady only, and timeouts expire with AdvanceTimeout only. No
+// system calls nor clocks are involved, which makes tests deterministic.
+type Watch struct {
+	mutex    sync.Mutex
+	change   sync.Cond // signals on each modification
+	closed   bool
+	fds      map[int]struct{} // watch list
+	ready    []int            // pending in order of MarkReady
+	expiries int              // pending timeouts from AdvanceTimeout
+}
+
+// Interface compliance check.
+var _ fdmom.Watcher = (*Watch)(nil)
+
+// NewWatch starts with an empty file list.
+func NewWatch() *Watch {
+	w := &Watch{fds: make(map[int]struct{})}
+	w.change.L = &w.mutex
+	return w
+}
+
+// IncludeFD implements fdmom.Watcher. Negative file descriptors get
+// fdmom.ErrBadFD.
+func (w *Watch) IncludeFD(fd int) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return fdmom.ErrClosed
+	}
+	if fd < 0 {
+		return fdmom.ErrBadFD
+	}
+	w.fds[fd] = struct{}{}
+	w.change.Broadcast()
+	return nil
+}
+
+// ExcludeFD implements fdmom.Watcher. Absence is ignored silently. Any readiness
+// pending from MarkReady is discarded.
+func (w *Watch) ExcludeFD(fd int) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return fdmom.ErrClosed
+	}
+	delete(w.fds, fd)
+	n := 0
+	for _, ready := range w.ready {
+		if ready != fd {
+			w.ready[n] = ready
+			n++
+		}
+	}
+	w.ready = w.ready[:n]
+	return nil
+}
+
+// MarkReady makes one Await return the file descriptor. Multiple calls queue up
+// in order. Await skips file descriptors not on the watch list, like the kernel
+// does, until they get included. Calls after Close have no effect.
+func (w *Watch) MarkReady(fd int) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return
+	}
+	w.ready = append(w.ready, fd)
+	w.change.Broadcast()
+}
+
+// AdvanceTimeout expires the timeout of one Await in progress. Without any such
+// Await in progress, the next Await with a positive timeout gets the expiry. Any
+// readiness pending takes precedence over an expiry, like it does for a Watch.
+func (w *Watch) AdvanceTimeout() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return
+	}
+	w.expiries++
+	w.change.Broadcast()
+}
+
+// AwaitFDWithRead implements fdmom.Watcher. A zero timeout returns ErrTimeout
+// immediately when none are ready. A positive timeout blocks until AdvanceTimeout
+// instead of the actual duration. A negative timeout blocks until ready or Close.
+func (w *Watch) AwaitFDWithRead(timeout time.Duration) (fd int, err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for {
+		if w.closed {
+			return -1, fdmom.ErrClosed
+		}
+		if fd, ok := w.takeReady(); ok {
+			return fd, nil
+		}
+		switch {
+		case timeout == 0:
+			return -1, fdmom.ErrTimeout
+		case timeout > 0 && w.expiries != 0:
+			w.expiries--
+			return -1, fdmom.ErrTimeout
+		}
+		w.change.Wait()
+	}
+}
+
+// TakeReady removes the first file descriptor pending which is on the watch
+// list. The mutex must be held.
+func (w *Watch) takeReady() (fd int, ok bool) {
+	for i, fd := range w.ready {
+		if _, ok := w.fds[fd]; ok {
+			w.ready = append(w.ready[:i], w.ready[i+1:]...)
+			return fd, true
+		}
+	}
+	return -1, false
+}
+
+// Close implements fdmom.Watcher. Any Awaits in progress return with
+// fdmom.ErrClosed.
+func (w *Watch) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	w.fds = make(map[int]struct{})
+	w.ready = nil
+	w.expiries = 0
+	w.change.Broadcast()
+	return nil
+}
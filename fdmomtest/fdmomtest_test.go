@@ -0,0 +1,97 @@
+package fdmomtest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pascaldekloe/fdmom"
+)
+
+func TestMarkReady(t *testing.T) {
+	w := NewWatch()
+	defer w.Close()
+
+	w.MarkReady(7) // not included yet
+	if got, err := w.AwaitFDWithRead(0); err != fdmom.ErrTimeout {
+		t.Errorf("got FD %d with error %v before include, want ErrTimeout", got, err)
+	}
+	if err := w.IncludeFD(7); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := w.AwaitFDWithRead(-1); err != nil || got != 7 {
+		t.Errorf("got FD %d with error %v, want FD 7", got, err)
+	}
+	// readiness applies once
+	if got, err := w.AwaitFDWithRead(0); err != fdmom.ErrTimeout {
+		t.Errorf("got FD %d with error %v after take, want ErrTimeout", got, err)
+	}
+
+	w.MarkReady(7)
+	if err := w.ExcludeFD(7); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.IncludeFD(7); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := w.AwaitFDWithRead(0); err != fdmom.ErrTimeout {
+		t.Errorf("got FD %d with error %v after exclude, want ErrTimeout", got, err)
+	}
+}
+
+func TestAdvanceTimeout(t *testing.T) {
+	w := NewWatch()
+	defer w.Close()
+
+	done := make(chan error)
+	go func() {
+		_, err := w.AwaitFDWithRead(time.Hour)
+		done <- err
+	}()
+	w.AdvanceTimeout()
+	if err := <-done; err != fdmom.ErrTimeout {
+		t.Errorf("got error %v, want ErrTimeout", err)
+	}
+
+	// no expiry for blocking without timeout
+	w.AdvanceTimeout()
+	if err := w.IncludeFD(3); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_, err := w.AwaitFDWithRead(-1)
+		done <- err
+	}()
+	w.MarkReady(3)
+	if err := <-done; err != nil {
+		t.Errorf("got error %v, want ready", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	w := NewWatch()
+
+	done := make(chan error)
+	go func() {
+		_, err := w.AwaitFDWithRead(-1)
+		done <- err
+	}()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != fdmom.ErrClosed {
+		t.Errorf("Await in progress got error %v, want ErrClosed", err)
+	}
+
+	if err := w.IncludeFD(3); err != fdmom.ErrClosed {
+		t.Errorf("IncludeFD got error %v, want ErrClosed", err)
+	}
+	if err := w.ExcludeFD(3); err != fdmom.ErrClosed {
+		t.Errorf("ExcludeFD got error %v, want ErrClosed", err)
+	}
+	if _, err := w.AwaitFDWithRead(0); err != fdmom.ErrClosed {
+		t.Errorf("Await got error %v, want ErrClosed", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Errorf("second Close got error %v", err)
+	}
+}
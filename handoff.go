@@ -0,0 +1,162 @@
+//go:build linux || darwin || netbsd || freebsd || openbsd || dragonfly
+
+package fdmom
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// HandoffEnv is the environment variable from Handoff to AdoptFDs, with an entry
+// per file descriptor, separated by commas. Each entry has the number in the
+// child, the number in the parent, the interest, the token and the rank, all in
+// decimal, separated by colons.
+const handoffEnv = "FDMOM_HANDOFF"
+
+// HandoffFD is a file descriptor from the watch list of a parent process.
+type HandoffFD struct {
+	FD       int      // number in this process
+	ParentFD int      // number in the parent process
+	Interest Interest // conditions of interest
+	Paused   bool     // from PauseFD
+	Token    uint64   // from IncludeFDToken, zero for none
+	Rank     int      // from IncludeFDRank
+}
+
+// Handoff passes the watch list to cmd, for AdoptFDs in the child process, such
+// that a graceful restart can carry its connections over exec. Each file
+// descriptor goes as a duplicate in cmd.ExtraFiles, with its interest, token and
+// rank in the environment of cmd. The duplicates are for the caller to close
+// after Start. The watch list remains as is, which means that both processes
+// get readiness until the parent excludes, or until it exits. Timers, paths,
+// processes, signals and children from IncludeWatch stay behind, as they belong
+// to the Watch rather than to the application.
+func (w *Watch) Handoff(cmd *exec.Cmd) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+
+	fds := make([]int, 0, len(w.fds))
+	for fd := range w.fds {
+		if !w.internalFD(fd) {
+			fds = append(fds, fd)
+		}
+	}
+	sort.Ints(fds)
+
+	files := make([]*os.File, 0, len(fds))
+	entries := make([]string, 0, len(fds))
+	for _, fd := range fds {
+		dupFD, err := dupCloseOnExec(fd)
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return &FDError{FD: fd, Err: err}
+		}
+		// ExtraFiles count from 3 in the child
+		childFD := 3 + len(cmd.ExtraFiles) + len(files)
+		files = append(files, os.NewFile(uintptr(dupFD), "fdmom handoff"))
+		entries = append(entries, fmt.Sprintf("%d:%d:%d:%d:%d",
+			childFD, fd, w.fds[fd], w.tokens[fd], w.ranks[fd]))
+	}
+	cmd.ExtraFiles = append(cmd.ExtraFiles, files...)
+	cmd.Env = append(cmd.Environ(), handoffEnv+"="+strings.Join(entries, ","))
+	return nil
+}
+
+// AdoptFDs includes the file descriptors from Handoff in the parent process, with
+// their interest, token and rank as before, including any pause. The environment
+// variable is unset on return, such that child processes do not claim the same
+// descriptors. The return is empty without Handoff. File descriptors adopted
+// are returned together with any errors from the inclusion, each as an
+// *FDError. Net.FileConn and net.FileListener can wrap the file descriptors
+// with os.NewFile.
+func (w *Watch) AdoptFDs() ([]HandoffFD, error) {
+	s := os.Getenv(handoffEnv)
+	// passed once
+	os.Unsetenv(handoffEnv)
+	if s == "" {
+		return nil, nil
+	}
+
+	entries := strings.Split(s, ",")
+	adopts := make([]HandoffFD, 0, len(entries))
+	interests := make([]Interest, 0, len(entries))
+	for _, entry := range entries {
+		a, interest, err := parseHandoff(entry)
+		if err != nil {
+			return nil, err
+		}
+		adopts = append(adopts, a)
+		interests = append(interests, interest)
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return nil, ErrClosed
+	}
+	adopted := adopts[:0]
+	var errs []error
+	for i, a := range adopts {
+		syscall.CloseOnExec(a.FD)
+		err := w.counters.include("include", a.FD, a.Interest, w.add(a.FD, interests[i]))
+		if err != nil {
+			errs = append(errs, &FDError{FD: a.FD, Err: err})
+			continue
+		}
+		if a.Token != 0 {
+			if w.tokens == nil {
+				w.tokens = make(map[int]uint64)
+			}
+			w.tokens[a.FD] = a.Token
+		}
+		if a.Rank != 0 {
+			if w.ranks == nil {
+				w.ranks = make(map[int]int)
+			}
+			w.ranks[a.FD] = a.Rank
+		}
+		adopted = append(adopted, a)
+	}
+	return adopted, errors.Join(errs...)
+}
+
+// ParseHandoff reads an entry from handoffEnv. The interest return includes
+// the paused bit.
+func parseHandoff(entry string) (a HandoffFD, interest Interest, err error) {
+	fields := strings.Split(entry, ":")
+	if len(fields) != 5 {
+		return a, 0, fmt.Errorf("handoff with malformed %s entry %q", handoffEnv, entry)
+	}
+	a.FD, err = strconv.Atoi(fields[0])
+	if err == nil {
+		a.ParentFD, err = strconv.Atoi(fields[1])
+	}
+	var u uint64
+	if err == nil {
+		u, err = strconv.ParseUint(fields[2], 10, 32)
+	}
+	if err == nil {
+		a.Token, err = strconv.ParseUint(fields[3], 10, 64)
+	}
+	if err == nil {
+		a.Rank, err = strconv.Atoi(fields[4])
+	}
+	if err != nil || a.FD < 0 {
+		return a, 0, fmt.Errorf("handoff with malformed %s entry %q", handoffEnv, entry)
+	}
+	interest = Interest(u)
+	a.Interest = interest &^ paused
+	a.Paused = interest&paused != 0
+	return a, interest, nil
+}
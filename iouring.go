@@ -0,0 +1,503 @@
+//go:build linux
+
+package fdmom
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// RingParams is struct io_uring_params from <linux/io_uring.h>.
+type ringParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFD         uint32
+	_            [3]uint32
+
+	// struct io_sqring_offsets
+	sqHead        uint32
+	sqTail        uint32
+	sqRingMask    uint32
+	sqRingEntries uint32
+	sqFlags       uint32
+	sqDropped     uint32
+	sqArray       uint32
+	_             uint32
+	_             uint64
+
+	// struct io_cqring_offsets
+	cqHead        uint32
+	cqTail        uint32
+	cqRingMask    uint32
+	cqRingEntries uint32
+	cqOverflow    uint32
+	cqCQEs        uint32
+	cqFlags       uint32
+	_             uint32
+	_             uint64
+}
+
+// RingSQE is struct io_uring_sqe from <linux/io_uring.h>, limited to the fields
+// in use.
+type ringSQE struct {
+	opcode   uint8
+	flags    uint8
+	ioprio   uint16
+	fd       int32
+	off      uint64
+	addr     uint64
+	len      uint32
+	opFlags  uint32 // poll32_events
+	userData uint64
+	_        [3]uint64
+}
+
+// RingCQE is struct io_uring_cqe from <linux/io_uring.h>.
+type ringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// RingGeteventsArg is struct io_uring_getevents_arg from <linux/io_uring.h>.
+type ringGeteventsArg struct {
+	sigmask   uint64
+	sigmaskSz uint32
+	_         uint32
+	ts        uint64
+}
+
+// Constants from <linux/io_uring.h>.
+const (
+	ringOffSQRing = 0
+	ringOffCQRing = 0x8000000
+	ringOffSQEs   = 0x10000000
+
+	ringOpPollAdd    = 6 // IORING_OP_POLL_ADD
+	ringOpPollRemove = 7 // IORING_OP_POLL_REMOVE
+
+	ringPollAddMulti = 1 << 0 // IORING_POLL_ADD_MULTI
+	ringCQEFMore     = 1 << 1 // IORING_CQE_F_MORE
+
+	ringEnterGetevents = 1 << 0 // IORING_ENTER_GETEVENTS
+	ringEnterExtArg    = 1 << 3 // IORING_ENTER_EXT_ARG
+
+	ringFeatExtArg   = 1 << 8  // IORING_FEAT_EXT_ARG from Linux 5.11
+	ringFeatRsrcTags = 1 << 10 // IORING_FEAT_RSRC_TAGS from Linux 5.13
+)
+
+// RingEntries is the size of the submission queue. Submissions are flushed
+// right away, so the number in use stays low. The completion queue is twice
+// as large, and the kernel keeps any overflow on Linux 5.5 and later.
+const ringEntries = 64
+
+// RingIgnore is the user data for submissions without interest in completion.
+const ringIgnore = ^uint64(0)
+
+// Uring is an io_uring(7) instance with its memory mapped. The submission queue
+// is protected by the mutex of the Watch, and so is the head of the completion
+// queue.
+type uring struct {
+	fd int
+
+	sqRing, cqRing, sqeMem []byte // mmap(2)
+
+	sqTail, sqHead *uint32
+	sqMask         uint32
+	sqArray        []uint32
+	sqes           []ringSQE
+	pending        uint32 // number of submissions not entered yet
+
+	cqHead, cqTail *uint32
+	cqMask         uint32
+	cqes           []ringCQE
+}
+
+// OpenRing sets up a new io_uring(7) instance. The descriptor has O_CLOEXEC set
+// by the kernel.
+func openRing() (*uring, error) {
+	var p ringParams
+	r1, _, errno := syscall.Syscall(sysIOURingSetup, ringEntries, uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("no Watch due io_uring_setup(2) error %w", errno)
+	}
+	r := &uring{fd: int(r1)}
+	if p.features&ringFeatExtArg == 0 || p.features&ringFeatRsrcTags == 0 {
+		syscall.Close(r.fd)
+		return nil, errors.New("no Watch due io_uring(7) without multishot poll; need Linux 5.13 or later")
+	}
+
+	const prot = syscall.PROT_READ | syscall.PROT_WRITE
+	const flags = syscall.MAP_SHARED | syscall.MAP_POPULATE
+	var err error
+	r.sqRing, err = syscall.Mmap(r.fd, ringOffSQRing, int(p.sqArray+p.sqEntries*4), prot, flags)
+	if err == nil {
+		r.cqRing, err = syscall.Mmap(r.fd, ringOffCQRing, int(p.cqCQEs+p.cqEntries*uint32(unsafe.Sizeof(ringCQE{}))), prot, flags)
+	}
+	if err == nil {
+		r.sqeMem, err = syscall.Mmap(r.fd, ringOffSQEs, int(p.sqEntries*uint32(unsafe.Sizeof(ringSQE{}))), prot, flags)
+	}
+	if err != nil {
+		r.close()
+		return nil, fmt.Errorf("no Watch due mmap(2) of io_uring(7) error %w", err)
+	}
+
+	r.sqHead = (*uint32)(unsafe.Pointer(&r.sqRing[p.sqHead]))
+	r.sqTail = (*uint32)(unsafe.Pointer(&r.sqRing[p.sqTail]))
+	r.sqMask = *(*uint32)(unsafe.Pointer(&r.sqRing[p.sqRingMask]))
+	r.sqArray = unsafe.Slice((*uint32)(unsafe.Pointer(&r.sqRing[p.sqArray])), p.sqEntries)
+	r.sqes = unsafe.Slice((*ringSQE)(unsafe.Pointer(&r.sqeMem[0])), p.sqEntries)
+	r.cqHead = (*uint32)(unsafe.Pointer(&r.cqRing[p.cqHead]))
+	r.cqTail = (*uint32)(unsafe.Pointer(&r.cqRing[p.cqTail]))
+	r.cqMask = *(*uint32)(unsafe.Pointer(&r.cqRing[p.cqRingMask]))
+	r.cqes = unsafe.Slice((*ringCQE)(unsafe.Pointer(&r.cqRing[p.cqCQEs])), p.cqEntries)
+	return r, nil
+}
+
+// Close releases the memory mappings and the descriptor. Any polls in progress
+// are cancelled by the kernel.
+func (r *uring) close() error {
+	for _, b := range [...][]byte{r.sqeMem, r.cqRing, r.sqRing} {
+		if b != nil {
+			syscall.Munmap(b)
+		}
+	}
+	return syscall.Close(r.fd)
+}
+
+// Push queues a submission. Enter must follow to apply.
+func (r *uring) push(sqe ringSQE) error {
+	tail := *r.sqTail // only written by us
+	if tail-atomic.LoadUint32(r.sqHead) > r.sqMask {
+		// flush before overwrite
+		if err := r.enter(); err != nil {
+			return err
+		}
+	}
+	i := tail & r.sqMask
+	r.sqes[i] = sqe
+	r.sqArray[i] = i
+	atomic.StoreUint32(r.sqTail, tail+1)
+	r.pending++
+	return nil
+}
+
+// Enter submits any pending submissions. The kernel consumes them before the
+// return.
+func (r *uring) enter() error {
+	for r.pending != 0 {
+		r1, _, errno := syscall.Syscall6(sysIOURingEnter, uintptr(r.fd), uintptr(r.pending), 0, 0, 0, 0)
+		switch errno {
+		case 0:
+			r.pending -= uint32(r1)
+		case syscall.EINTR:
+			continue
+		default:
+			return errno
+		}
+	}
+	return nil
+}
+
+// PollAdd arms a poll on fd with the completions tagged with fd as user data.
+// Multishot polls remain armed after each completion.
+func (r *uring) pollAdd(fd int, events uint32, multishot bool) error {
+	sqe := ringSQE{
+		opcode:   ringOpPollAdd,
+		fd:       int32(fd),
+		opFlags:  ringPollEvents(events),
+		userData: uint64(fd),
+	}
+	if multishot {
+		sqe.len = ringPollAddMulti
+	}
+	if err := r.push(sqe); err != nil {
+		return err
+	}
+	return r.enter()
+}
+
+// PollRemove cancels the poll on fd, if any. The poll completes with ECANCELED.
+func (r *uring) pollRemove(fd int) error {
+	err := r.push(ringSQE{
+		opcode:   ringOpPollRemove,
+		addr:     uint64(fd),
+		userData: ringIgnore,
+	})
+	if err != nil {
+		return err
+	}
+	return r.enter()
+}
+
+// Pop takes the next completion, if any.
+func (r *uring) pop() (cqe ringCQE, ok bool) {
+	head := *r.cqHead // only written by us
+	if head == atomic.LoadUint32(r.cqTail) {
+		return cqe, false
+	}
+	cqe = r.cqes[head&r.cqMask]
+	atomic.StoreUint32(r.cqHead, head+1)
+	return cqe, true
+}
+
+// Wait blocks until a completion is available, with nanosecond precision on the
+// timeout. Negative timeouts block indefinitely. A signal mask other than nil
+// applies for the duration of the wait. The kernel wakes each waiter on a new
+// completion, regardless of who pops it.
+func (r *uring) wait(timeout time.Duration, sigmask *sigset) error {
+	var arg ringGeteventsArg
+	if sigmask != nil {
+		arg.sigmask = uint64(uintptr(unsafe.Pointer(sigmask)))
+		arg.sigmaskSz = uint32(unsafe.Sizeof(*sigmask))
+	}
+	var ts kernelTimespec
+	if timeout >= 0 {
+		ts = nsecToKernelTimespec(int64(timeout))
+		arg.ts = uint64(uintptr(unsafe.Pointer(&ts)))
+	}
+	_, _, errno := syscall.Syscall6(sysIOURingEnter, uintptr(r.fd), 0, 1,
+		ringEnterGetevents|ringEnterExtArg, uintptr(unsafe.Pointer(&arg)), unsafe.Sizeof(arg))
+	runtime.KeepAlive(sigmask)
+	runtime.KeepAlive(&ts)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// RingPollEvents returns the poll32_events encoding of events, which has its
+// 16-bit halves swapped on big-endian platforms.
+func ringPollEvents(events uint32) uint32 {
+	probe := uint16(1)
+	if *(*byte)(unsafe.Pointer(&probe)) == 0 {
+		return events<<16 | events>>16
+	}
+	return events
+}
+
+// RingAdd is the io_uring(7) variant of add. The mutex must be held.
+func (w *Watch) ringAdd(fd int, interest Interest) error {
+	// poll submissions report errors with their completion only
+	_, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_GETFD, 0)
+	if errno == syscall.EBADF {
+		return ErrBadFD
+	}
+
+	if _, ok := w.fds[fd]; ok {
+		err := w.ring.pollRemove(fd)
+		if err != nil {
+			return opError("IncludeFD", fd, err)
+		}
+	}
+	w.fds[fd] = interest
+	err := w.ring.pollAdd(fd, w.ringEvents(fd), !w.config.oneShot)
+	if err != nil {
+		// the previous poll, if any, is gone
+		delete(w.fds, fd)
+		delete(w.tokens, fd)
+		return opError("IncludeFD", fd, err)
+	}
+	return nil
+}
+
+// RingExclude is the io_uring(7) variant of exclude. The mutex must be held.
+func (w *Watch) ringExclude(fd int) error {
+	if _, ok := w.fds[fd]; !ok {
+		return nil
+	}
+	delete(w.fds, fd)
+	delete(w.tokens, fd)
+	delete(w.ranks, fd)
+	err := w.ring.pollRemove(fd)
+	if err != nil {
+		return opError("ExcludeFD", fd, err)
+	}
+	return nil
+}
+
+// RingReap takes completions until it finds one for the watch list, the wakeup
+// eventfd(2) or a timer. Completions for file descriptors excluded in the mean
+// time are discarded. The return is false when none are available.
+func (w *Watch) ringReap() (fd int, events uint32, err error, ok bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for {
+		if w.closed {
+			// Completions stay in place, such that each waiter wakes.
+			return 0, 0, ErrClosed, true
+		}
+		cqe, ok := w.ring.pop()
+		if !ok {
+			return 0, 0, nil, false
+		}
+		if cqe.userData == ringIgnore {
+			continue
+		}
+		fd := int(cqe.userData)
+		interest, watched := w.fds[fd]
+		watched = watched && interest&paused == 0
+		_, timer := w.timers[fd]
+		if !watched && !timer && fd != w.wakeFD {
+			continue // excluded during the wait
+		}
+		if cqe.res < 0 {
+			errno := syscall.Errno(-cqe.res)
+			if errno == syscall.ECANCELED {
+				continue // replaced by another poll
+			}
+			if errno == syscall.EBADF {
+				delete(w.fds, fd)
+				delete(w.tokens, fd)
+				return fd, 0, ErrBadFD, true
+			}
+			return fd, 0, errno, true
+		}
+		if cqe.flags&ringCQEFMore == 0 && (!w.config.oneShot || !watched) {
+			// multishot terminated, e.g., on completion overflow
+			err := w.ring.pollAdd(fd, w.ringEvents(fd), true)
+			if err != nil {
+				return fd, 0, fmt.Errorf("Watch rearm of file lost on io_uring_enter(2) error %w", err), true
+			}
+		}
+		return fd, uint32(cqe.res), nil, true
+	}
+}
+
+// RingTake is like ringReap, yet it resolves wakeups and timers too, as done
+// by await. The return is false when none are available.
+func (w *Watch) ringTake() (fd int, ready Interest, err error, ok bool) {
+	w.mutex.Lock()
+	err = w.ringErr
+	w.ringErr = nil
+	w.mutex.Unlock()
+	if err != nil {
+		return 0, 0, err, true
+	}
+
+	for {
+		fd, events, err, ok := w.ringReap()
+		switch {
+		case !ok:
+			return 0, 0, nil, false
+		case err == ErrClosed:
+			return 0, 0, err, true
+		case err != nil:
+			return 0, 0, &FDError{FD: fd, Err: err}, true
+		}
+
+		if fd == w.wakeFD {
+			err := w.woken()
+			if err != nil {
+				return 0, 0, err, true
+			}
+			continue // wakeup taken by another routine
+		}
+		if w.isTimer(fd) && !readTimer(fd) {
+			continue // expiry taken by another routine
+		}
+		if w.deadlineFired(fd) {
+			if err := w.takeExpiry(); err != nil {
+				return 0, 0, err, true
+			}
+			continue
+		}
+		ready = readyOf(events)
+		if ready&Hangup != 0 && w.config.excludeOnHangup {
+			w.excludeHungUp(fd)
+		}
+		w.counters.event(fd)
+		w.deadlineReady(fd)
+		return fd, ready, nil, true
+	}
+}
+
+// RingAwait is the io_uring(7) variant of await, with enter done.
+func (w *Watch) ringAwait(timeout time.Duration, sigmask *sigset) (fd int, ready Interest, err error) {
+	// restarts continue with the remainder
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	var expired bool
+	for {
+		fd, ready, err, ok := w.ringTake()
+		if ok {
+			return fd, ready, err
+		}
+		if expired {
+			w.counters.timeouts.Add(1)
+			return 0, 0, ErrTimeout
+		}
+
+		err = w.ring.wait(timeout, sigmask)
+		switch err {
+		case nil:
+			break
+		case syscall.EINTR:
+			w.counters.restart()
+			timeout = remaining(timeout, deadline)
+			continue
+		case syscall.ETIME:
+			// completions may race the expiry
+			expired = true
+		case syscall.EBADF:
+			return 0, 0, ErrClosed
+		default:
+			return 0, 0, w.counters.unavailable(fmt.Errorf("Watch unavailable due io_uring_enter(2) error %w", err))
+		}
+		if w.isClosed() {
+			// Close wins from any results in the same instant.
+			return 0, 0, ErrClosed
+		}
+		timeout = remaining(timeout, deadline)
+	}
+}
+
+// RingAwaitFDs is the io_uring(7) variant of awaitBatch, with enter done.
+func (w *Watch) ringAwaitFDs(buf awaitBuf, timeout time.Duration) (n int, err error) {
+	fd, ready, err := w.ringAwait(timeout, nil)
+	if err != nil {
+		return 0, err
+	}
+	w.putReadable(buf, 0, fd, ready)
+	for n = 1; n < buf.len(); n++ {
+		fd, ready, err, ok := w.ringTake()
+		if !ok {
+			break
+		}
+		if err != nil {
+			// errors are for the next Await to report
+			w.mutex.Lock()
+			w.ringErr = err
+			w.mutex.Unlock()
+			break
+		}
+		w.deadlineReady(fd)
+		w.putReadable(buf, n, fd, ready)
+	}
+	return n, nil
+}
+
+// RingEvents returns the poll events for fd. The mutex must be held.
+func (w *Watch) ringEvents(fd int) uint32 {
+	interest, ok := w.fds[fd]
+	if !ok {
+		return syscall.EPOLLIN // wakeup or timer
+	}
+	events := epollEvents(interest)
+	if w.config.detectHalfClose {
+		events |= syscall.EPOLLRDHUP
+	}
+	return events
+}
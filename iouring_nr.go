@@ -0,0 +1,9 @@
+//go:build linux && !(mips || mipsle || mips64 || mips64le)
+
+package fdmom
+
+// System call numbers, which are missing in package syscall.
+const (
+	sysIOURingSetup = 425
+	sysIOURingEnter = 426
+)
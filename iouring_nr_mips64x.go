@@ -0,0 +1,9 @@
+//go:build linux && (mips64 || mips64le)
+
+package fdmom
+
+// System call numbers for the n64 ABI, which are missing in package syscall.
+const (
+	sysIOURingSetup = 5425
+	sysIOURingEnter = 5426
+)
@@ -0,0 +1,9 @@
+//go:build linux && (mips || mipsle)
+
+package fdmom
+
+// System call numbers for the o32 ABI, which are missing in package syscall.
+const (
+	sysIOURingSetup = 4425
+	sysIOURingEnter = 4426
+)
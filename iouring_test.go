@@ -0,0 +1,210 @@
+//go:build linux
+
+package fdmom
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// SkipWithoutIOURing skips the test when io_uring(7) is not available, such as
+// with kernels before 5.13, or with io_uring_disabled set by sysctl(8).
+func skipWithoutIOURing(t *testing.T) {
+	w, err := OpenWatch(IOURing())
+	if err != nil {
+		t.Skip(err)
+	}
+	w.Close()
+}
+
+func TestIOURing(t *testing.T) {
+	skipWithoutIOURing(t)
+	p := newPipe(t, IOURing())
+	err := p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err = p.w.WriteString("Hello")
+		if err != nil {
+			t.Fatal("test data lost:", err)
+		}
+		fd, ready, err := p.Watch.AwaitFD(holdupMax)
+		if err != nil || fd != p.rFD || ready != Read {
+			t.Fatalf("got FD %#x ready %s with error %v after write %d, want FD %#x ready Read",
+				fd, ready, err, i+1, p.rFD)
+		}
+		// multishot polls are edge-triggered
+		fd, err = p.Watch.AwaitFDWithRead(0)
+		if err != ErrTimeout {
+			t.Errorf("got FD %#x with error %v without change, want ErrTimeout",
+				fd, err)
+		}
+	}
+
+	err = p.Watch.Wakeup()
+	if err != nil {
+		t.Fatal("wakeup error:", err)
+	}
+	fd, err := p.Watch.AwaitFDWithRead(holdupMax)
+	if err != ErrWoken {
+		t.Errorf("got FD %#x with error %v after Wakeup, want ErrWoken", fd, err)
+	}
+
+	err = p.Watch.ExcludeFD(p.rFD)
+	if err != nil {
+		t.Fatal("exclude error:", err)
+	}
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	fd, err = p.Watch.AwaitFDWithRead(10 * time.Millisecond)
+	if err != ErrTimeout {
+		t.Errorf("got FD %#x with error %v after exclude, want ErrTimeout", fd, err)
+	}
+
+	const delay = 10 * time.Millisecond
+	id, err := p.Watch.AddTimer(delay, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		got, err := p.Watch.AwaitFDWithRead(delay + holdupMax)
+		if err != nil || got != id {
+			t.Fatalf("periodic timer expiry %d got FD %#x with error %v, want timer %#x",
+				i+1, got, err, id)
+		}
+	}
+	err = p.Watch.RemoveTimer(id)
+	if err != nil {
+		t.Error("remove periodic timer:", err)
+	}
+	got, err := p.Watch.AwaitFDWithRead(2 * delay)
+	if err != ErrTimeout {
+		t.Errorf("removed periodic timer got FD %#x with error %v, want ErrTimeout",
+			got, err)
+	}
+}
+
+func TestIOURingOneShot(t *testing.T) {
+	skipWithoutIOURing(t)
+	p := newPipe(t, IOURing(), OneShot())
+	err := p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		fd, err := p.Watch.AwaitFDWithRead(holdupMax)
+		if err != nil || fd != p.rFD {
+			t.Fatalf("got FD %#x with error %v, want FD %#x", fd, err, p.rFD)
+		}
+		fd, err = p.Watch.AwaitFDWithRead(0)
+		if err != ErrTimeout {
+			t.Errorf("got FD %#x with error %v while disarmed, want ErrTimeout",
+				fd, err)
+		}
+		// single-shot polls complete right away when ready
+		err = p.Watch.RearmFD(p.rFD)
+		if err != nil {
+			t.Fatal("rearm error:", err)
+		}
+	}
+}
+
+func TestIOURingCloseDuringAwaits(t *testing.T) {
+	skipWithoutIOURing(t)
+	p := newPipe(t, IOURing())
+
+	const routineCount = 4
+	done := make(chan error, routineCount)
+	for i := 0; i < routineCount; i++ {
+		go func() {
+			_, err := p.Watch.AwaitFDWithRead(-1)
+			done <- err
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	err := p.Watch.Close()
+	if err != nil {
+		t.Error("close error:", err)
+	}
+
+	timeout := time.After(holdupMax)
+	for i := 0; i < routineCount; i++ {
+		select {
+		case err := <-done:
+			if err != ErrClosed {
+				t.Errorf("await got error %v, want ErrClosed", err)
+			}
+		case <-timeout:
+			t.Fatalf("%d awaits still running after close", routineCount-i)
+		}
+	}
+}
+
+// Errors after the first completion of a batch go to the next Await.
+func TestIOURingBatchError(t *testing.T) {
+	skipWithoutIOURing(t)
+	p := newPipe(t, IOURing())
+	var fds [2]int
+	if err := syscall.Pipe2(fds[:], syscall.O_CLOEXEC); err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fds[0])
+	defer syscall.Close(fds[1])
+
+	err := p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	err = p.Watch.IncludeFDWithDeadline(fds[0], time.Now().Add(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// completion of the pipe precedes the one of the timer
+	time.Sleep(10*time.Millisecond + holdupMax/2)
+
+	buf := make([]int, 4)
+	n, err := p.Watch.AwaitFDs(buf, 0)
+	if err != nil || n != 1 || buf[0] != p.rFD {
+		t.Fatalf("got %d with error %v, want FD %#x only", n, err, p.rFD)
+	}
+	fd, err := p.Watch.AwaitFDWithRead(0)
+	var fdErr *FDError
+	if !errors.As(err, &fdErr) || fdErr.FD != fds[0] || !errors.Is(err, ErrTimeout) {
+		t.Errorf("got FD %#x with error %v after batch, want an *FDError with ErrTimeout for FD %#x",
+			fd, err, fds[0])
+	}
+}
+
+func TestIOURingExcludeRank(t *testing.T) {
+	skipWithoutIOURing(t)
+	p := newPipe(t, IOURing())
+	err := p.Watch.IncludeFDRank(p.rFD, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Watch.ExcludeFD(p.rFD)
+	if err != nil {
+		t.Fatal("exclude error:", err)
+	}
+	p.Watch.mutex.Lock()
+	_, hasRank := p.Watch.ranks[p.rFD]
+	p.Watch.mutex.Unlock()
+	if hasRank {
+		t.Error("rank remains after exclude")
+	}
+}
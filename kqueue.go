@@ -3,29 +3,244 @@
 package fdmom
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"net"
+	"os"
+	"sync"
 	"syscall"
 	"time"
+	"unsafe"
 )
 
+// EventBatchSize is the default number of events read per kevent(2). Rotation
+// with RoundRobin is exact for up to this many file descriptors ready at once.
+const eventBatchSize = 64
+
 // Watch monitors a list of files for read availability.
 type Watch struct {
-	queueFD    int
-	roundRobin int
+	queueFD int
+	wakeFDs [2]int // pipe(2) read and write end
+	config  config
+
+	mutex     sync.Mutex
+	closed    bool
+	done      chan struct{}        // closed on Close
+	waiters   int                  // number of Awaits in progress
+	cursor    int                  // identifier returned last with RoundRobin
+	fds       map[int]Interest     // watch list
+	tokens    map[int]uint64       // from IncludeFDToken
+	ranks     map[int]int          // from IncludeFDRank
+	hungUp    map[int]uint64       // tokens for the terminal event from ExcludeOnHangup
+	children  map[int]*Watch       // from IncludeWatch
+	listeners map[int]net.Listener // from IncludeListener
+	timers    map[int]struct{}     // EVFILT_TIMER identifiers
+	timerNext int                  // negative sequence of timer identifiers
+
+	vnodes      map[int]VnodeNote // EVFILT_VNODE registrations
+	vnodesFired map[int]VnodeNote // notes pending for VnodeNotes
+	paths       map[int]PathOp    // descriptors from WatchPath
+	procs       map[int]int       // IncludeProcess identifiers per pid
+
+	signals      map[int]int         // IncludeSignals identifiers per number
+	signalsFired map[int][]os.Signal // pending for ReadSignals
+	posted       []uint64            // from Post, pending for AwaitEvent
+
+	changes    []syscall.Kevent_t // pending with CoalesceChanges
+	changeErrs []error            // failed changes for the next Await
+	stashed    []syscall.Kevent_t // read ahead with EdgeTriggered, OneShot or EventBatch
+
+	readySince map[eventKey]uint64 // sequence number per registration with ReadyOrder
+	readyNext  uint64              // next sequence number for ReadyOrder
+
+	counters  counters
+	deadlines deadlines // from SetFDDeadline
+
+	eventsOnce sync.Once
+	events     chan int
 }
 
 // OpenWatch starts with an empty file list.
-func OpenWatch() (*Watch, error) {
+func OpenWatch(opts ...Option) (*Watch, error) {
+	var c config
+	for _, o := range opts {
+		o(&c)
+	}
+
+	// kqueue(2) descriptors don't survive fork(2), yet exec(2) is pinned
+	// explicitly nonetheless.
+	syscall.ForkLock.RLock()
 	fd, err := syscall.Kqueue()
+	if err == nil && !c.keepOnExec {
+		syscall.CloseOnExec(fd)
+	}
+	syscall.ForkLock.RUnlock()
 	if err != nil {
 		return nil, fmt.Errorf("no watch due kqueue(2) error %w", err)
 	}
+	w := &Watch{
+		queueFD: fd,
+		done:    make(chan struct{}),
+		fds:     make(map[int]Interest),
+		timers:  make(map[int]struct{}),
+
+		vnodes:      make(map[int]VnodeNote),
+		vnodesFired: make(map[int]VnodeNote),
+		config:      c,
+	}
+	if w.config.countPerFD {
+		w.counters.perFD = make(map[int]uint64)
+	}
+	w.counters.diag = w.config.diagnostics
+	if w.config.trackLatency {
+		w.counters.latency = &latencyTracker{
+			slowGap:   w.config.slowGap,
+			slowEvent: w.config.slowEvent,
+		}
+	}
 
-	return &Watch{queueFD: fd}, nil
+	// EVFILT_USER is not available on all platforms.
+	syscall.ForkLock.RLock()
+	err = syscall.Pipe(w.wakeFDs[:])
+	if err == nil {
+		syscall.CloseOnExec(w.wakeFDs[0])
+		syscall.CloseOnExec(w.wakeFDs[1])
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("no watch due pipe(2) error %w", err)
+	}
+	syscall.SetNonblock(w.wakeFDs[0], true)
+	syscall.SetNonblock(w.wakeFDs[1], true)
+
+	var change syscall.Kevent_t
+	syscall.SetKevent(&change, w.wakeFDs[0], syscall.EVFILT_READ, syscall.EV_ADD)
+	errno, err := w.apply(&change)
+	if err == nil && errno != 0 {
+		err = errno
+	}
+	if err != nil {
+		w.release()
+		return nil, fmt.Errorf("no watch due kevent(2) error %w", err)
+	}
+	if w.config.sweepInterval > 0 {
+		go w.sweep(w.config.sweepInterval)
+	}
+	return w, nil
 }
 
-// Close implements the io.Closer interface.
+// Close implements the io.Closer interface. Any Awaits in progress return with
+// ErrClosed, including those with results from the same instant. The kqueue(2)
+// descriptor is released on return of the last Await, in which case any error
+// from close(2) is lost.
 func (w *Watch) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	close(w.done)
+	w.posted = nil
+
+	for fd := range w.fds {
+		delete(w.fds, fd)
+		delete(w.tokens, fd)
+		delete(w.ranks, fd)
+	}
+	for id := range w.timers {
+		delete(w.timers, id)
+	}
+	for fd := range w.vnodes {
+		delete(w.vnodes, fd)
+	}
+	for fd := range w.vnodesFired {
+		delete(w.vnodesFired, fd)
+	}
+	w.closePaths()
+	for pid := range w.procs {
+		delete(w.procs, pid)
+	}
+	for signo := range w.signals {
+		delete(w.signals, signo)
+	}
+	for id := range w.signalsFired {
+		delete(w.signalsFired, id)
+	}
+	w.changes = nil
+	w.changeErrs = nil
+	w.stashed = nil
+
+	if w.waiters != 0 {
+		// The pipe(2) is never read after Close, which means
+		// that it wakes each Await in progress.
+		w.wake()
+		return nil
+	}
+	return w.release()
+}
+
+// File returns a duplicate of the kqueue(2) descriptor. Closing the file does not
+// affect the Watch, and vise versa. The descriptor can be included in another
+// Watch, which reports it as readable while any events are pending.
+//
+// Kqueue(2) descriptors are not inherited by fork(2), i.e., the duplicate is of
+// no use to a child process.
+func (w *Watch) File() (*os.File, error) {
+	if !w.enter() {
+		return nil, ErrClosed
+	}
+	defer w.leave()
+
+	fd, err := dupCloseOnExec(w.queueFD)
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), "kqueue(2)"), nil
+}
+
+// FD returns the kqueue(2) descriptor for an outer event loop to include, such
+// as GLib, libev or another Watch. Readable means that an Await has results.
+// Events read ahead with EdgeTriggered, OneShot or EventBatch do not count,
+// which is why an outer loop should Await with a zero timeout until ErrTimeout.
+// The Watch keeps ownership, i.e., the number is void after Close. The return
+// is -1 once closed.
+func (w *Watch) FD() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return -1
+	}
+	return w.queueFD
+}
+
+// SyscallConn provides access to the kqueue(2) descriptor without ownership.
+// The descriptor remains valid for the duration of Control, even with Close in
+// the mean time. Use of the descriptor concurrent to Await is undefined. Read
+// waits for pending events, and Write is not supported.
+func (w *Watch) SyscallConn() (syscall.RawConn, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return nil, ErrClosed
+	}
+	return rawConn{w: w, fd: w.queueFD}, nil
+}
+
+// String returns a summary of the state, including the watch list, for
+// debugging. It is safe for use during an Await.
+func (w *Watch) String() string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return describe("kqueue(2)", w.queueFD, w.closed, w.wakeFDs[:], w.fds, w.timers)
+}
+
+// Release frees the kernel resources of a closed Watch.
+func (w *Watch) release() error {
+	syscall.Close(w.wakeFDs[0])
+	syscall.Close(w.wakeFDs[1])
 	err := syscall.Close(w.queueFD)
 	if err != nil && err != syscall.EBADF {
 		return fmt.Errorf("Watch stuck on close(2) of kqueue(2) error %w", err)
@@ -33,88 +248,1515 @@ func (w *Watch) Close() error {
 	return nil
 }
 
+// Wake interrupts an Await in progress, if any, or the next one otherwise.
+func (w *Watch) wake() {
+	syscall.Write(w.wakeFDs[1], []byte{1})
+}
+
+// WakeReadFD returns the descriptor which becomes readable on wakeup.
+func (w *Watch) wakeReadFD() int { return w.wakeFDs[0] }
+
+// Woken handles a wakeup event. The return is either ErrClosed or ErrWoken, or
+// nil when another routine took the wakeup already.
+func (w *Watch) woken() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+
+	// drain all to prevent repeated wakeups
+	var buf [64]byte
+	var took bool
+	for {
+		n, err := syscall.Read(w.wakeFDs[0], buf[:])
+		if err == syscall.EINTR {
+			continue
+		}
+		if n <= 0 {
+			break
+		}
+		took = true
+	}
+	if !took {
+		return nil
+	}
+	return ErrWoken
+}
+
+// Enter registers an Await in progress. The return is false when closed.
+func (w *Watch) enter() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return false
+	}
+	w.waiters++
+	return true
+}
+
+// Leave unregisters an Await in progress, and it releases the kernel resources
+// when Close is pending on its completion.
+func (w *Watch) leave() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.waiters--
+	if w.closed && w.waiters == 0 {
+		w.release()
+	}
+}
+
 // AwaitFDWithRead blocks until it finds a file descriptor with read available
 // per direct. Positive timeout values, including zero for non-blocking, cause
 // an ErrTimeout on expiry. Negative timeouts block indefinitely.
 func (w *Watch) AwaitFDWithRead(timeout time.Duration) (fd int, err error) {
+	fd, _, err = w.AwaitFD(timeout)
+	return fd, err
+}
+
+// AwaitFD is like AwaitFDWithRead, yet it also reports which conditions were
+// met on the file descriptor. Priority is not reported on FreeBSD nor NetBSD,
+// as they lack EVFILT_EXCEPT. Errors from the kernel on a file descriptor in
+// particular come as an *FDError.
+func (w *Watch) AwaitFD(timeout time.Duration) (fd int, ready Interest, err error) {
+	w.counters.await()
+	if !w.enter() {
+		return 0, 0, ErrClosed
+	}
+	defer w.leave()
+
+	// restarts continue with the remainder
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	for {
+		if err := w.takeExpiry(); err != nil {
+			return 0, 0, err
+		}
+		if fd := w.pendingChild(); fd >= 0 {
+			w.counters.event(fd)
+			w.deadlineReady(fd)
+			return fd, Read, nil
+		}
+
+		ts := syscall.NsecToTimespec(int64(timeout))
+		var tsp *syscall.Timespec
+		if timeout >= 0 {
+			tsp = &ts
+		}
+		// When multiple events are read, then pick one in round-robin to
+		// prevent any descriptor from consuming all attention. Neither
+		// the buffer nor the timespec escape, which keeps them off the
+		// heap without a buffer on the Watch, and without the locking
+		// which concurrent Awaits would need for such.
+		var buf [eventBatchSize]syscall.Kevent_t
+		batch := buf[:]
+		if n := w.config.eventBatch; n > len(buf) {
+			batch = make([]syscall.Kevent_t, n)
+		} else if n > 0 {
+			batch = buf[:n]
+		}
+		var event *syscall.Kevent_t
+		var n int // number of events in batch
+
+		// pending changes go with the first kevent(2)
+		w.mutex.Lock()
+		if len(w.changeErrs) != 0 {
+			errs := w.changeErrs
+			w.changeErrs = nil
+			w.mutex.Unlock()
+			return 0, 0, errors.Join(errs...)
+		}
+		var changes []syscall.Kevent_t
+		if len(w.stashed) != 0 {
+			// read ahead with EdgeTriggered, OneShot or EventBatch
+			batch = batch[:copy(batch[:1], w.stashed)]
+			w.stashed = w.stashed[1:]
+			event, n = &batch[0], 1
+		} else {
+			changes = w.changes
+			w.changes = nil
+		}
+		w.mutex.Unlock()
+
+		if w.config.ordering == KernelOrder {
+			batch = buf[:1]
+		}
+		if len(changes) != 0 {
+			// room for an error on each change
+			batch = make([]syscall.Kevent_t, len(changes)+len(batch))
+		}
+		for event == nil {
+			n, err = syscall.Kevent(w.queueFD, changes, batch, tsp)
+			if err != nil {
+				switch err {
+				case syscall.EINTR:
+					// “When kevent() call fails with EINTR error,
+					// all changes in the changelist have been
+					// applied.” ―the System Calls Manual from FreeBSD
+					changes = nil
+					w.counters.restart()
+					timeout = remaining(timeout, deadline)
+					ts = syscall.NsecToTimespec(int64(timeout))
+					continue
+				case syscall.EBADF:
+					return 0, 0, ErrClosed
+				}
+				return 0, 0, w.counters.unavailable(fmt.Errorf("Watch unavailable due kevent(2) error %w", err))
+			}
+			if w.isClosed() {
+				// Close wins from any results in the same instant.
+				return 0, 0, ErrClosed
+			}
+			if changes != nil {
+				changes = nil
+				n = w.changesApplied(batch[:n])
+				if n == 0 {
+					w.mutex.Lock()
+					errs := w.changeErrs
+					w.changeErrs = nil
+					w.mutex.Unlock()
+					if len(errs) != 0 {
+						return 0, 0, errors.Join(errs...)
+					}
+				}
+			}
+			if n == 0 {
+				w.counters.timeouts.Add(1)
+				return 0, 0, ErrTimeout
+			}
+
+			w.mutex.Lock()
+			event = w.nextEvent(batch[:n], n < len(batch))
+			w.mutex.Unlock()
+			if event.Filter != syscall.EVFILT_READ || int(event.Ident) != w.wakeFDs[0] {
+				break
+			}
+			err = w.woken()
+			if err != nil {
+				return 0, 0, err
+			}
+			// wakeup taken by another routine
+			event = nil
+			timeout = remaining(timeout, deadline)
+			ts = syscall.NsecToTimespec(int64(timeout))
+		}
+		if n > 1 {
+			w.mutex.Lock()
+			w.stash(batch[:n], event)
+			w.mutex.Unlock()
+		}
+
+		if event.Flags&syscall.EV_ERROR != 0 {
+			// The kernel reports an error instead of readiness, such as
+			// for a file descriptor closed without ExcludeFD.
+			return 0, 0, &FDError{
+				FD:  int(event.Ident),
+				Err: fmt.Errorf("Watch await got kevent(2) error %w", syscall.Errno(event.Data)),
+			}
+		}
+
+		if event.Filter == syscall.EVFILT_TIMER && w.deadlineFired(int(event.Ident)) {
+			if err := w.takeExpiry(); err != nil {
+				return 0, 0, err
+			}
+			// expiries discarded; await with the remainder
+			timeout = remaining(timeout, deadline)
+			continue
+		}
+		if event.Filter == syscall.EVFILT_VNODE {
+			w.vnodeFired(event)
+		}
+		ready = readyOf(event)
+		if isFileEvent(event) {
+			// Read, Write and Priority are distinct filters. Merge
+			// any counterpart from the same batch.
+			for i := range batch[:n] {
+				other := &batch[i]
+				if other != event && other.Ident == event.Ident &&
+					other.Flags&syscall.EV_ERROR == 0 && isFileEvent(other) {
+					ready |= readyOf(other)
+				}
+			}
+
+			if ready&Hangup != 0 && w.config.excludeOnHangup {
+				w.excludeHungUp(int(event.Ident))
+			}
+		}
+		fd, ok := w.identOf(event)
+		if !ok {
+			// excluded in the mean time; await with the remainder
+			timeout = remaining(timeout, deadline)
+			continue
+		}
+		w.counters.event(fd)
+		w.deadlineReady(fd)
+		return fd, ready, nil
+	}
+}
+
+// AwaitFDs is like AwaitFDWithRead, yet it fills buf with the file descriptors
+// ready from a single kevent(2), up to len(buf) of them. The return is the
+// number of file descriptors in buf, which is at least one on success. File
+// descriptors ready for both read and Write appear once.
+func (w *Watch) AwaitFDs(buf []int, timeout time.Duration) (n int, err error) {
+	return w.awaitBatch(awaitBuf{fds: buf}, timeout)
+}
+
+// AwaitBatch implements AwaitFDs and AwaitEvents.
+func (w *Watch) awaitBatch(buf awaitBuf, timeout time.Duration) (n int, err error) {
+	w.counters.await()
+	if buf.len() == 0 {
+		return 0, ErrEmptyBuffer
+	}
+	if !w.enter() {
+		return 0, ErrClosed
+	}
+	defer w.leave()
+	if err := w.takeExpiry(); err != nil {
+		return 0, err
+	}
+	if fd := w.pendingChild(); fd >= 0 {
+		w.counters.event(fd)
+		w.deadlineReady(fd)
+		w.put(buf, 0, fd, Read, -1)
+		return 1, nil
+	}
+
 	ts := syscall.NsecToTimespec(int64(timeout))
 	var tsp *syscall.Timespec
 	if timeout >= 0 {
 		tsp = &ts
 	}
+	// restarts continue with the remainder
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
 
-	// When two events are read, then pick one in round-robin to
-	// prevent a single descriptor from consuming all attention.
-	var buf [2]syscall.Kevent_t
-	var bufN int
-ReadEvents:
-	for {
-		bufN, err = syscall.Kevent(w.queueFD, nil, buf[:], tsp)
-		switch err {
-		case nil:
-			break ReadEvents
+	// allocation only for large buffers
+	var stack [64]syscall.Kevent_t
 
-		case syscall.EBADF:
+	// pending changes go with the first kevent(2)
+	w.mutex.Lock()
+	if len(w.changeErrs) != 0 {
+		errs := w.changeErrs
+		w.changeErrs = nil
+		w.mutex.Unlock()
+		return 0, errors.Join(errs...)
+	}
+	if len(w.stashed) != 0 {
+		// read ahead with EdgeTriggered, OneShot or EventBatch
+		stashed := stack[:]
+		if len(w.stashed) > len(stashed) {
+			stashed = make([]syscall.Kevent_t, len(w.stashed))
+		}
+		stashed = stashed[:copy(stashed, w.stashed)]
+		w.stashed = w.stashed[:0]
+		w.rankSort(stashed)
+		w.mutex.Unlock()
+		n, err := w.collect(buf, stashed)
+		if err != nil || n != 0 {
+			return n, err
+		}
+		w.mutex.Lock()
+	}
+	changes := w.changes
+	w.changes = nil
+	ranked := len(w.ranks) != 0
+	w.mutex.Unlock()
+
+	// Read and write come as distinct events, which may leave buf short
+	// of its capacity. Room for an error on each change could take ready
+	// events beyond capacity. Ranks choose from a batch beyond capacity.
+	batch := stack[:]
+	if len(changes)+buf.len() > len(batch) {
+		batch = make([]syscall.Kevent_t, len(changes)+buf.len())
+	} else if !ranked {
+		batch = batch[:len(changes)+buf.len()]
+	}
+	for {
+		eventN, err := syscall.Kevent(w.queueFD, changes, batch, tsp)
+		if err != nil {
+			switch err {
+			case syscall.EINTR:
+				// all changes in the changelist applied
+				changes = nil
+				w.counters.restart()
+				timeout = remaining(timeout, deadline)
+				ts = syscall.NsecToTimespec(int64(timeout))
+				continue
+			case syscall.EBADF:
+				return 0, ErrClosed
+			}
+			return 0, w.counters.unavailable(fmt.Errorf("Watch unavailable due kevent(2) error %w", err))
+		}
+		if w.isClosed() {
+			// Close wins from any results in the same instant.
 			return 0, ErrClosed
+		}
+		if changes != nil {
+			changes = nil
+			eventN = w.changesApplied(batch[:eventN])
+			if eventN == 0 {
+				w.mutex.Lock()
+				errs := w.changeErrs
+				w.changeErrs = nil
+				w.mutex.Unlock()
+				if len(errs) != 0 {
+					return 0, errors.Join(errs...)
+				}
+			}
+		}
+		if eventN == 0 {
+			w.counters.timeouts.Add(1)
+			return 0, ErrTimeout
+		}
+
+		w.mutex.Lock()
+		w.rankSort(batch[:eventN])
+		w.mutex.Unlock()
+		n, err = w.collect(buf, batch[:eventN])
+		if err != nil {
+			return 0, err
+		}
+		if n != 0 {
+			return n, nil
+		}
+		timeout = remaining(timeout, deadline)
+		ts = syscall.NsecToTimespec(int64(timeout))
+	}
+}
+
+// Collect fills buf with the results from events. The return is the number of
+// results in buf. Events beyond capacity are stashed.
+func (w *Watch) collect(buf awaitBuf, events []syscall.Kevent_t) (n int, err error) {
+	for i := range events {
+		event := &events[i]
+		if event.Flags&syscall.EV_ERROR != 0 {
+			// The kernel reports an error instead of readiness,
+			// such as for a file descriptor closed without
+			// ExcludeFD.
+			return 0, &FDError{
+				FD:  int(event.Ident),
+				Err: fmt.Errorf("Watch await got kevent(2) error %w", syscall.Errno(event.Data)),
+			}
+		}
+		if event.Filter == syscall.EVFILT_READ && int(event.Ident) == w.wakeFDs[0] {
+			err := w.woken()
+			if err != nil {
+				return 0, err
+			}
+			continue // wakeup taken by another routine
+		}
+		fd := int(event.Ident)
+		fileEvent := isFileEvent(event)
+		available := -1
+		if event.Filter == syscall.EVFILT_READ {
+			available = int(event.Data)
+		}
+		if fileEvent && merged(buf, n, fd, readyOf(event), available) {
+			continue // counterpart filter
+		}
+		if event.Filter == syscall.EVFILT_TIMER && w.deadlineFired(fd) {
+			continue // for the next Await to report
+		}
+		fd, ok := w.identOf(event)
+		if !ok {
+			continue // excluded in the mean time
+		}
+		if n >= buf.len() {
+			// level-triggered events come back
+			w.mutex.Lock()
+			w.stash(events[i:i+1], nil)
+			w.mutex.Unlock()
+			continue
+		}
+		if event.Filter == syscall.EVFILT_VNODE {
+			w.vnodeFired(event)
+		}
+		if fileEvent && readyOf(event)&Hangup != 0 && w.config.excludeOnHangup {
+			w.excludeHungUp(fd)
+		}
+		w.counters.event(fd)
+		w.deadlineReady(fd)
+		w.put(buf, n, fd, readyOf(event), available)
+		n++
+	}
+	return n, nil
+}
+
+// Stash keeps the ready events which were read without being returned, other
+// than skip and its counterpart filter, if any. EV_CLEAR and EV_ONESHOT would
+// lose them otherwise. Level-triggered file events come back by themselves,
+// which is why the stash is for EdgeTriggered, Edge and OneShot only, unless
+// EventBatch asks for read ahead. Timers, signals, processes and vnodes are
+// always kept, as their filters consume each event. The mutex must be held.
+func (w *Watch) stash(events []syscall.Kevent_t, skip *syscall.Kevent_t) {
+	all := w.config.edgeTriggered || w.config.oneShot || w.config.eventBatch > 1
+	for i := range events {
+		e := &events[i]
+		switch {
+		case !all && isFileEvent(e) && w.fds[int(e.Ident)]&Edge == 0:
+			continue // level-triggered
+		case e.Flags&syscall.EV_ERROR != 0:
+			continue // not a ready event
+		case e.Filter == syscall.EVFILT_READ && int(e.Ident) == w.wakeFDs[0]:
+			continue // level-triggered
+		case e == skip:
+			continue
+		case skip != nil && e.Ident == skip.Ident && isFileEvent(e) && isFileEvent(skip):
+			continue // merged
+		}
+		w.stashed = append(w.stashed, *e)
+	}
+}
+
+// WithReceipt sets EV_RECEIPT on each change, for kevent(2) without events ready
+// in return. Change-only calls need room for a receipt per change, and no more.
+func withReceipt(changes []syscall.Kevent_t) {
+	for i := range changes {
+		changes[i].Flags |= evReceipt
+	}
+}
+
+// HasReadAhead returns whether any events are stashed.
+func (w *Watch) hasReadAhead() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return len(w.stashed) != 0
+}
+
+// Unstash discards any events stashed for ident, either from EVFILT_READ and
+// EVFILT_WRITE, or from the other filters. The mutex must be held.
+func (w *Watch) unstash(ident int, file bool) {
+	n := 0
+	for i := range w.stashed {
+		e := &w.stashed[i]
+		if int(e.Ident) == ident && isFileEvent(e) == file {
+			continue
+		}
+		w.stashed[n] = *e
+		n++
+	}
+	w.stashed = w.stashed[:n]
+}
+
+// UnstashFilter discards any events stashed for ident with filter. The mutex
+// must be held.
+func (w *Watch) unstashFilter(filter, ident int) {
+	// Filter has a distinct type on NetBSD.
+	var match syscall.Kevent_t
+	syscall.SetKevent(&match, ident, filter, 0)
+	n := 0
+	for i := range w.stashed {
+		e := &w.stashed[i]
+		if e.Filter == match.Filter && e.Ident == match.Ident {
+			continue
+		}
+		w.stashed[n] = *e
+		n++
+	}
+	w.stashed = w.stashed[:n]
+}
+
+// IdentOf returns the identifier for the Await methods to report. The return is
+// false for events excluded in the mean time.
+func (w *Watch) identOf(event *syscall.Kevent_t) (id int, ok bool) {
+	switch event.Filter {
+	case syscall.EVFILT_PROC:
+		return w.procID(event)
+	case syscall.EVFILT_SIGNAL:
+		return w.signalFired(event)
+	}
+	return int(event.Ident), true
+}
+
+// ExceptInterest are the conditions for EVFILT_EXCEPT, if any.
+const exceptInterest = (Read | Priority | Hangup) &^ readInterest
 
-		case syscall.EINTR:
+// NextEvent returns the event to report from a batch, conform the Ordering.
+// Complete batches have each event ready, i.e., none were left out due capacity.
+// The mutex must be held.
+func (w *Watch) nextEvent(events []syscall.Kevent_t, complete bool) *syscall.Kevent_t {
+	switch w.config.ordering {
+	case ReadyOrder:
+		return w.nextReady(events, complete)
+	case RankOrder:
+		if len(w.ranks) != 0 {
+			return w.nextRanked(events)
+		}
+	}
+	// The cursor rotates over the identifiers, rather than over positions
+	// in the batch, so each of them gets a turn, also when the kernel
+	// reorders.
+	event := nextInTurn(events, w.cursor)
+	w.cursor = int(event.Ident)
+	return event
+}
+
+// EventKey identifies a kevent(2) registration.
+type eventKey struct {
+	ident  int
+	filter int
+}
+
+// KeyOf returns the registration of event.
+func keyOf(event *syscall.Kevent_t) eventKey {
+	return eventKey{int(event.Ident), int(event.Filter)}
+}
+
+// NextReady returns the event found ready first. Registrations which are absent
+// from a complete batch are not ready anymore, i.e., they lose their turn. The
+// mutex must be held.
+func (w *Watch) nextReady(events []syscall.Kevent_t, complete bool) *syscall.Kevent_t {
+	if w.readySince == nil {
+		w.readySince = make(map[eventKey]uint64)
+	}
+	var first *syscall.Kevent_t
+	var firstSeq uint64
+	for i := range events {
+		key := keyOf(&events[i])
+		seq, ok := w.readySince[key]
+		if !ok {
+			seq = w.readyNext
+			w.readyNext++
+			w.readySince[key] = seq
+		}
+		if first == nil || seq < firstSeq {
+			first, firstSeq = &events[i], seq
+		}
+	}
+
+	if complete && len(w.readySince) > len(events) {
+	Prune:
+		for key := range w.readySince {
+			for i := range events {
+				if keyOf(&events[i]) == key {
+					continue Prune
+				}
+			}
+			delete(w.readySince, key)
+		}
+	}
+	// next in line when ready again
+	delete(w.readySince, keyOf(first))
+	return first
+}
+
+// NextRanked returns the event with the highest rank from IncludeFDRank, round
+// robin between equal ranks. The mutex must be held.
+func (w *Watch) nextRanked(events []syscall.Kevent_t) *syscall.Kevent_t {
+	top := math.MinInt
+	for i := range events {
+		if rank := w.rankOf(&events[i]); rank > top {
+			top = rank
+		}
+	}
+
+	// nextInTurn with events of the top rank only
+	var next, lowest *syscall.Kevent_t
+	for i := range events {
+		e := &events[i]
+		if w.rankOf(e) != top {
 			continue
 		}
+		ident := int(e.Ident)
+		if lowest == nil || ident < int(lowest.Ident) {
+			lowest = e
+		}
+		if ident > w.cursor && (next == nil || ident < int(next.Ident)) {
+			next = e
+		}
+	}
+	if next == nil {
+		next = lowest
+	}
+	w.cursor = int(next.Ident)
+	return next
+}
 
-		return 0, fmt.Errorf("Watch unavailable due kevent(2) error %w", err)
+// RankSort orders events by their rank from IncludeFDRank, highest first. Equal
+// ranks keep the order of the kernel. The mutex must be held.
+func (w *Watch) rankSort(events []syscall.Kevent_t) {
+	if len(w.ranks) == 0 {
+		return
 	}
+	// insertion sort is stable, and it does not allocate
+	for i := 1; i < len(events); i++ {
+		e := events[i]
+		rank := w.rankOf(&e)
+		j := i
+		for ; j > 0 && w.rankOf(&events[j-1]) < rank; j-- {
+			events[j] = events[j-1]
+		}
+		events[j] = e
+	}
+}
 
-	switch bufN {
-	case 0:
-		return 0, ErrTimeout
-	case 1:
-		return int(buf[0].Ident), nil
-	default: // 2
-		w.roundRobin++
-		return int(buf[w.roundRobin&1].Ident), nil
+// RankOf returns the rank from IncludeFDRank. Identifiers other than file
+// descriptors have the default rank. The mutex must be held.
+func (w *Watch) rankOf(event *syscall.Kevent_t) int {
+	if !isFileEvent(event) {
+		return 0
+	}
+	return w.ranks[int(event.Ident)]
+}
+
+// NextInTurn returns the event with the lowest identifier above cursor, or the
+// lowest identifier overall when none are above.
+func nextInTurn(events []syscall.Kevent_t, cursor int) *syscall.Kevent_t {
+	var next, lowest *syscall.Kevent_t
+	for i := range events {
+		e := &events[i]
+		ident := int(e.Ident)
+		if lowest == nil || ident < int(lowest.Ident) {
+			lowest = e
+		}
+		if ident > cursor && (next == nil || ident < int(next.Ident)) {
+			next = e
+		}
+	}
+	if next != nil {
+		return next
+	}
+	return lowest
+}
+
+// IsExceptEvent returns whether the filter is EVFILT_EXCEPT.
+func isExceptEvent(e *syscall.Kevent_t) bool {
+	return exceptInterest != 0 && e.Filter == evFiltExcept
+}
+
+// Fionread is the FIONREAD request of ioctl(2), which is the same on each of
+// the BSDs.
+const fionread = 0x4004667f
+
+// Readable returns the number of bytes available for read on fd, or -1 when
+// unknown. Kqueue(2) reports the count with EVFILT_READ already, which makes
+// this the fallback for AwaitEvent only. Identifiers other than the watch list
+// are not file descriptors, such as the ones from IncludeTimer.
+func (w *Watch) readable(fd int, ready Interest) int {
+	if ready&Read == 0 {
+		return -1
+	}
+	w.mutex.Lock()
+	_, ok := w.fds[fd]
+	w.mutex.Unlock()
+	if !ok {
+		return -1
+	}
+	var n int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), fionread, uintptr(unsafe.Pointer(&n)))
+	if errno != 0 {
+		return -1 // not supported by the file type
+	}
+	return int(n)
+}
+
+// IsFileEvent returns whether the filter is EVFILT_READ, EVFILT_WRITE or
+// EVFILT_EXCEPT.
+func isFileEvent(e *syscall.Kevent_t) bool {
+	return e.Filter == syscall.EVFILT_READ || e.Filter == syscall.EVFILT_WRITE || isExceptEvent(e)
+}
+
+// Merged returns whether fd is in the first n results of buf already. Events
+// get ready added, and available when known.
+func merged(buf awaitBuf, n, fd int, ready Interest, available int) bool {
+	if buf.fds != nil {
+		for _, v := range buf.fds[:n] {
+			if v == fd {
+				return true
+			}
+		}
+		return false
+	}
+	for i := range buf.events[:n] {
+		if buf.events[i].FD == fd {
+			buf.events[i].Ready |= ready
+			if available >= 0 {
+				buf.events[i].Available = available
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// ReadyOf maps a kevent(2) event to its respective conditions.
+func readyOf(event *syscall.Kevent_t) Interest {
+	ready := Read
+	switch {
+	case event.Filter == syscall.EVFILT_WRITE:
+		ready = Write
+	case isExceptEvent(event):
+		return Priority
+	}
+	if event.Filter == syscall.EVFILT_READ || event.Filter == syscall.EVFILT_WRITE {
+		if event.Flags&syscall.EV_EOF != 0 {
+			ready |= Hangup
+			// socket error pending, if any
+			if event.Fflags != 0 {
+				ready |= Error
+			}
+		}
 	}
+	return ready
 }
 
 // IncludeFD adds the file descriptor to the watch list. Duplicates are ignored
-// silently.
+// silently. Kqueue(2) drops registrations on close(2) of the file descriptor,
+// which means that recycled file descriptors never get events from before.
 func (w *Watch) IncludeFD(fd int) error {
-	fd64 := uint64(uint(fd))
-	events := [2]syscall.Kevent_t{{
-		Ident:  fd64,
-		Flags:  syscall.EV_ADD,
-		Filter: syscall.EVFILT_READ,
-	}, {}}
+	return w.include(fd, Read)
+}
+
+// IncludeFDForPriority adds the file descriptor to the watch list for both read
+// and Priority availability. Descriptors already on the watch list get their
+// Priority interest added. Out-of-band data is detected with EVFILT_EXCEPT and
+// NOTE_OOB on Darwin, DragonFly and OpenBSD. FreeBSD and NetBSD have no such
+// filter, in which case the file descriptor is included for read only.
+func (w *Watch) IncludeFDForPriority(fd int) error {
+	return w.include(fd, Read|Priority)
+}
+
+// IncludeFDForWrite adds the file descriptor to the watch list for Write
+// availability. Descriptors already on the watch list get their Write interest
+// added. Write availability is level-triggered too, i.e., a file descriptor
+// reports for as long as its buffer has space, which is most of the time.
+//
+// Kqueue(2) has a distinct filter for Write. Await merges the two filters into
+// one report when both are ready at the same time.
+func (w *Watch) IncludeFDForWrite(fd int) error {
+	return w.include(fd, Write)
+}
+
+// Include adds interest to the watch list.
+func (w *Watch) include(fd int, interest Interest) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	return w.counters.include("include", fd, interest, w.add(fd, interest|w.fds[fd]))
+}
+
+// IncludeFDInterest sets the conditions of interest for the file descriptor,
+// which replaces any interest from before. Read and Hangup expand to
+// EVFILT_READ, Write expands to EVFILT_WRITE, and Priority expands to
+// EVFILT_EXCEPT, or to EVFILT_READ when not available. The Edge and Level
+// modes select EV_CLEAR for the file descriptor, over the EdgeTriggered option.
+// A change of mode registers the filters anew. Zero interest is equivalent to
+// ExcludeFD.
+func (w *Watch) IncludeFDInterest(fd int, interest Interest) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	if interest&(Read|Priority|Hangup|Write) == 0 {
+		return w.exclude(fd)
+	}
+	return w.counters.include("include", fd, interest, w.add(fd, interest|w.fds[fd]&paused))
+}
+
+// Add applies EVFILT_READ and EVFILT_WRITE for the interest, which replaces any
+// interest from before. The mutex must be held.
+func (w *Watch) add(fd int, interest Interest) error {
+	changes, n := w.changesFor(fd, w.fds[fd], interest)
+	if w.config.coalesceChanges {
+		w.changes = append(w.changes, changes[:n]...)
+		w.fds[fd] = interest
+		return nil
+	}
+	for i := range changes[:n] {
+		errno, err := w.apply(&changes[i])
+		if err != nil {
+			if err == syscall.EBADF {
+				return ErrClosed
+			}
+			return opError("IncludeFD", fd, err)
+		}
+		switch errno {
+		case 0, syscall.ENOENT:
+			break // ENOENT from EV_DELETE only
+		case syscall.EBADF:
+			return ErrBadFD
+		case syscall.ENOMEM:
+			return ErrTooManyWatches
+		default:
+			return opError("IncludeFD", fd, errno)
+		}
+	}
+	w.fds[fd] = interest
+	return nil
+}
+
+// InterestChanges returns the EV_ADD and EV_DELETE for each filter to go from
+// interest before to interest after. Additions precede deletion, and they get
+// addFlags on top.
+func interestChanges(fd int, before, after Interest, addFlags int) (changes [3]syscall.Kevent_t, n int) {
+	if after&readInterest != 0 {
+		syscall.SetKevent(&changes[n], fd, syscall.EVFILT_READ, syscall.EV_ADD|addFlags)
+		n++
+	}
+	if after&Write != 0 {
+		syscall.SetKevent(&changes[n], fd, syscall.EVFILT_WRITE, syscall.EV_ADD|addFlags)
+		n++
+	}
+	if after&exceptInterest != 0 {
+		syscall.SetKevent(&changes[n], fd, evFiltExcept, syscall.EV_ADD|addFlags)
+		changes[n].Fflags = noteOOB
+		n++
+	}
+	if after&readInterest == 0 && before&readInterest != 0 {
+		syscall.SetKevent(&changes[n], fd, syscall.EVFILT_READ, syscall.EV_DELETE)
+		n++
+	}
+	if after&Write == 0 && before&Write != 0 {
+		syscall.SetKevent(&changes[n], fd, syscall.EVFILT_WRITE, syscall.EV_DELETE)
+		n++
+	}
+	if after&exceptInterest == 0 && before&exceptInterest != 0 {
+		syscall.SetKevent(&changes[n], fd, evFiltExcept, syscall.EV_DELETE)
+		n++
+	}
+	return changes, n
+}
+
+// ChangesFor returns the EV_ADD and EV_DELETE to go from interest before to
+// interest after, like interestChanges does. Kqueue(2) keeps the flags of each
+// filter from its first EV_ADD, which is why a change of mode deletes all filters
+// before they are added again.
+func (w *Watch) changesFor(fd int, before, after Interest) (changes [6]syscall.Kevent_t, n int) {
+	if before != 0 && w.edgeTriggered(before) != w.edgeTriggered(after) {
+		deletes, deleteN := interestChanges(fd, before, 0, 0)
+		n = copy(changes[:], deletes[:deleteN])
+		before = 0
+	}
+	adds, addN := interestChanges(fd, before, after, w.addFlags(after))
+	n += copy(changes[n:], adds[:addN])
+	return changes, n
+}
+
+// AddFlags returns the flags for each EV_ADD of a file descriptor with interest.
+func (w *Watch) addFlags(interest Interest) int {
+	var flags int
+	if w.edgeTriggered(interest) {
+		flags |= syscall.EV_CLEAR
+	}
+	if interest&paused != 0 {
+		flags |= syscall.EV_DISABLE
+	}
+	if w.config.oneShot {
+		flags |= syscall.EV_ONESHOT
+	}
+	return flags
+}
+
+// ModifyFD sets the conditions of interest for a file descriptor on the watch
+// list, which replaces any interest from before, like IncludeFDInterest does.
+// The EV_ADD and EV_DELETE for EVFILT_READ and EVFILT_WRITE go in a single
+// kevent(2), such that the file descriptor does not leave the watch list in
+// between. Absence from the watch list gets ErrNotWatched. Zero interest is
+// equivalent to ExcludeFD.
+func (w *Watch) ModifyFD(fd int, interest Interest) error {
+	return w.counters.include("modify", fd, interest, w.modify(fd, interest))
+}
+
+// Modify is ModifyFD without accounting.
+func (w *Watch) modify(fd int, interest Interest) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	before, ok := w.fds[fd]
+	if !ok {
+		return ErrNotWatched
+	}
+	if interest&(Read|Priority|Hangup|Write) == 0 {
+		return w.exclude(fd)
+	}
+	interest |= before & paused
+
+	changes, n := w.changesFor(fd, before, interest)
+	if w.config.coalesceChanges {
+		w.changes = append(w.changes, changes[:n]...)
+		w.fds[fd] = interest
+		return nil
+	}
+	err := w.flushChanges()
+	if err != nil {
+		return err
+	}
+
+	// room for an error on each change
+	var events [len(changes)]syscall.Kevent_t
 
 	// zero value indicates an immediate timeout
 	var noBlock syscall.Timespec
 
-	n, err := syscall.Kevent(w.queueFD, events[:1], events[1:], &noBlock)
-	// “When kevent() call fails with EINTR error, all changes in the
-	// changelist have been applied.”
-	// ―the System Calls Manual from FreeBSD
-	if err != nil && err != syscall.EINTR {
-		if err == syscall.EBADF {
-			return ErrClosed
+	withReceipt(changes[:n])
+	got, err := syscall.Kevent(w.queueFD, changes[:n], events[:n], &noBlock)
+	switch err {
+	case nil:
+		break
+	case syscall.EINTR:
+		got = 0 // all changes applied
+	case syscall.EBADF:
+		return ErrClosed
+	default:
+		return opError("ModifyFD", fd, err)
+	}
+	for i := range events[:got] {
+		e := &events[i]
+		if e.Flags&syscall.EV_ERROR == 0 || e.Data == 0 {
+			continue // not an error
 		}
-		return fmt.Errorf("Watch IncludeFD lost on kevent(2) error %w", err)
+		switch errno := syscall.Errno(e.Data); errno {
+		case syscall.ENOENT:
+			break // EV_DELETE only
+		case syscall.EBADF:
+			// closed files leave kqueue(2) automatically
+			delete(w.fds, fd)
+			delete(w.tokens, fd)
+			delete(w.ranks, fd)
+			return ErrBadFD
+		case syscall.ENOMEM:
+			return ErrTooManyWatches
+		default:
+			return opError("ModifyFD", fd, errno)
+		}
+	}
+	w.stash(events[:got], nil)
+	w.fds[fd] = interest
+	return nil
+}
+
+// IncludeFDs adds each file descriptor to the watch list like IncludeFD does,
+// yet with a single kevent(2). Failures do not stop the other file descriptors
+// from inclusion. The errors are joined, with an *FDError for each file
+// descriptor rejected.
+func (w *Watch) IncludeFDs(fds ...int) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	if len(fds) == 0 {
+		return nil
+	}
+
+	err := w.flushChanges()
+	if err != nil {
+		return err
+	}
+
+	changes := make([]syscall.Kevent_t, len(fds))
+	for i, fd := range fds {
+		syscall.SetKevent(&changes[i], fd, syscall.EVFILT_READ, syscall.EV_ADD|w.addFlags(Read|w.fds[fd]))
+	}
+	// room for an error on each change
+	events := make([]syscall.Kevent_t, len(fds))
+
+	// zero value indicates an immediate timeout
+	var noBlock syscall.Timespec
+
+	withReceipt(changes)
+	n, err := syscall.Kevent(w.queueFD, changes, events, &noBlock)
+	switch err {
+	case nil:
+		break
+	case syscall.EINTR:
+		n = 0 // all changes applied
+	case syscall.EBADF:
+		return ErrClosed
+	default:
+		return opError("IncludeFDs", -1, err)
 	}
 
-	if n != 0 && events[1].Ident == fd64 && events[1].Flags&syscall.EV_ERROR != 0 {
-		return fmt.Errorf("Watch IncludeFD denied by kevent(2) with error %w",
-			syscall.Errno(events[1].Data))
+	denied := make(map[int]error)
+	for i := range events[:n] {
+		e := &events[i]
+		if e.Flags&syscall.EV_ERROR == 0 || e.Data == 0 || e.Filter != syscall.EVFILT_READ {
+			continue // not an error
+		}
+		fd := int(e.Ident)
+		switch errno := syscall.Errno(e.Data); errno {
+		case syscall.EBADF:
+			denied[fd] = ErrBadFD
+		case syscall.ENOMEM:
+			denied[fd] = ErrTooManyWatches
+		default:
+			denied[fd] = opError("IncludeFDs", fd, errno)
+		}
+	}
+	var errs []error
+	for _, fd := range fds {
+		err := w.counters.include("include", fd, Read, denied[fd])
+		if err != nil {
+			errs = append(errs, &FDError{FD: fd, Err: err})
+			continue
+		}
+		w.fds[fd] |= Read
+	}
+	w.stash(events[:n], nil)
+	return errors.Join(errs...)
+}
+
+// PauseFD mutes a file descriptor on the watch list until ResumeFD, without loss
+// of its interest, e.g., for backpressure on a chatty peer. Inclusion and
+// ModifyFD on a paused file descriptor apply with ResumeFD. The filters get
+// EV_DISABLE, and EV_ENABLE on ResumeFD. Absence from the watch list gets
+// ErrNotWatched.
+func (w *Watch) PauseFD(fd int) error {
+	return w.setEnabled(fd, false)
+}
+
+// ResumeFD undoes PauseFD. File descriptors which are not paused are ignored
+// silently. Absence from the watch list gets ErrNotWatched.
+func (w *Watch) ResumeFD(fd int) error {
+	return w.setEnabled(fd, true)
+}
+
+// SetEnabled implements PauseFD and ResumeFD.
+func (w *Watch) setEnabled(fd int, enable bool) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	interest, ok := w.fds[fd]
+	if !ok {
+		return ErrNotWatched
+	}
+	if (interest&paused == 0) == enable {
+		return nil
+	}
+
+	flags, op := syscall.EV_DISABLE, "PauseFD"
+	if enable {
+		flags, op = syscall.EV_ENABLE, "ResumeFD"
+	}
+	changes, n := deleteChanges(fd, w.fds)
+	for i := range changes[:n] {
+		syscall.SetKevent(&changes[i], fd, int(changes[i].Filter), flags)
+	}
+	if w.config.coalesceChanges {
+		w.changes = append(w.changes, changes[:n]...)
+	} else {
+		for i := range changes[:n] {
+			errno, err := w.apply(&changes[i])
+			if err != nil {
+				if err == syscall.EBADF {
+					return ErrClosed
+				}
+				return opError(op, fd, err)
+			}
+			switch errno {
+			case 0:
+				break
+			case syscall.EBADF, syscall.ENOENT:
+				// closed files leave kqueue(2) automatically
+				delete(w.fds, fd)
+				delete(w.tokens, fd)
+				delete(w.ranks, fd)
+				w.unstash(fd, true)
+				return ErrNotWatched
+			default:
+				return opError(op, fd, errno)
+			}
+		}
+	}
+
+	if enable {
+		w.fds[fd] = interest &^ paused
+	} else {
+		w.fds[fd] = interest | paused
+		// ready events are for after ResumeFD
+		w.unstash(fd, true)
 	}
 	return nil
 }
 
+// ExcludeFDs removes each file descriptor from the watch list like ExcludeFD
+// does, yet with a single kevent(2). Failures do not stop the other file
+// descriptors from exclusion. The errors are joined, with an *FDError for each
+// file descriptor rejected.
+func (w *Watch) ExcludeFDs(fds ...int) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	if len(fds) == 0 {
+		return nil
+	}
+
+	err := w.flushChanges()
+	if err != nil {
+		return err
+	}
+
+	changes := make([]syscall.Kevent_t, 0, len(fds))
+	for _, fd := range fds {
+		deletes, n := deleteChanges(fd, w.fds)
+		changes = append(changes, deletes[:n]...)
+	}
+	// room for an error on each change
+	events := make([]syscall.Kevent_t, len(changes))
+
+	// zero value indicates an immediate timeout
+	var noBlock syscall.Timespec
+
+	withReceipt(changes)
+	n, err := syscall.Kevent(w.queueFD, changes, events, &noBlock)
+	switch err {
+	case nil:
+		break
+	case syscall.EINTR:
+		n = 0 // all changes applied
+	case syscall.EBADF:
+		return ErrClosed
+	default:
+		return opError("ExcludeFDs", -1, err)
+	}
+	w.stash(events[:n], nil)
+
+	var errs []error
+	denied := make(map[int]bool)
+	for i := range events[:n] {
+		e := &events[i]
+		if e.Flags&syscall.EV_ERROR == 0 || e.Data == 0 {
+			continue // not an error
+		}
+		fd := int(e.Ident)
+		var err error
+		switch errno := syscall.Errno(e.Data); errno {
+		case syscall.ENOENT:
+			continue // absent
+		case syscall.EBADF:
+			// closed files leave kqueue(2) automatically
+			err = ErrBadFD
+		default:
+			denied[fd] = true
+			err = opError("ExcludeFDs", fd, errno)
+		}
+		errs = append(errs, &FDError{FD: fd, Err: err})
+	}
+	for _, fd := range fds {
+		if !denied[fd] {
+			delete(w.fds, fd)
+			delete(w.tokens, fd)
+			delete(w.ranks, fd)
+			w.unstash(fd, true)
+		}
+	}
+	return errors.Join(errs...)
+}
+
 // ExcludeFD removes the file descriptor from the watch list. Absence is ignored
 // silently.
 func (w *Watch) ExcludeFD(fd int) error {
-	fd64 := uint64(uint(fd))
-	events := [2]syscall.Kevent_t{{
-		Ident:  fd64,
-		Flags:  syscall.EV_DELETE,
-		Filter: syscall.EVFILT_READ,
-	}, {}}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	return w.counters.exclude(fd, w.exclude(fd))
+}
+
+// Forget removes fd from the watch list after close(2). Kqueue(2) drops the
+// registrations by itself. The mutex must be held.
+func (w *Watch) forget(fd int) {
+	delete(w.fds, fd)
+	delete(w.tokens, fd)
+	delete(w.ranks, fd)
+	w.unstash(fd, true)
+
+	// pending changes would fail with EBADF
+	n := 0
+	for i := range w.changes {
+		c := &w.changes[i]
+		if int(c.Ident) == fd && isFileEvent(c) {
+			continue
+		}
+		w.changes[n] = *c
+		n++
+	}
+	w.changes = w.changes[:n]
+}
+
+// Exclude removes fd from the watch list. The mutex must be held.
+func (w *Watch) exclude(fd int) error {
+	defer w.unstash(fd, true)
+	changes, n := deleteChanges(fd, w.fds)
+	if w.config.coalesceChanges {
+		w.changes = append(w.changes, changes[:n]...)
+		delete(w.fds, fd)
+		delete(w.tokens, fd)
+		delete(w.ranks, fd)
+		return nil
+	}
+	for i := range changes[:n] {
+		errno, err := w.apply(&changes[i])
+		if err != nil {
+			if err == syscall.EBADF {
+				return ErrClosed
+			}
+			return opError("ExcludeFD", fd, err)
+		}
+		switch errno {
+		case 0, syscall.ENOENT:
+			break
+		case syscall.EBADF:
+			// closed files leave kqueue(2) automatically
+			delete(w.fds, fd)
+			delete(w.tokens, fd)
+			delete(w.ranks, fd)
+			return ErrBadFD
+		default:
+			return opError("ExcludeFD", fd, errno)
+		}
+	}
+	delete(w.fds, fd)
+	delete(w.tokens, fd)
+	delete(w.ranks, fd)
+	return nil
+}
+
+// DeleteChanges returns the EV_DELETE for each filter of fd in use. Absence
+// from the watch list defaults to EVFILT_READ.
+func deleteChanges(fd int, fds map[int]Interest) (changes [3]syscall.Kevent_t, n int) {
+	interest, ok := fds[fd]
+	if !ok || interest&readInterest != 0 {
+		syscall.SetKevent(&changes[n], fd, syscall.EVFILT_READ, syscall.EV_DELETE)
+		n++
+	}
+	if interest&Write != 0 {
+		syscall.SetKevent(&changes[n], fd, syscall.EVFILT_WRITE, syscall.EV_DELETE)
+		n++
+	}
+	if interest&exceptInterest != 0 {
+		syscall.SetKevent(&changes[n], fd, evFiltExcept, syscall.EV_DELETE)
+		n++
+	}
+	return changes, n
+}
+
+// InternalFD returns whether fd on the watch list belongs to the Watch, rather
+// than to the application. Processes and signals have identifiers of their own
+// with kqueue(2). The mutex must be held.
+func (w *Watch) internalFD(fd int) bool {
+	_, isPath := w.paths[fd]
+	_, isChild := w.children[fd]
+	return isPath || isChild
+}
+
+// Reset removes all file descriptors and timers from the watch list, which is
+// equivalent to a new OpenWatch, only cheaper. Any deadlines from SetFDDeadline,
+// children from IncludeWatch, listeners from IncludeListener, and tokens pending
+// from ExcludeOnHangup go too.
+func (w *Watch) Reset() error {
+	w.deadlines.mutex.Lock()
+	defer w.deadlines.mutex.Unlock()
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	w.resetDeadlines()
+
+	// failures of pending changes are void after reset
+	firstErr := w.flushChanges()
+	w.changeErrs = nil
+
+	for fd := range w.fds {
+		changes, n := deleteChanges(fd, w.fds)
+		var failed bool
+		for i := range changes[:n] {
+			errno, err := w.apply(&changes[i])
+			switch {
+			case err != nil:
+				if err == syscall.EBADF {
+					return ErrClosed
+				}
+				if firstErr == nil {
+					firstErr = opError("Reset", fd, err)
+				}
+				failed = true
+			case errno != 0 && errno != syscall.ENOENT && errno != syscall.EBADF:
+				if firstErr == nil {
+					firstErr = opError("Reset", fd, errno)
+				}
+				failed = true
+			}
+		}
+		if !failed {
+			// closed files leave kqueue(2) automatically
+			delete(w.fds, fd)
+			delete(w.tokens, fd)
+			delete(w.ranks, fd)
+		}
+	}
+	for id := range w.timers {
+		var change syscall.Kevent_t
+		syscall.SetKevent(&change, id, syscall.EVFILT_TIMER, syscall.EV_DELETE)
+		// one-shot timers are gone after expiry
+		_, err := w.apply(&change)
+		if err == syscall.EBADF {
+			return ErrClosed
+		}
+		delete(w.timers, id)
+	}
+	for fd := range w.vnodes {
+		var change syscall.Kevent_t
+		syscall.SetKevent(&change, fd, syscall.EVFILT_VNODE, syscall.EV_DELETE)
+		// closed files leave kqueue(2) automatically
+		_, err := w.apply(&change)
+		if err == syscall.EBADF {
+			return ErrClosed
+		}
+		delete(w.vnodes, fd)
+		delete(w.vnodesFired, fd)
+	}
+	w.resetAssociations()
+	w.closePaths()
+	for pid := range w.procs {
+		var change syscall.Kevent_t
+		syscall.SetKevent(&change, pid, syscall.EVFILT_PROC, syscall.EV_DELETE)
+		// one-shot registrations are gone after exit
+		_, err := w.apply(&change)
+		if err == syscall.EBADF {
+			return ErrClosed
+		}
+		delete(w.procs, pid)
+	}
+	for signo := range w.signals {
+		var change syscall.Kevent_t
+		syscall.SetKevent(&change, signo, syscall.EVFILT_SIGNAL, syscall.EV_DELETE)
+		_, err := w.apply(&change)
+		if err == syscall.EBADF {
+			return ErrClosed
+		}
+		delete(w.signals, signo)
+	}
+	for id := range w.signalsFired {
+		delete(w.signalsFired, id)
+	}
+	// keep events for failed removals only
+	stashN := 0
+	for i := range w.stashed {
+		e := &w.stashed[i]
+		if _, ok := w.fds[int(e.Ident)]; ok && isFileEvent(e) {
+			w.stashed[stashN] = *e
+			stashN++
+		}
+	}
+	w.stashed = w.stashed[:stashN]
+	return firstErr
+}
+
+// AddTimer arms a new timer. Expiry is reported by the Await methods with the
+// timer identifier in place of a file descriptor. Periodic timers repeat with
+// d as their interval. Multiple expiries in between Awaits are reported only
+// once. Use RemoveTimer to release the timer, including the one-shot kind.
+// Darwin and FreeBSD apply d in nanoseconds with NOTE_NSECONDS. The other BSDs
+// round d up to milliseconds.
+func (w *Watch) AddTimer(d time.Duration, periodic bool) (id int, err error) {
+	if d <= 0 {
+		return -1, fmt.Errorf("Watch AddTimer got non-positive duration %s", d)
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return -1, ErrClosed
+	}
+
+	// identifiers count down from -1 to keep clear of file descriptors
+	w.timerNext--
+	id = w.timerNext
+
+	flags := syscall.EV_ADD
+	if !periodic {
+		flags |= syscall.EV_ONESHOT
+	}
+	var change syscall.Kevent_t
+	syscall.SetKevent(&change, id, syscall.EVFILT_TIMER, flags)
+	if noteNSeconds != 0 {
+		change.Fflags = noteNSeconds
+		change.Data = int64(d)
+	} else {
+		// milliseconds round up as they are a minimum guarantee
+		change.Data = int64((d + time.Millisecond - 1) / time.Millisecond)
+	}
+
+	errno, err := w.apply(&change)
+	if err != nil {
+		if err == syscall.EBADF {
+			return -1, ErrClosed
+		}
+		return -1, fmt.Errorf("Watch AddTimer lost on kevent(2) error %w", err)
+	}
+	if errno != 0 {
+		return -1, fmt.Errorf("Watch AddTimer denied by kevent(2) with error %w", errno)
+	}
+	w.timers[id] = struct{}{}
+	return id, nil
+}
+
+// RemoveTimer disarms and releases a timer from AddTimer. Absence is ignored
+// silently.
+func (w *Watch) RemoveTimer(id int) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	if w.isDeadlineTimer(id) {
+		return nil // internal to SetFDDeadline
+	}
+
+	var change syscall.Kevent_t
+	syscall.SetKevent(&change, id, syscall.EVFILT_TIMER, syscall.EV_DELETE)
+	errno, err := w.apply(&change)
+	if err != nil {
+		if err == syscall.EBADF {
+			return ErrClosed
+		}
+		return fmt.Errorf("Watch RemoveTimer lost on kevent(2) error %w", err)
+	}
+	// one-shot timers are gone after expiry
+	if errno != 0 && errno != syscall.ENOENT {
+		return fmt.Errorf("Watch RemoveTimer denied by kevent(2) with error %w", errno)
+	}
+	delete(w.timers, id)
+	w.unstash(id, false)
+	return nil
+}
+
+// IsTimer returns whether the identifier is one from AddTimer.
+func (w *Watch) isTimer(id int) bool {
+	w.mutex.Lock()
+	_, ok := w.timers[id]
+	w.mutex.Unlock()
+	return ok
+}
+
+// Apply submits a single change to the kernel queue, after any pending. The
+// error return is for kevent(2) itself, and the errno return is for the change
+// in particular. The mutex must be held.
+func (w *Watch) apply(change *syscall.Kevent_t) (errno syscall.Errno, err error) {
+	err = w.flushChanges()
+	if err != nil {
+		return 0, err
+	}
+
+	events := [2]syscall.Kevent_t{*change, {}}
+	withReceipt(events[:1])
 
 	// zero value indicates an immediate timeout
 	var noBlock syscall.Timespec
@@ -124,17 +1766,88 @@ func (w *Watch) ExcludeFD(fd int) error {
 	// changelist have been applied.”
 	// ―the System Calls Manual from FreeBSD
 	if err != nil && err != syscall.EINTR {
-		if err == syscall.EBADF {
-			return ErrClosed
+		return 0, err
+	}
+
+	if n != 0 && events[1].Ident == change.Ident &&
+		events[1].Filter == change.Filter &&
+		events[1].Flags&syscall.EV_ERROR != 0 {
+		return syscall.Errno(events[1].Data), nil
+	}
+	w.stash(events[1:1+n], nil)
+	return 0, nil
+}
+
+// FlushChanges submits the changes pending, if any. Failures are for the next
+// Await to report. Events ready are read as a side effect, which means that
+// they are held back until the next kevent(2). The mutex must be held.
+func (w *Watch) flushChanges() error {
+	if len(w.changes) == 0 {
+		return nil
+	}
+	changes := w.changes
+	w.changes = nil
+
+	// room for an error on each change
+	events := make([]syscall.Kevent_t, len(changes))
+
+	// zero value indicates an immediate timeout
+	var noBlock syscall.Timespec
+
+	withReceipt(changes)
+	n, err := syscall.Kevent(w.queueFD, changes, events, &noBlock)
+	switch err {
+	case nil:
+		break
+	case syscall.EINTR:
+		return nil // all changes applied
+	default:
+		return fmt.Errorf("Watch lost pending changes on kevent(2) error %w", err)
+	}
+	w.recordChangeErrs(events[:n])
+	w.stash(events[:n], nil)
+	return nil
+}
+
+// ChangesApplied moves change failures from the events into changeErrs, and it
+// returns the number of events left, which are ready events only.
+func (w *Watch) changesApplied(events []syscall.Kevent_t) (n int) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.recordChangeErrs(events)
+	for i := range events {
+		if events[i].Flags&syscall.EV_ERROR == 0 {
+			events[n] = events[i]
+			n++
 		}
-		return fmt.Errorf("Watch ExcludeFD lost on kevent(2) error %w", err)
 	}
+	return n
+}
 
-	if n != 0 && events[1].Ident == fd64 && events[1].Flags&syscall.EV_ERROR != 0 {
-		err := syscall.Errno(events[1].Data)
-		if err != syscall.ENOENT {
-			return fmt.Errorf("Watch ExcludeFD denied by kevent(2) with error %w", err)
+// RecordChangeErrs appends each failure from the events to changeErrs. The
+// mutex must be held.
+func (w *Watch) recordChangeErrs(events []syscall.Kevent_t) {
+	for i := range events {
+		e := &events[i]
+		if e.Flags&syscall.EV_ERROR == 0 || e.Data == 0 {
+			continue // not an error
 		}
+		fd := int(e.Ident)
+		var err error
+		switch errno := syscall.Errno(e.Data); errno {
+		case syscall.ENOENT:
+			continue // exclude of absent file descriptor
+		case syscall.EBADF:
+			err = ErrBadFD
+		case syscall.ENOMEM:
+			err = ErrTooManyWatches
+		default:
+			err = fmt.Errorf("Watch change denied by kevent(2) with error %w", errno)
+		}
+		delete(w.fds, fd)
+		delete(w.tokens, fd)
+		delete(w.ranks, fd)
+		w.counters.include("include", fd, 0, err)
+		w.changeErrs = append(w.changeErrs, &FDError{FD: fd, Err: err})
 	}
-	return nil
 }
@@ -0,0 +1,16 @@
+//go:build netbsd || freebsd
+
+package fdmom
+
+import "syscall"
+
+// Out-of-band data is not reported. EvFiltExcept is never in use, as Priority
+// is part of readInterest.
+const (
+	evFiltExcept = syscall.EVFILT_READ
+	noteOOB      = 0
+)
+
+// ReadInterest are the conditions for EVFILT_READ, which includes Priority for
+// lack of EVFILT_EXCEPT.
+const readInterest = Read | Priority | Hangup
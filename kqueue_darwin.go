@@ -0,0 +1,19 @@
+package fdmom
+
+import "syscall"
+
+// EVFILT_EXCEPT with NOTE_OOB reports out-of-band data, which is missing in
+// package syscall.
+const (
+	evFiltExcept = -15
+	noteOOB      = 0x2
+)
+
+// ReadInterest are the conditions for EVFILT_READ. Priority has EVFILT_EXCEPT.
+const readInterest = Read | Hangup
+
+// NoteNSeconds has EVFILT_TIMER in nanoseconds.
+const noteNSeconds = syscall.NOTE_NSECONDS
+
+// EvReceipt has kevent(2) report each change, without events ready.
+const evReceipt = syscall.EV_RECEIPT
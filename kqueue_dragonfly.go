@@ -0,0 +1,19 @@
+package fdmom
+
+import "syscall"
+
+// EVFILT_EXCEPT with NOTE_OOB reports out-of-band data.
+const (
+	evFiltExcept = syscall.EVFILT_EXCEPT
+	noteOOB      = syscall.NOTE_OOB
+)
+
+// ReadInterest are the conditions for EVFILT_READ. Priority has EVFILT_EXCEPT.
+const readInterest = Read | Hangup
+
+// NoteNSeconds is not available, which leaves EVFILT_TIMER in milliseconds.
+const noteNSeconds = 0
+
+// EvReceipt is not available. The stash keeps any events ready from changes
+// instead.
+const evReceipt = 0
@@ -0,0 +1,8 @@
+package fdmom
+
+// NoteNSeconds has EVFILT_TIMER in nanoseconds since FreeBSD 11, which is
+// missing in package syscall.
+const noteNSeconds = 0x8
+
+// EvReceipt has kevent(2) report each change, without events ready.
+const evReceipt = 0x40 // EV_RECEIPT
@@ -0,0 +1,8 @@
+package fdmom
+
+// NoteNSeconds is not in use, which leaves EVFILT_TIMER in milliseconds.
+const noteNSeconds = 0
+
+// EvReceipt is not in use, as NetBSD has EV_RECEIPT since version 10 only. The
+// stash keeps any events ready from changes instead.
+const evReceipt = 0
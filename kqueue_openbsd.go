@@ -0,0 +1,18 @@
+package fdmom
+
+// EVFILT_EXCEPT with NOTE_OOB reports out-of-band data since OpenBSD 6.6, which
+// is missing in package syscall.
+const (
+	evFiltExcept = -9
+	noteOOB      = 0x4
+)
+
+// ReadInterest are the conditions for EVFILT_READ. Priority has EVFILT_EXCEPT.
+const readInterest = Read | Hangup
+
+// NoteNSeconds is not in use, which leaves EVFILT_TIMER in milliseconds.
+const noteNSeconds = 0
+
+// EvReceipt has kevent(2) report each change, without events ready, which is
+// missing in package syscall for most architectures.
+const evReceipt = 0x40 // EV_RECEIPT
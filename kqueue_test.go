@@ -0,0 +1,187 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+
+package fdmom
+
+import (
+	"errors"
+	"math"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// InterruptThreads sends SIGURG to the process, which is delivered to any one
+// thread.
+func interruptThreads(t *testing.T) {
+	err := syscall.Kill(os.Getpid(), syscall.SIGURG)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWatchCloseOnExec(t *testing.T) {
+	w, err := OpenWatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	for _, fd := range []int{w.queueFD, w.wakeFDs[0], w.wakeFDs[1]} {
+		flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_GETFD, 0)
+		if errno != 0 {
+			t.Fatal("fcntl(2) error:", errno)
+		}
+		if flags&syscall.FD_CLOEXEC == 0 {
+			t.Errorf("FD %#x has no FD_CLOEXEC", fd)
+		}
+	}
+}
+
+func TestWatchKeepOnExec(t *testing.T) {
+	w, err := OpenWatch(KeepOnExec())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(w.queueFD), syscall.F_GETFD, 0)
+	if errno != 0 {
+		t.Fatal("fcntl(2) error:", errno)
+	}
+	if flags&syscall.FD_CLOEXEC != 0 {
+		t.Error("queue FD has FD_CLOEXEC")
+	}
+	for _, fd := range w.wakeFDs[:] {
+		flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_GETFD, 0)
+		if errno != 0 {
+			t.Fatal("fcntl(2) error:", errno)
+		}
+		if flags&syscall.FD_CLOEXEC == 0 {
+			t.Errorf("wakeup FD %#x has no FD_CLOEXEC", fd)
+		}
+	}
+}
+
+func TestWatchCoalesceChanges(t *testing.T) {
+	p := newPipe(t, CoalesceChanges())
+
+	// not open for sure
+	const badFD = math.MaxInt32
+
+	err := p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Watch.IncludeFD(badFD)
+	if err != nil {
+		t.Fatal("include got error before Await:", err)
+	}
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+
+	// ready event first, and the failure next
+	got, err := p.Watch.AwaitFDWithRead(holdupMax)
+	if err != nil || got != p.rFD {
+		t.Errorf("got FD %#x with error %v, want FD %#x", got, err, p.rFD)
+	}
+	_, err = p.Watch.AwaitFDWithRead(0)
+	var fdErr *FDError
+	if !errors.As(err, &fdErr) || fdErr.FD != badFD || !errors.Is(err, ErrBadFD) {
+		t.Errorf("got error %v, want an FDError with ErrBadFD for FD %#x", err, badFD)
+	}
+	if p.Watch.IsWatched(badFD) {
+		t.Error("bad FD on watch list after failure")
+	}
+
+	err = p.Watch.ExcludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.Watch.AwaitFDWithRead(0)
+	if err != ErrTimeout {
+		t.Errorf("got error %v after exclude, want ErrTimeout", err)
+	}
+}
+
+func TestWatchIncludeSignals(t *testing.T) {
+	p := newPipe(t)
+	id, err := p.Watch.IncludeSignals(syscall.SIGWINCH)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// EVFILT_SIGNAL records despite the handler of the Go runtime
+	err = syscall.Kill(os.Getpid(), syscall.SIGWINCH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.Watch.AwaitFDWithRead(holdupMax)
+	if err != nil || got != id {
+		t.Fatalf("got FD %#x with error %v, want identifier %#x", got, err, id)
+	}
+	signals, err := p.Watch.ReadSignals(id)
+	if err != nil || len(signals) != 1 || signals[0] != syscall.SIGWINCH {
+		t.Errorf("got signals %v with error %v, want [%s]", signals, err, syscall.SIGWINCH)
+	}
+
+	err = p.Watch.ExcludeSignals(id)
+	if err != nil {
+		t.Fatal("exclude error:", err)
+	}
+	_, err = p.Watch.ReadSignals(id)
+	var fdErr *FDError
+	if !errors.As(err, &fdErr) {
+		t.Errorf("read after exclude got error %v, want an *FDError", err)
+	}
+}
+
+// Timer events come once, also when kevent(2) for changes reads them.
+func TestWatchTimerWithReadyFDs(t *testing.T) {
+	p := newPipe(t)
+	err := p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	id, err := p.Watch.AddTimer(10*time.Millisecond, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10*time.Millisecond + holdupMax/2)
+
+	// changes with the timer expired
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	err = p.Watch.IncludeFD(int(r.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Watch.ExcludeFDs(int(r.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 8; i++ {
+		fd, err := p.Watch.AwaitFDWithRead(holdupMax)
+		if err != nil {
+			t.Fatal("await error:", err)
+		}
+		if fd == id {
+			return // fired
+		}
+		if fd != p.rFD {
+			t.Fatalf("got FD %#x, want timer %d or FD %#x", fd, id, p.rFD)
+		}
+	}
+	t.Error("timer lost while the pipe stays ready")
+}
@@ -0,0 +1,128 @@
+//go:build linux || darwin || netbsd || freebsd || openbsd || dragonfly
+
+package fdmom
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Listener is a net.Listener with an accept timeout per call, from a Watch of
+// its own rather than SetDeadline, which not all listeners provide, and which
+// would apply to each routine in Accept. Servers with many listeners can Park
+// the idle ones on a shared Watch, with an accept routine only for those which
+// are busy:
+//
+//	for {
+//		conn, err := l.AcceptTimeout(idleTimeout)
+//		if err == fdmom.ErrTimeout {
+//			return l.Park(shared) // AwaitAccept on shared returns l
+//		}
+//		…
+//	}
+type Listener struct {
+	net.Listener
+	conn syscall.Conn
+	w    *Watch // the listener only
+
+	mutex  sync.Mutex
+	parked *Watch // from Park
+}
+
+// NewListener wraps l, which must provide its file descriptor with syscall.Conn.
+// The Listener takes ownership of l, i.e., Close applies to both.
+func NewListener(l net.Listener) (*Listener, error) {
+	c, ok := l.(syscall.Conn)
+	if !ok {
+		return nil, fmt.Errorf("listener %T does not provide its file descriptor", l)
+	}
+	w, err := OpenWatch()
+	if err != nil {
+		return nil, err
+	}
+	_, err = controlFD(c, w.IncludeFD)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	return &Listener{Listener: l, conn: c, w: w}, nil
+}
+
+// Accept implements the net.Listener interface. It blocks until a connection
+// comes in, or until Close.
+func (l *Listener) Accept() (net.Conn, error) {
+	return l.AcceptTimeout(-1)
+}
+
+// AcceptTimeout is like Accept, yet with a timeout as with AwaitFDWithRead.
+// Expiry gets ErrTimeout. Connections come from the file descriptor directly,
+// i.e., any Accept logic of the net.Listener does not apply.
+func (l *Listener) AcceptTimeout(timeout time.Duration) (net.Conn, error) {
+	// restarts continue with the remainder
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	for {
+		var conn net.Conn
+		var acceptErr error
+		_, err := controlFD(l.conn, func(fd int) error {
+			conn, acceptErr = acceptOne(fd, "Listener")
+			return nil
+		})
+		if err != nil {
+			if l.w.isClosed() {
+				return nil, ErrClosed
+			}
+			return nil, err
+		}
+		if conn != nil || acceptErr != nil {
+			return conn, acceptErr
+		}
+
+		_, err = l.w.AwaitFDWithRead(timeout)
+		if err != nil {
+			return nil, err
+		}
+		// connection may be taken by another routine
+		timeout = remaining(timeout, deadline)
+	}
+}
+
+// Park puts the listener on the watch list of w, like IncludeListener does.
+// AwaitAccept on w returns the Listener once a connection is pending, and it
+// removes the Listener from w again.
+func (l *Listener) Park(w *Watch) error {
+	_, err := w.IncludeListener(l)
+	if err != nil {
+		return err
+	}
+	l.mutex.Lock()
+	l.parked = w
+	l.mutex.Unlock()
+	return nil
+}
+
+// SyscallConn implements the syscall.Conn interface with the net.Listener.
+func (l *Listener) SyscallConn() (syscall.RawConn, error) {
+	return l.conn.SyscallConn()
+}
+
+// Close implements the net.Listener interface. Any AcceptTimeouts in progress
+// return with ErrClosed, and the listener leaves the Watch from Park, if any.
+func (l *Listener) Close() error {
+	l.mutex.Lock()
+	parked := l.parked
+	l.parked = nil
+	l.mutex.Unlock()
+	if parked != nil {
+		// absence is ignored silently
+		parked.ExcludeListener(l)
+	}
+
+	l.w.Close()
+	return l.Listener.Close()
+}
@@ -0,0 +1,423 @@
+//go:build linux || darwin || netbsd || freebsd || openbsd || dragonfly
+
+package fdmom
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Run invokes handler with each file descriptor from AwaitFDWithRead until ctx
+// is done, until the Watch is closed, or until handler returns an error. The
+// return is either the error from handler, or ctx.Err, or ErrClosed, or an
+// error from AwaitFDWithRead. Expiries from SetFDDeadline invoke handler with
+// the file descriptor too, as they are per file descriptor rather than for the
+// loop. Concurrent Awaits on the same Watch may delay the return of Run on ctx
+// expiry.
+func (w *Watch) Run(ctx context.Context, handler func(fd int) error) error {
+	if done := ctx.Done(); done != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-done:
+				w.wakeOpen()
+			case <-stop:
+			}
+		}()
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		fd, err := w.AwaitFDWithRead(-1)
+		switch err {
+		case nil:
+			err = handler(fd)
+			if err != nil {
+				return err
+			}
+		case ErrWoken:
+			continue // checks ctx
+		default:
+			var fdErr *FDError
+			if errors.As(err, &fdErr) && fdErr.Err == ErrTimeout {
+				// deadline expiry
+				err = handler(fdErr.FD)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			return err
+		}
+	}
+}
+
+// AwaitFDWithReadContext is like AwaitFDWithRead, yet it blocks until ctx is
+// done instead of a timeout. Cancellation interrupts the wait with an internal
+// wakeup, in which case the return is ctx.Err. The deadline of ctx, if any,
+// applies as a timeout, in which case the return is context.DeadlineExceeded.
+// Concurrent Awaits on the same Watch may delay the return on cancellation.
+func (w *Watch) AwaitFDWithReadContext(ctx context.Context) (fd int, err error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if done := ctx.Done(); done != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-done:
+				w.wakeOpen()
+			case <-stop:
+			}
+		}()
+	}
+
+	timeout := time.Duration(-1)
+	deadline, hasDeadline := ctx.Deadline()
+	for {
+		if hasDeadline {
+			timeout = time.Until(deadline)
+			if timeout < 0 {
+				timeout = 0 // last non-blocking attempt
+			}
+		}
+
+		fd, err := w.AwaitFDWithRead(timeout)
+		switch err {
+		case nil:
+			return fd, nil
+		case ErrWoken:
+			if err := ctx.Err(); err != nil {
+				return 0, err
+			}
+			continue // wakeup for another purpose
+		case ErrTimeout:
+			if hasDeadline {
+				// ctx.Err may lag behind the clock
+				return 0, context.DeadlineExceeded
+			}
+		}
+		return 0, err
+	}
+}
+
+// Events returns a channel which receives each file descriptor from
+// AwaitFDWithRead. Expiries from SetFDDeadline send the file descriptor too,
+// like Run does. The channel closes when the Watch is closed, or on any error
+// other than an *FDError.
+//
+// A routine feeds the channel, with one Await at a time. The routine blocks
+// until the file descriptor is received, which means that slow consumers do
+// not cause any spinning, nor do they lose events. Note that the routine does
+// not wait for the consumer to act on the file descriptor after reception. Any
+// file descriptor may be received again before the consumer had a chance to
+// read, as readiness is level-triggered.
+func (w *Watch) Events() <-chan int {
+	w.eventsOnce.Do(func() {
+		w.events = make(chan int)
+		go w.feed(w.events)
+	})
+	return w.events
+}
+
+// Feed sends each file descriptor from AwaitFDWithRead until error.
+func (w *Watch) feed(c chan<- int) {
+	defer close(c)
+	for {
+		fd, err := w.AwaitFDWithRead(-1)
+		if err != nil {
+			var fdErr *FDError
+			switch {
+			case errors.As(err, &fdErr) && fdErr.Err == ErrTimeout:
+				fd = fdErr.FD // deadline expiry
+			case err == ErrWoken || fdErr != nil:
+				continue
+			default:
+				return
+			}
+		}
+
+		select {
+		case c <- fd:
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// ExpiryEvent returns the Event for a deadline expiry from SetFDDeadline. The
+// return is false when err is not an expiry.
+func (w *Watch) expiryEvent(err error) (Event, bool) {
+	var fdErr *FDError
+	if !errors.As(err, &fdErr) || fdErr.Err != ErrTimeout {
+		return Event{}, false
+	}
+	w.mutex.Lock()
+	token := w.tokens[fdErr.FD]
+	w.mutex.Unlock()
+	return Event{FD: fdErr.FD, Token: token, Expired: true, Available: -1}, true
+}
+
+// Server is an event loop from Serve.
+type Server struct {
+	w        *Watch
+	stopOnce sync.Once
+	stop     chan struct{} // closed on Stop
+	done     chan struct{} // closed on return of the loop
+	err      error         // cause of return, if any
+}
+
+// Serve invokes handler with each Event from AwaitEvent on a routine of its own,
+// one at a time, until Stop, until the Watch is closed, or until an error other
+// than an *FDError. The watch list may change at any time, including from within
+// handler, as changes apply to the Await in progress. Tokens from Post reach
+// handler as an Event with Posted set, and expiries from SetFDDeadline reach
+// handler as an Event with Expired set.
+func (w *Watch) Serve(handler func(Event)) *Server {
+	s := &Server{
+		w:    w,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go s.loop(handler)
+	return s
+}
+
+// Loop runs until stop or error.
+func (s *Server) loop(handler func(Event)) {
+	defer close(s.done)
+	for {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		event, err := s.w.AwaitEvent(-1)
+		if err != nil {
+			var ok bool
+			event, ok = s.w.expiryEvent(err)
+			if !ok {
+				var fdErr *FDError
+				if err == ErrWoken || errors.As(err, &fdErr) {
+					continue // checks stop
+				}
+				s.err = err
+				return
+			}
+		}
+		handler(event)
+	}
+}
+
+// Stop ends the event loop without waiting. Any handler in progress completes
+// first. Stop is safe for use from within the handler.
+func (s *Server) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		s.w.wakeOpen()
+	})
+}
+
+// Wait blocks until the event loop ends. The return is nil on Stop, ErrClosed
+// on Close of the Watch, or any other error which ended the loop.
+func (s *Server) Wait() error {
+	<-s.done
+	return s.err
+}
+
+// Done returns a channel which closes when the event loop ends.
+func (s *Server) Done() <-chan struct{} {
+	return s.done
+}
+
+// Dispatcher is a worker pool from Dispatch.
+type Dispatcher struct {
+	w        *Watch
+	handler  func(Event)
+	jobs     chan Event
+	stopOnce sync.Once
+	stop     chan struct{} // closed on Stop
+	done     chan struct{} // closed on return of the loop and the workers
+	err      error         // cause of return, if any
+
+	mutex   sync.Mutex
+	pending map[int]*Event // in progress per file descriptor, with any follow-up
+}
+
+// Dispatch invokes handler with each Event from AwaitEvent on a number of worker
+// routines, until Stop, until the Watch is closed, or until an error other than
+// an *FDError. No two workers handle the same file descriptor at the same time.
+// Events which arrive during a handler merge into one follow-up invocation on the
+// same worker, after the handler returns. File descriptors pause with PauseFD for
+// the duration, such that level-triggered readiness does not spin the loop, and
+// they resume with ResumeFD once done, which means that handler can not keep its
+// own file descriptor paused. Tokens from Post reach any worker as an Event with
+// Posted set, and expiries from SetFDDeadline reach a worker as an Event with
+// Expired set. Workers less than one count as one.
+func (w *Watch) Dispatch(workers int, handler func(Event)) *Dispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+	d := &Dispatcher{
+		w:       w,
+		handler: handler,
+		jobs:    make(chan Event),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		pending: make(map[int]*Event),
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			d.work()
+		}()
+	}
+	go func() {
+		d.loop()
+		close(d.jobs)
+		wg.Wait()
+		close(d.done)
+	}()
+	return d
+}
+
+// Loop hands out events until stop or error.
+func (d *Dispatcher) loop() {
+	for {
+		select {
+		case <-d.stop:
+			return
+		default:
+		}
+
+		event, err := d.w.AwaitEvent(-1)
+		if err != nil {
+			var ok bool
+			event, ok = d.w.expiryEvent(err)
+			if !ok {
+				var fdErr *FDError
+				if err == ErrWoken || errors.As(err, &fdErr) {
+					continue // checks stop
+				}
+				d.err = err
+				return
+			}
+		}
+		if !event.Posted && !d.claim(event) {
+			continue // merged into a follow-up
+		}
+
+		select {
+		case d.jobs <- event:
+		case <-d.stop:
+			if !event.Posted {
+				d.release(event.FD)
+			}
+			return
+		}
+	}
+}
+
+// Work runs handler with each job until the loop ends.
+func (d *Dispatcher) work() {
+	for event := range d.jobs {
+		for {
+			d.handler(event)
+			if event.Posted {
+				break
+			}
+			next, ok := d.next(event.FD)
+			if !ok {
+				break
+			}
+			event = next
+		}
+	}
+}
+
+// Claim marks the file descriptor of event as in progress. The return is false
+// when a worker has the file descriptor already, in which case event merges into
+// the follow-up.
+func (d *Dispatcher) claim(event Event) bool {
+	d.mutex.Lock()
+	p, busy := d.pending[event.FD]
+	switch {
+	case !busy:
+		d.pending[event.FD] = nil
+	case p == nil:
+		d.pending[event.FD] = &event
+	default:
+		p.Ready |= event.Ready
+		p.Token = event.Token
+		p.Available = event.Available
+		p.Expired = p.Expired || event.Expired
+	}
+	d.mutex.Unlock()
+	if busy {
+		return false
+	}
+
+	// Identifiers other than the watch list, such as timers, can not pause.
+	// The pending map serializes them regardless.
+	d.w.PauseFD(event.FD)
+	return true
+}
+
+// Next returns the follow-up for the file descriptor, if any. The file
+// descriptor is released otherwise.
+func (d *Dispatcher) next(fd int) (Event, bool) {
+	d.mutex.Lock()
+	if p := d.pending[fd]; p != nil {
+		d.pending[fd] = nil
+		d.mutex.Unlock()
+		return *p, true
+	}
+	d.mutex.Unlock()
+
+	d.release(fd)
+	return Event{}, false
+}
+
+// Release ends the claim on the file descriptor.
+func (d *Dispatcher) release(fd int) {
+	d.mutex.Lock()
+	delete(d.pending, fd)
+	d.mutex.Unlock()
+	// errors include an ExcludeFD or a Close from the handler
+	d.w.ResumeFD(fd)
+}
+
+// Stop ends the event loop without waiting. Any handler in progress completes
+// first, including its follow-up, if any. Stop is safe for use from within the
+// handler.
+func (d *Dispatcher) Stop() {
+	d.stopOnce.Do(func() {
+		close(d.stop)
+		d.w.wakeOpen()
+	})
+}
+
+// Wait blocks until the event loop and each of the workers end. The return is
+// nil on Stop, ErrClosed on Close of the Watch, or any other error which ended
+// the loop.
+func (d *Dispatcher) Wait() error {
+	<-d.done
+	return d.err
+}
+
+// Done returns a channel which closes when the event loop and each of the
+// workers end.
+func (d *Dispatcher) Done() <-chan struct{} {
+	return d.done
+}
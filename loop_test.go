@@ -0,0 +1,382 @@
+//go:build linux || darwin || netbsd || freebsd || openbsd || dragonfly
+
+package fdmom
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunHandlerError(t *testing.T) {
+	p := newPipe(t)
+	err := p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+
+	handlerErr := errors.New("test handler error")
+	var calls int
+	err = p.Watch.Run(context.Background(), func(fd int) error {
+		calls++
+		if fd != p.rFD {
+			t.Errorf("handler got FD %#x, want FD %#x", fd, p.rFD)
+		}
+		if calls < 3 {
+			return nil // data remains
+		}
+		return handlerErr
+	})
+	if err != handlerErr {
+		t.Errorf("got error %v, want the handler error", err)
+	}
+	if calls != 3 {
+		t.Errorf("got %d handler calls, want 3", calls)
+	}
+}
+
+func TestRunDeadline(t *testing.T) {
+	p := newPipe(t)
+	err := p.Watch.IncludeFDWithDeadline(p.rFD, time.Now().Add(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handlerErr := errors.New("test handler error")
+	err = p.Watch.Run(context.Background(), func(fd int) error {
+		if fd != p.rFD {
+			t.Errorf("handler got FD %#x, want FD %#x", fd, p.rFD)
+		}
+		return handlerErr
+	})
+	if err != handlerErr {
+		t.Errorf("got error %v, want the handler error on deadline expiry", err)
+	}
+}
+
+func TestRunCancel(t *testing.T) {
+	p := newPipe(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := p.Watch.Run(ctx, func(fd int) error {
+		t.Errorf("handler got FD %#x on empty watch", fd)
+		return nil
+	})
+	if err != context.Canceled {
+		t.Errorf("got error %v, want context.Canceled", err)
+	} else if age := time.Since(start); age > 10*time.Millisecond+holdupMax {
+		t.Errorf("cancel took %s", age)
+	}
+}
+
+func TestRunClose(t *testing.T) {
+	p := newPipe(t)
+
+	time.AfterFunc(10*time.Millisecond, func() { p.Watch.Close() })
+	err := p.Watch.Run(context.Background(), func(fd int) error {
+		t.Errorf("handler got FD %#x on empty watch", fd)
+		return nil
+	})
+	if err != ErrClosed {
+		t.Errorf("got error %v, want ErrClosed", err)
+	}
+}
+
+func TestAwaitFDWithReadContext(t *testing.T) {
+	p := newPipe(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	fd, err := p.Watch.AwaitFDWithReadContext(ctx)
+	if err != context.Canceled {
+		t.Errorf("got FD %#x with error %v, want context.Canceled", fd, err)
+	} else if age := time.Since(start); age > 10*time.Millisecond+holdupMax {
+		t.Errorf("cancel took %s", age)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	fd, err = p.Watch.AwaitFDWithReadContext(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("got FD %#x with error %v, want context.DeadlineExceeded", fd, err)
+	}
+
+	err = p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	fd, err = p.Watch.AwaitFDWithReadContext(context.Background())
+	if err != nil || fd != p.rFD {
+		t.Errorf("got FD %#x with error %v, want FD %#x", fd, err, p.rFD)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	p := newPipe(t)
+	err := p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+
+	events := p.Watch.Events()
+	if p.Watch.Events() != events {
+		t.Error("repeated Events got another channel")
+	}
+	select {
+	case got := <-events:
+		if got != p.rFD {
+			t.Errorf("got FD %#x, want FD %#x", got, p.rFD)
+		}
+	case <-time.After(holdupMax):
+		t.Fatal("no event received")
+	}
+
+	err = p.Watch.Close()
+	if err != nil {
+		t.Fatal("close error:", err)
+	}
+	timeout := time.After(holdupMax)
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return // closed
+			}
+		case <-timeout:
+			t.Fatal("event channel not closed after Watch close")
+		}
+	}
+}
+
+func TestEventsDeadline(t *testing.T) {
+	p := newPipe(t)
+	err := p.Watch.IncludeFDWithDeadline(p.rFD, time.Now().Add(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-p.Watch.Events():
+		if got != p.rFD {
+			t.Errorf("got FD %#x, want FD %#x", got, p.rFD)
+		}
+	case <-time.After(holdupMax):
+		t.Fatal("no event received on deadline expiry")
+	}
+}
+
+func TestServe(t *testing.T) {
+	p := newPipe(t)
+	err := p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan Event)
+	s := p.Watch.Serve(func(e Event) {
+		// consume the data for level-triggered
+		var buf [8]byte
+		p.r.Read(buf[:])
+		events <- e
+	})
+
+	for i := 0; i < 2; i++ {
+		_, err = p.w.WriteString("Hello")
+		if err != nil {
+			t.Fatal("test data lost:", err)
+		}
+		select {
+		case e := <-events:
+			if e.FD != p.rFD || e.Ready&Read == 0 {
+				t.Errorf("handler got %+v, want FD %#x with Read", e, p.rFD)
+			}
+		case <-time.After(holdupMax):
+			t.Fatalf("no handler invocation for write %d", i+1)
+		}
+	}
+
+	s.Stop()
+	s.Stop() // no-op
+	select {
+	case <-s.Done():
+		break
+	case <-time.After(holdupMax):
+		t.Fatal("event loop still running after Stop")
+	}
+	if err := s.Wait(); err != nil {
+		t.Errorf("got error %v after Stop, want nil", err)
+	}
+}
+
+func TestServeDeadline(t *testing.T) {
+	p := newPipe(t)
+	err := p.Watch.IncludeFDToken(p.rFD, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Watch.SetFDDeadline(p.rFD, time.Now().Add(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan Event, 1)
+	s := p.Watch.Serve(func(e Event) { events <- e })
+	defer s.Stop()
+	select {
+	case e := <-events:
+		if e.FD != p.rFD || !e.Expired || e.Ready != 0 || e.Token != 42 {
+			t.Errorf("handler got %+v, want FD %#x Expired with token 42", e, p.rFD)
+		}
+	case <-time.After(holdupMax):
+		t.Fatal("no handler invocation on deadline expiry")
+	}
+}
+
+func TestServeClose(t *testing.T) {
+	p := newPipe(t)
+	s := p.Watch.Serve(func(Event) { t.Error("handler invoked") })
+	err := p.Watch.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Wait(); err != ErrClosed {
+		t.Errorf("got error %v after Close, want ErrClosed", err)
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	p := newPipe(t)
+
+	const pipeN = 4
+	files := []*os.File{p.r}
+	writers := []*os.File{p.w}
+	for i := 1; i < pipeN; i++ {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		defer w.Close()
+		files = append(files, r)
+		writers = append(writers, w)
+	}
+	for _, f := range files {
+		fd := int(f.Fd())
+		// follow-ups may find the data taken already
+		err := syscall.SetNonblock(fd, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = p.Watch.IncludeFD(fd)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var mutex sync.Mutex
+	active := make(map[int]int) // handlers in progress per file descriptor
+	received := make(chan int, 64)
+	d := p.Watch.Dispatch(pipeN, func(e Event) {
+		mutex.Lock()
+		active[e.FD]++
+		if n := active[e.FD]; n > 1 {
+			t.Errorf("file descriptor %d in %d handlers at once", e.FD, n)
+		}
+		mutex.Unlock()
+
+		time.Sleep(time.Millisecond) // provoke overlap
+		var buf [64]byte
+		n, err := syscall.Read(e.FD, buf[:])
+		if err != nil {
+			n = 0
+		}
+
+		mutex.Lock()
+		active[e.FD]--
+		mutex.Unlock()
+		received <- n
+	})
+
+	const writeN = 3
+	for i := 0; i < writeN; i++ {
+		for _, w := range writers {
+			_, err := w.WriteString("Hi")
+			if err != nil {
+				t.Fatal("test data lost:", err)
+			}
+		}
+	}
+	var got int
+	for got < pipeN*writeN*len("Hi") {
+		select {
+		case n := <-received:
+			got += n
+		case <-time.After(holdupMax):
+			t.Fatalf("got %d bytes, want %d", got, pipeN*writeN*len("Hi"))
+		}
+	}
+
+	d.Stop()
+	d.Stop() // no-op
+	select {
+	case <-d.Done():
+		break
+	case <-time.After(holdupMax):
+		t.Fatal("dispatcher still running after Stop")
+	}
+	if err := d.Wait(); err != nil {
+		t.Errorf("got error %v after Stop, want nil", err)
+	}
+}
+
+func TestDispatchDeadline(t *testing.T) {
+	p := newPipe(t)
+	err := p.Watch.IncludeFDWithDeadline(p.rFD, time.Now().Add(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan Event, 1)
+	d := p.Watch.Dispatch(2, func(e Event) { events <- e })
+	defer d.Stop()
+	select {
+	case e := <-events:
+		if e.FD != p.rFD || !e.Expired || e.Ready != 0 {
+			t.Errorf("handler got %+v, want FD %#x Expired", e, p.rFD)
+		}
+	case <-time.After(holdupMax):
+		t.Fatal("no handler invocation on deadline expiry")
+	}
+}
+
+func TestDispatchClose(t *testing.T) {
+	p := newPipe(t)
+	d := p.Watch.Dispatch(2, func(Event) { t.Error("handler invoked") })
+	err := p.Watch.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Wait(); err != ErrClosed {
+		t.Errorf("got error %v after Close, want ErrClosed", err)
+	}
+}
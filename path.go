@@ -0,0 +1,57 @@
+//go:build linux || darwin || netbsd || freebsd || openbsd || dragonfly
+
+package fdmom
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// PathOp is a set of file system changes for WatchPath.
+type PathOp uint32
+
+// File system changes are bit flags.
+const (
+	PathCreate PathOp = 1 << iota // directory entry added
+	PathWrite                     // content written
+	PathDelete                    // file unlinked
+	PathRename                    // file renamed
+)
+
+// String returns the changes separated by a pipe character.
+func (op PathOp) String() string {
+	if op == 0 {
+		return "0"
+	}
+	var buf []byte
+	for bit, name := range [...]string{"Create", "Write", "Delete", "Rename"} {
+		if op&(1<<bit) == 0 {
+			continue
+		}
+		if len(buf) != 0 {
+			buf = append(buf, '|')
+		}
+		buf = append(buf, name...)
+		op &^= 1 << bit
+	}
+	if op != 0 {
+		if len(buf) != 0 {
+			buf = append(buf, '|')
+		}
+		buf = fmt.Appendf(buf, "%#x", uint(op))
+	}
+	return string(buf)
+}
+
+// ErrNotPath denies file descriptors which are not from WatchPath.
+var errNotPath = errors.New("file descriptor not from WatchPath")
+
+// ClosePaths releases the descriptors of WatchPath. The mutex must be held.
+func (w *Watch) closePaths() {
+	for fd := range w.paths {
+		// close(2) removes the descriptor from the kernel queue too
+		syscall.Close(fd)
+		delete(w.paths, fd)
+	}
+}
@@ -0,0 +1,138 @@
+//go:build linux
+
+package fdmom
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// WatchPath adds a new descriptor for changes on the file system at path to the
+// watch list. Any of the changes in ops get the descriptor reported by the Await
+// methods, and PathOps tells which ones. Directories report changes on their
+// entries. The Watch owns the descriptor. Use UnwatchPath to release it.
+//
+// Linux uses an inotify(7) instance per path. The BSDs, including Darwin, open
+// the path for EVFILT_VNODE instead.
+func (w *Watch) WatchPath(path string, ops PathOp) (fd int, err error) {
+	fd, err = syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return -1, fmt.Errorf("Watch WatchPath lost on inotify_init1(2) error %w", err)
+	}
+	_, err = syscall.InotifyAddWatch(fd, path, inotifyMask(ops))
+	if err != nil {
+		syscall.Close(fd)
+		return -1, fmt.Errorf("Watch WatchPath denied by inotify_add_watch(2) with error %w", err)
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		syscall.Close(fd)
+		return -1, ErrClosed
+	}
+	err = w.add(fd, Read)
+	if err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	if w.paths == nil {
+		w.paths = make(map[int]PathOp)
+	}
+	w.paths[fd] = ops
+	return fd, nil
+}
+
+// UnwatchPath removes a descriptor of WatchPath from the watch list, and it
+// releases the descriptor. Absence is ignored silently.
+func (w *Watch) UnwatchPath(fd int) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	if _, ok := w.paths[fd]; !ok {
+		return nil
+	}
+	delete(w.paths, fd)
+	// close(2) removes the descriptor from epoll(7) too
+	delete(w.fds, fd)
+	delete(w.tokens, fd)
+	if w.ring != nil {
+		w.ring.pollRemove(fd)
+	}
+	return syscall.Close(fd)
+}
+
+// PathOps takes the changes on a descriptor of WatchPath since the previous
+// call. The return is zero when none happened.
+func (w *Watch) PathOps(fd int) (PathOp, error) {
+	w.mutex.Lock()
+	ops, ok := w.paths[fd]
+	w.mutex.Unlock()
+	if !ok {
+		return 0, &FDError{FD: fd, Err: errNotPath}
+	}
+
+	var got PathOp
+	var buf [4096]byte
+	for {
+		n, err := syscall.Read(fd, buf[:])
+		switch err {
+		case nil:
+			break
+		case syscall.EINTR:
+			continue
+		case syscall.EAGAIN:
+			return got, nil
+		default:
+			return got, fmt.Errorf("Watch PathOps lost on read(2) error %w", err)
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				got |= ops // lost track
+			}
+			got |= pathOpsOf(event.Mask) & ops
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+		}
+	}
+}
+
+// InotifyMask maps changes to their respective inotify(7) events.
+func inotifyMask(ops PathOp) uint32 {
+	var mask uint32
+	if ops&PathCreate != 0 {
+		mask |= syscall.IN_CREATE | syscall.IN_MOVED_TO
+	}
+	if ops&PathWrite != 0 {
+		mask |= syscall.IN_MODIFY
+	}
+	if ops&PathDelete != 0 {
+		mask |= syscall.IN_DELETE | syscall.IN_DELETE_SELF
+	}
+	if ops&PathRename != 0 {
+		mask |= syscall.IN_MOVED_FROM | syscall.IN_MOVE_SELF
+	}
+	return mask
+}
+
+// PathOpsOf maps inotify(7) events to their respective changes.
+func pathOpsOf(mask uint32) PathOp {
+	var ops PathOp
+	if mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+		ops |= PathCreate
+	}
+	if mask&syscall.IN_MODIFY != 0 {
+		ops |= PathWrite
+	}
+	if mask&(syscall.IN_DELETE|syscall.IN_DELETE_SELF) != 0 {
+		ops |= PathDelete
+	}
+	if mask&(syscall.IN_MOVED_FROM|syscall.IN_MOVE_SELF) != 0 {
+		ops |= PathRename
+	}
+	return ops
+}
@@ -0,0 +1,103 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+
+package fdmom
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// WatchPath adds a new descriptor for changes on the file system at path to the
+// watch list. Any of the changes in ops get the descriptor reported by the Await
+// methods, and PathOps tells which ones. Directories report changes on their
+// entries. The Watch owns the descriptor. Use UnwatchPath to release it.
+//
+// The BSDs, including Darwin, open the path for EVFILT_VNODE. Linux uses an
+// inotify(7) instance per path instead. EVFILT_VNODE can not distinguish new
+// entries in a directory from any other write, which means that PathCreate and
+// PathWrite come together on directories when both are in ops.
+func (w *Watch) WatchPath(path string, ops PathOp) (fd int, err error) {
+	fd, err = syscall.Open(path, syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, fmt.Errorf("Watch WatchPath lost on open(2) error %w", err)
+	}
+	err = w.WatchVnode(fd, vnodeNotesOf(ops))
+	if err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		syscall.Close(fd)
+		return -1, ErrClosed
+	}
+	if w.paths == nil {
+		w.paths = make(map[int]PathOp)
+	}
+	w.paths[fd] = ops
+	return fd, nil
+}
+
+// UnwatchPath removes a descriptor of WatchPath from the watch list, and it
+// releases the descriptor. Absence is ignored silently.
+func (w *Watch) UnwatchPath(fd int) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	if _, ok := w.paths[fd]; !ok {
+		return nil
+	}
+	delete(w.paths, fd)
+	// close(2) removes the descriptor from kqueue(2) too
+	delete(w.vnodes, fd)
+	delete(w.vnodesFired, fd)
+	w.unstash(fd, false)
+	return syscall.Close(fd)
+}
+
+// PathOps takes the changes on a descriptor of WatchPath since the previous
+// call. The return is zero when none happened.
+func (w *Watch) PathOps(fd int) (PathOp, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	ops, ok := w.paths[fd]
+	if !ok {
+		return 0, &FDError{FD: fd, Err: errNotPath}
+	}
+	notes := w.vnodesFired[fd]
+	delete(w.vnodesFired, fd)
+
+	var got PathOp
+	if notes&(NoteWrite|NoteExtend) != 0 {
+		got |= PathCreate | PathWrite
+	}
+	if notes&NoteDelete != 0 {
+		got |= PathDelete
+	}
+	if notes&NoteRename != 0 {
+		got |= PathRename
+	}
+	return got & ops, nil
+}
+
+// VnodeNotesOf maps changes to their respective EVFILT_VNODE notes.
+func vnodeNotesOf(ops PathOp) VnodeNote {
+	var notes VnodeNote
+	if ops&PathCreate != 0 {
+		notes |= NoteWrite // directory entries
+	}
+	if ops&PathWrite != 0 {
+		notes |= NoteWrite | NoteExtend
+	}
+	if ops&PathDelete != 0 {
+		notes |= NoteDelete
+	}
+	if ops&PathRename != 0 {
+		notes |= NoteRename
+	}
+	return notes
+}
@@ -0,0 +1,6 @@
+//go:build linux && !(mips || mipsle || mips64 || mips64le)
+
+package fdmom
+
+// System call number, which is missing in package syscall.
+const sysPidfdOpen = 434
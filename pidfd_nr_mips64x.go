@@ -0,0 +1,6 @@
+//go:build linux && (mips64 || mips64le)
+
+package fdmom
+
+// System call number for the n64 ABI, which is missing in package syscall.
+const sysPidfdOpen = 5434
@@ -0,0 +1,6 @@
+//go:build linux && (mips || mipsle)
+
+package fdmom
+
+// System call number for the o32 ABI, which is missing in package syscall.
+const sysPidfdOpen = 4434
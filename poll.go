@@ -0,0 +1,61 @@
+//go:build linux || darwin || netbsd || freebsd || openbsd || dragonfly
+
+package fdmom
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// PollFD is struct pollfd from <poll.h>.
+type pollFD struct {
+	fd      int32
+	events  int16
+	revents int16
+}
+
+// Poll(2) flags are the same on each platform supported.
+const (
+	pollIn   = 0x1
+	pollErr  = 0x8
+	pollHup  = 0x10
+	pollNVal = 0x20
+)
+
+// PollFD checks whether the file descriptor has read available, with poll(2),
+// independent of any Watch. Positive timeout values, including zero for non-
+// blocking, return false on expiry. Negative timeouts block indefinitely.
+// File descriptors which are not open get ErrBadFD.
+func PollFD(fd int, timeout time.Duration) (ready bool, err error) {
+	// restarts continue with the remainder
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	for {
+		p := pollFD{fd: int32(fd), events: pollIn}
+		n, err := poll(&p, timeout)
+		switch {
+		case err == syscall.EINTR:
+			timeout = remaining(timeout, deadline)
+			continue
+		case err != nil:
+			return false, fmt.Errorf("PollFD lost on poll(2) error %w", err)
+		case n == 0:
+			if timeout > 0 {
+				// wait may be capped; continue with any remainder
+				timeout = remaining(timeout, deadline)
+				if timeout > 0 {
+					continue
+				}
+			}
+			return false, nil
+		case p.revents&pollNVal != 0:
+			return false, ErrBadFD
+		}
+		// hang-ups and errors make read return without blocking
+		return p.revents&(pollIn|pollHup|pollErr) != 0, nil
+	}
+}
@@ -0,0 +1,33 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+
+package fdmom
+
+import (
+	"math"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Poll is poll(2) on a single file descriptor. Negative timeouts block
+// indefinitely. Timeouts beyond the millisecond range are capped, which makes
+// the wait return early.
+func poll(p *pollFD, timeout time.Duration) (n int, err error) {
+	msec := -1 // indefinite
+	if timeout >= 0 {
+		// timeout rounds up as they are a minimum guarantee
+		d := timeout / time.Millisecond
+		if timeout%time.Millisecond != 0 {
+			d++
+		}
+		if d > math.MaxInt32 {
+			d = math.MaxInt32
+		}
+		msec = int(d)
+	}
+	r1, _, errno := syscall.Syscall(syscall.SYS_POLL, uintptr(unsafe.Pointer(p)), 1, uintptr(msec))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(r1), nil
+}
@@ -0,0 +1,26 @@
+//go:build linux
+
+package fdmom
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Poll is ppoll(2) on a single file descriptor, with nanosecond precision on
+// the timeout. Negative timeouts block indefinitely.
+func poll(p *pollFD, timeout time.Duration) (n int, err error) {
+	var tsp *syscall.Timespec // indefinite
+	var ts syscall.Timespec
+	if timeout >= 0 {
+		ts = syscall.NsecToTimespec(int64(timeout))
+		tsp = &ts
+	}
+	r1, _, errno := syscall.Syscall6(syscall.SYS_PPOLL, uintptr(unsafe.Pointer(p)), 1,
+		uintptr(unsafe.Pointer(tsp)), 0 /* no sigmask */, 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(r1), nil
+}
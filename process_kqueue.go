@@ -0,0 +1,93 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+
+package fdmom
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// IncludeProcess adds the termination of process pid to the watch list. The
+// Await methods report the identifier returned once the process exited, in
+// place of a file descriptor. Use ExcludeProcess to release the identifier.
+// Process termination does not require the process to be a child.
+//
+// The BSDs, including Darwin, use EVFILT_PROC with NOTE_EXIT, which is reported
+// only once. The identifiers come from the same negative sequence as AddTimer.
+// Linux uses a pidfd_open(2) descriptor instead, which is reported for as long
+// as it remains on the watch list.
+func (w *Watch) IncludeProcess(pid int) (id int, err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return -1, ErrClosed
+	}
+	if id, ok := w.procs[pid]; ok {
+		return id, nil
+	}
+
+	var change syscall.Kevent_t
+	syscall.SetKevent(&change, pid, syscall.EVFILT_PROC, syscall.EV_ADD|syscall.EV_ONESHOT)
+	change.Fflags = syscall.NOTE_EXIT
+	errno, err := w.apply(&change)
+	if err != nil {
+		if err == syscall.EBADF {
+			return -1, ErrClosed
+		}
+		return -1, fmt.Errorf("Watch IncludeProcess lost on kevent(2) error %w", err)
+	}
+	if errno != 0 {
+		return -1, fmt.Errorf("Watch IncludeProcess denied by kevent(2) with error %w", errno)
+	}
+
+	// identifiers count down from -1 to keep clear of file descriptors
+	w.timerNext--
+	id = w.timerNext
+	if w.procs == nil {
+		w.procs = make(map[int]int)
+	}
+	w.procs[pid] = id
+	return id, nil
+}
+
+// ExcludeProcess removes an identifier of IncludeProcess from the watch list.
+// Absence is ignored silently.
+func (w *Watch) ExcludeProcess(id int) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	for pid, procID := range w.procs {
+		if procID != id {
+			continue
+		}
+
+		var change syscall.Kevent_t
+		syscall.SetKevent(&change, pid, syscall.EVFILT_PROC, syscall.EV_DELETE)
+		errno, err := w.apply(&change)
+		if err != nil {
+			if err == syscall.EBADF {
+				return ErrClosed
+			}
+			return fmt.Errorf("Watch ExcludeProcess lost on kevent(2) error %w", err)
+		}
+		// reported registrations are gone, as they are one-shot
+		if errno != 0 && errno != syscall.ENOENT && errno != syscall.ESRCH {
+			return fmt.Errorf("Watch ExcludeProcess denied by kevent(2) with error %w", errno)
+		}
+		delete(w.procs, pid)
+		w.unstashFilter(syscall.EVFILT_PROC, pid)
+		return nil
+	}
+	return nil
+}
+
+// ProcID returns the identifier of IncludeProcess for an EVFILT_PROC event.
+// The return is false when the process was excluded in the mean time.
+func (w *Watch) procID(event *syscall.Kevent_t) (id int, ok bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	id, ok = w.procs[int(event.Ident)]
+	return
+}
@@ -0,0 +1,72 @@
+//go:build linux
+
+package fdmom
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// IncludeProcess adds the termination of process pid to the watch list. The
+// Await methods report the identifier returned once the process exited, in
+// place of a file descriptor. Use ExcludeProcess to release the identifier.
+// Process termination does not require the process to be a child.
+//
+// Linux uses a pidfd_open(2) descriptor as the identifier, which is reported for
+// as long as it remains on the watch list. The BSDs, including Darwin, use
+// EVFILT_PROC with NOTE_EXIT instead, which is reported only once.
+func (w *Watch) IncludeProcess(pid int) (id int, err error) {
+	r1, _, errno := syscall.Syscall(sysPidfdOpen, uintptr(pid), 0, 0)
+	if errno != 0 {
+		return -1, fmt.Errorf("Watch IncludeProcess denied by pidfd_open(2) with error %w", errno)
+	}
+	fd := int(r1) // close-on-exec by default
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		syscall.Close(fd)
+		return -1, ErrClosed
+	}
+	err = w.add(fd, Read)
+	if err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	if w.procs == nil {
+		w.procs = make(map[int]int)
+	}
+	w.procs[fd] = pid
+	return fd, nil
+}
+
+// ExcludeProcess removes an identifier of IncludeProcess from the watch list.
+// Absence is ignored silently.
+func (w *Watch) ExcludeProcess(id int) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	if _, ok := w.procs[id]; !ok {
+		return nil
+	}
+	delete(w.procs, id)
+	// close(2) removes the descriptor from epoll(7) too
+	delete(w.fds, id)
+	delete(w.tokens, id)
+	if w.ring != nil {
+		w.ring.pollRemove(id)
+	}
+	return syscall.Close(id)
+}
+
+// CloseProcesses releases the descriptors of IncludeProcess. The mutex must be
+// held.
+func (w *Watch) closeProcesses() {
+	for fd := range w.procs {
+		// close(2) removes the descriptor from epoll(7) too
+		syscall.Close(fd)
+		delete(w.procs, fd)
+	}
+}
@@ -0,0 +1,100 @@
+//go:build linux || darwin || netbsd || freebsd || openbsd || dragonfly
+
+package fdmom
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// IdleReaper excludes file descriptors from a Watch once they have not been
+// ready for a fixed duration, such as dead keep-alive connections. Each file
+// descriptor has a deadline from SetFDDeadline, which readiness pushes back.
+type IdleReaper struct {
+	w    *Watch
+	idle time.Duration
+
+	mutex     sync.Mutex
+	lastReady map[int]time.Time // per file descriptor included
+}
+
+// NewIdleReaper returns a reaper for file descriptors idle longer than idle.
+// Use the Include and Await methods of the reaper instead of the ones on w.
+func NewIdleReaper(w *Watch, idle time.Duration) *IdleReaper {
+	return &IdleReaper{
+		w:         w,
+		idle:      idle,
+		lastReady: make(map[int]time.Time),
+	}
+}
+
+// IncludeFD adds the file descriptor to the watch list, like IncludeFD on the
+// Watch does. Time idle starts from now.
+func (r *IdleReaper) IncludeFD(fd int) error {
+	now := time.Now()
+	r.mutex.Lock()
+	r.lastReady[fd] = now
+	r.mutex.Unlock()
+
+	err := r.w.IncludeFDWithDeadline(fd, now.Add(r.idle))
+	if err != nil {
+		r.mutex.Lock()
+		delete(r.lastReady, fd)
+		r.mutex.Unlock()
+	}
+	return err
+}
+
+// ExcludeFD removes the file descriptor from the watch list, like ExcludeFD on
+// the Watch does.
+func (r *IdleReaper) ExcludeFD(fd int) error {
+	r.mutex.Lock()
+	delete(r.lastReady, fd)
+	r.mutex.Unlock()
+	return r.w.ExcludeFD(fd)
+}
+
+// LastReady returns the last time the file descriptor was reported by AwaitFD,
+// or the time of IncludeFD when none. The return is false for file descriptors
+// not included, including the ones reaped.
+func (r *IdleReaper) LastReady(fd int) (t time.Time, ok bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	t, ok = r.lastReady[fd]
+	return
+}
+
+// AwaitFD is like AwaitFD on the Watch, yet file descriptors idle for too long
+// are excluded from the watch list, and reported as an *FDError with
+// ErrTimeout.
+func (r *IdleReaper) AwaitFD(timeout time.Duration) (fd int, ready Interest, err error) {
+	fd, ready, err = r.w.AwaitFD(timeout)
+	var fdErr *FDError
+	switch {
+	case err == nil:
+		now := time.Now()
+		r.mutex.Lock()
+		if _, ok := r.lastReady[fd]; ok {
+			r.lastReady[fd] = now
+		}
+		r.mutex.Unlock()
+
+	case errors.As(err, &fdErr) && errors.Is(err, ErrTimeout):
+		r.mutex.Lock()
+		_, ok := r.lastReady[fdErr.FD]
+		delete(r.lastReady, fdErr.FD)
+		r.mutex.Unlock()
+		if ok {
+			// errors are for ExcludeFD to report
+			r.w.ExcludeFD(fdErr.FD)
+		}
+	}
+	return fd, ready, err
+}
+
+// AwaitFDWithRead is like AwaitFD, yet without the readiness.
+func (r *IdleReaper) AwaitFDWithRead(timeout time.Duration) (fd int, err error) {
+	fd, _, err = r.AwaitFD(timeout)
+	return
+}
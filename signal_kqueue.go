@@ -0,0 +1,140 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+
+package fdmom
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// IncludeSignals adds the signals to the watch list. The Await methods report
+// the identifier returned once any of the signals arrived, in place of a file
+// descriptor, and ReadSignals takes them off. Use ExcludeSignals to release the
+// identifier.
+//
+// The BSDs, including Darwin, use EVFILT_SIGNAL, which records delivery attempts
+// regardless of any signal handler. Signals without signal.Notify or
+// signal.Ignore still get their default action from the Go runtime, such as
+// termination. The identifiers come from the same negative sequence as AddTimer.
+// Linux uses a signalfd(2) from IncludeSignalFD instead, which requires the
+// signals to be blocked on all threads.
+func (w *Watch) IncludeSignals(sig ...os.Signal) (id int, err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return -1, ErrClosed
+	}
+
+	// identifiers count down from -1 to keep clear of file descriptors
+	id = w.timerNext - 1
+	for i := range sig {
+		s, ok := sig[i].(syscall.Signal)
+		if !ok {
+			return -1, fmt.Errorf("Watch IncludeSignals got signal %s of type %T, want a syscall.Signal", sig[i], sig[i])
+		}
+		if _, ok := w.signals[int(s)]; ok {
+			return -1, fmt.Errorf("Watch IncludeSignals got signal %s, which is included already", s)
+		}
+
+		var change syscall.Kevent_t
+		// edge-triggered as signals can not be read off
+		syscall.SetKevent(&change, int(s), syscall.EVFILT_SIGNAL, syscall.EV_ADD|syscall.EV_CLEAR)
+		errno, err := w.apply(&change)
+		if err != nil {
+			if err == syscall.EBADF {
+				return -1, ErrClosed
+			}
+			return -1, fmt.Errorf("Watch IncludeSignals lost on kevent(2) error %w", err)
+		}
+		if errno != 0 {
+			w.deleteSignals(id)
+			return -1, fmt.Errorf("Watch IncludeSignals denied by kevent(2) with error %w", errno)
+		}
+		if w.signals == nil {
+			w.signals = make(map[int]int)
+		}
+		w.signals[int(s)] = id
+	}
+	w.timerNext = id
+	return id, nil
+}
+
+// ExcludeSignals removes an identifier of IncludeSignals from the watch list.
+// Absence is ignored silently.
+func (w *Watch) ExcludeSignals(id int) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	return w.deleteSignals(id)
+}
+
+// DeleteSignals removes the registrations of id. The mutex must be held.
+func (w *Watch) deleteSignals(id int) error {
+	for signo, signalsID := range w.signals {
+		if signalsID != id {
+			continue
+		}
+
+		var change syscall.Kevent_t
+		syscall.SetKevent(&change, signo, syscall.EVFILT_SIGNAL, syscall.EV_DELETE)
+		errno, err := w.apply(&change)
+		if err != nil {
+			if err == syscall.EBADF {
+				return ErrClosed
+			}
+			return fmt.Errorf("Watch ExcludeSignals lost on kevent(2) error %w", err)
+		}
+		if errno != 0 && errno != syscall.ENOENT {
+			return fmt.Errorf("Watch ExcludeSignals denied by kevent(2) with error %w", errno)
+		}
+		delete(w.signals, signo)
+		w.unstashFilter(syscall.EVFILT_SIGNAL, signo)
+	}
+	delete(w.signalsFired, id)
+	return nil
+}
+
+// ReadSignals takes the signals arrived on an identifier of IncludeSignals. The
+// return is empty when none arrived. Linux reports each signal received in
+// order, while the BSDs coalesce repeats.
+func (w *Watch) ReadSignals(id int) ([]os.Signal, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for _, signalsID := range w.signals {
+		if signalsID == id {
+			signals := w.signalsFired[id]
+			delete(w.signalsFired, id)
+			return signals, nil
+		}
+	}
+	return nil, &FDError{FD: id, Err: errNotSignals}
+}
+
+// ErrNotSignals denies identifiers which are not from IncludeSignals.
+var errNotSignals = errors.New("identifier not from IncludeSignals")
+
+// SignalFired records an EVFILT_SIGNAL event. The return is the identifier of
+// IncludeSignals, or false when the signal was excluded in the mean time.
+func (w *Watch) signalFired(event *syscall.Kevent_t) (id int, ok bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	id, ok = w.signals[int(event.Ident)]
+	if !ok {
+		return 0, false
+	}
+	sig := syscall.Signal(event.Ident)
+	for _, fired := range w.signalsFired[id] {
+		if fired == sig {
+			return id, true // coalesced
+		}
+	}
+	if w.signalsFired == nil {
+		w.signalsFired = make(map[int][]os.Signal)
+	}
+	w.signalsFired[id] = append(w.signalsFired[id], sig)
+	return id, true
+}
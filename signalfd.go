@@ -0,0 +1,200 @@
+//go:build linux
+
+package fdmom
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// SignalInfo is a notification from signalfd(2).
+type SignalInfo struct {
+	Signal syscall.Signal
+	Code   int32  // origin, like SI_USER or SI_KERNEL
+	PID    uint32 // sender process
+	UID    uint32 // sender user
+	Status int32  // exit status or signal for SIGCHLD
+}
+
+// IncludeSignalFD adds a new signalfd(2) for the signals to the watch list.
+// AwaitFDWithRead reports the file descriptor for as long as any of the signals
+// are pending, and ReadSignalFD takes them off. The caller owns the descriptor
+// returned. ExcludeFD and syscall.Close it when done.
+//
+// A signalfd(2) only receives signals which are blocked on all threads. The Go
+// runtime retains the signal mask from program start on each of its threads,
+// which means that the signals must be blocked by the parent process already.
+// Signals which are not blocked go to the signal handler of the Go runtime, as
+// usual, which means that os/signal remains in charge.
+//
+// IncludeSignalFD is available on Linux only. IncludeSignals has a portable
+// equivalent, with EVFILT_SIGNAL on the BSDs.
+func (w *Watch) IncludeSignalFD(signals ...syscall.Signal) (fd int, err error) {
+	set, err := sigsetOf(signals)
+	if err != nil {
+		return -1, fmt.Errorf("Watch IncludeSignalFD got %w", err)
+	}
+
+	// sizemask must be _NSIG/8, or the kernel responds with EINVAL
+	const flags = syscall.O_NONBLOCK | syscall.O_CLOEXEC // SFD_NONBLOCK | SFD_CLOEXEC
+	r1, _, errno := syscall.RawSyscall6(syscall.SYS_SIGNALFD4, ^uintptr(0),
+		uintptr(unsafe.Pointer(&set)), unsafe.Sizeof(set), flags, 0, 0)
+	if errno != 0 {
+		return -1, fmt.Errorf("Watch IncludeSignalFD lost on signalfd(2) error %w", errno)
+	}
+	fd = int(r1)
+
+	err = w.IncludeFD(fd)
+	if err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
+
+// ReadSignalFD takes a pending signal from a descriptor of IncludeSignalFD. The
+// absence of pending signals gets syscall.EAGAIN.
+func (w *Watch) ReadSignalFD(fd int) (SignalInfo, error) {
+	var info signalfdSiginfo
+	buf := (*[unsafe.Sizeof(info)]byte)(unsafe.Pointer(&info))[:]
+	n, err := syscall.Read(fd, buf)
+	for err == syscall.EINTR {
+		n, err = syscall.Read(fd, buf)
+	}
+	switch {
+	case err == syscall.EAGAIN:
+		return SignalInfo{}, err
+	case err != nil:
+		return SignalInfo{}, fmt.Errorf("Watch ReadSignalFD lost on read(2) error %w", err)
+	case n != len(buf):
+		return SignalInfo{}, fmt.Errorf("Watch ReadSignalFD got %d bytes from signalfd(2), want %d", n, len(buf))
+	}
+
+	return SignalInfo{
+		Signal: syscall.Signal(info.Signo),
+		Code:   info.Code,
+		PID:    info.PID,
+		UID:    info.UID,
+		Status: info.Status,
+	}, nil
+}
+
+// IncludeSignals adds the signals to the watch list. The Await methods report
+// the identifier returned while any of the signals is pending, in place of a
+// file descriptor, and ReadSignals takes them off. Use ExcludeSignals to release
+// the identifier.
+//
+// Linux uses a signalfd(2) from IncludeSignalFD as the identifier, with the same
+// requirement that the signals are blocked on all threads. The BSDs, including
+// Darwin, use EVFILT_SIGNAL instead, which records delivery attempts regardless
+// of any signal handler.
+func (w *Watch) IncludeSignals(sig ...os.Signal) (id int, err error) {
+	signals := make([]syscall.Signal, len(sig))
+	for i := range sig {
+		s, ok := sig[i].(syscall.Signal)
+		if !ok {
+			return -1, fmt.Errorf("Watch IncludeSignals got signal %s of type %T, want a syscall.Signal", sig[i], sig[i])
+		}
+		signals[i] = s
+	}
+
+	fd, err := w.IncludeSignalFD(signals...)
+	if err != nil {
+		return -1, err
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		syscall.Close(fd)
+		return -1, ErrClosed
+	}
+	if w.signals == nil {
+		w.signals = make(map[int]struct{})
+	}
+	w.signals[fd] = struct{}{}
+	return fd, nil
+}
+
+// ExcludeSignals removes an identifier of IncludeSignals from the watch list.
+// Absence is ignored silently.
+func (w *Watch) ExcludeSignals(id int) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	if _, ok := w.signals[id]; !ok {
+		return nil
+	}
+	delete(w.signals, id)
+	// close(2) removes the descriptor from epoll(7) too
+	delete(w.fds, id)
+	delete(w.tokens, id)
+	if w.ring != nil {
+		w.ring.pollRemove(id)
+	}
+	return syscall.Close(id)
+}
+
+// ReadSignals takes the signals pending on an identifier of IncludeSignals. The
+// return is empty when none are pending. Linux reports each signal received in
+// order, while the BSDs coalesce repeats.
+func (w *Watch) ReadSignals(id int) ([]os.Signal, error) {
+	w.mutex.Lock()
+	_, ok := w.signals[id]
+	w.mutex.Unlock()
+	if !ok {
+		return nil, &FDError{FD: id, Err: errNotSignals}
+	}
+
+	var signals []os.Signal
+	for {
+		info, err := w.ReadSignalFD(id)
+		switch err {
+		case nil:
+			signals = append(signals, info.Signal)
+		case syscall.EAGAIN:
+			return signals, nil
+		default:
+			return signals, err
+		}
+	}
+}
+
+// ErrNotSignals denies identifiers which are not from IncludeSignals.
+var errNotSignals = errors.New("identifier not from IncludeSignals")
+
+// CloseSignals releases the descriptors of IncludeSignals. The mutex must be
+// held.
+func (w *Watch) closeSignals() {
+	for fd := range w.signals {
+		// close(2) removes the descriptor from epoll(7) too
+		syscall.Close(fd)
+		delete(w.signals, fd)
+	}
+}
+
+// SignalfdSiginfo is struct signalfd_siginfo from <sys/signalfd.h>.
+type signalfdSiginfo struct {
+	Signo   uint32
+	Errno   int32
+	Code    int32
+	PID     uint32
+	UID     uint32
+	FD      int32
+	TID     uint32
+	Band    uint32
+	Overrun uint32
+	Trapno  uint32
+	Status  int32
+	Int     int32
+	Ptr     uint64
+	Utime   uint64
+	Stime   uint64
+	Addr    uint64
+	AddrLSB uint16
+	_       [46]byte
+}
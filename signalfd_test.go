@@ -0,0 +1,172 @@
+//go:build linux
+
+package fdmom
+
+import (
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestIncludeSignalFD(t *testing.T) {
+	p := newPipe(t)
+
+	// a test failure may not terminate the process with SIGUSR2
+	signal.Notify(make(chan os.Signal, 1), syscall.SIGUSR2)
+	defer signal.Reset(syscall.SIGUSR2)
+
+	// signals blocked on this thread only
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	mask, _ := sigsetOf([]syscall.Signal{syscall.SIGUSR2})
+	var old sigset
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_RT_SIGPROCMASK, 0, // SIG_BLOCK
+		uintptr(unsafe.Pointer(&mask)), uintptr(unsafe.Pointer(&old)), unsafe.Sizeof(mask), 0, 0)
+	if errno != 0 {
+		t.Fatal("rt_sigprocmask(2) error:", errno)
+	}
+	defer syscall.RawSyscall6(syscall.SYS_RT_SIGPROCMASK, 2, // SIG_SETMASK
+		uintptr(unsafe.Pointer(&old)), 0, unsafe.Sizeof(old), 0, 0)
+
+	fd, err := p.Watch.IncludeSignalFD(syscall.SIGUSR2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fd)
+
+	_, err = p.Watch.ReadSignalFD(fd)
+	if err != syscall.EAGAIN {
+		t.Errorf("read without pending signal got error %v, want EAGAIN", err)
+	}
+
+	err = syscall.Tgkill(os.Getpid(), syscall.Gettid(), syscall.SIGUSR2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := p.Watch.AwaitFDWithRead(holdupMax)
+	if err != nil || got != fd {
+		t.Fatalf("got FD %#x with error %v, want signalfd %#x", got, err, fd)
+	}
+	info, err := p.Watch.ReadSignalFD(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Signal != syscall.SIGUSR2 || info.PID != uint32(os.Getpid()) {
+		t.Errorf("got signal %s from PID %d, want %s from PID %d",
+			info.Signal, info.PID, syscall.SIGUSR2, os.Getpid())
+	}
+}
+
+// The kernel rejects a sigset_t of the wrong size with EINVAL.
+func TestIncludeSignalFDRange(t *testing.T) {
+	p := newPipe(t)
+
+	fd, err := p.Watch.IncludeSignalFD(nsig)
+	if err != nil {
+		t.Fatalf("include of signal %d got error: %s", nsig, err)
+	}
+	syscall.Close(fd)
+
+	_, err = p.Watch.IncludeSignalFD(nsig + 1)
+	if err == nil {
+		t.Errorf("include of signal %d got no error", nsig+1)
+	}
+}
+
+func TestIncludeSignals(t *testing.T) {
+	p := newPipe(t)
+
+	// signals blocked on this thread only
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	mask, _ := sigsetOf([]syscall.Signal{syscall.SIGWINCH})
+	var old sigset
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_RT_SIGPROCMASK, 0, // SIG_BLOCK
+		uintptr(unsafe.Pointer(&mask)), uintptr(unsafe.Pointer(&old)), unsafe.Sizeof(mask), 0, 0)
+	if errno != 0 {
+		t.Fatal("rt_sigprocmask(2) error:", errno)
+	}
+	defer syscall.RawSyscall6(syscall.SYS_RT_SIGPROCMASK, 2, // SIG_SETMASK
+		uintptr(unsafe.Pointer(&old)), 0, unsafe.Sizeof(old), 0, 0)
+
+	id, err := p.Watch.IncludeSignals(syscall.SIGWINCH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		err = syscall.Tgkill(os.Getpid(), syscall.Gettid(), syscall.SIGWINCH)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := p.Watch.AwaitFDWithRead(holdupMax)
+	if err != nil || got != id {
+		t.Fatalf("got FD %#x with error %v, want identifier %#x", got, err, id)
+	}
+	// standard signals do not queue
+	signals, err := p.Watch.ReadSignals(id)
+	if err != nil || len(signals) != 1 || signals[0] != syscall.SIGWINCH {
+		t.Errorf("got signals %v with error %v, want [%s]", signals, err, syscall.SIGWINCH)
+	}
+
+	err = p.Watch.ExcludeSignals(id)
+	if err != nil {
+		t.Fatal("exclude error:", err)
+	}
+	if p.Watch.IsWatched(id) {
+		t.Error("identifier still on watch list after exclude")
+	}
+}
+
+func TestAwaitFDWithReadMasked(t *testing.T) {
+	p := newPipe(t)
+
+	received := make(chan os.Signal, 1)
+	signal.Notify(received, syscall.SIGUSR1)
+	defer signal.Stop(received)
+
+	// signal blocked on this thread only
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	mask, _ := sigsetOf([]syscall.Signal{syscall.SIGUSR1})
+	var old sigset
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_RT_SIGPROCMASK, 0, // SIG_BLOCK
+		uintptr(unsafe.Pointer(&mask)), uintptr(unsafe.Pointer(&old)), unsafe.Sizeof(mask), 0, 0)
+	if errno != 0 {
+		t.Fatal("rt_sigprocmask(2) error:", errno)
+	}
+	defer syscall.RawSyscall6(syscall.SYS_RT_SIGPROCMASK, 2, // SIG_SETMASK
+		uintptr(unsafe.Pointer(&old)), 0, unsafe.Sizeof(old), 0, 0)
+
+	err := syscall.Tgkill(os.Getpid(), syscall.Gettid(), syscall.SIGUSR1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// pending signal stays blocked with mask
+	_, err = p.Watch.AwaitFDWithReadMasked(0, syscall.SIGUSR1)
+	if err != ErrTimeout {
+		t.Fatalf("got error %v, want ErrTimeout", err)
+	}
+	select {
+	case <-received:
+		t.Fatal("signal delivered while blocked")
+	default:
+	}
+
+	// pending signal delivered without mask
+	_, err = p.Watch.AwaitFDWithReadMasked(holdupMax)
+	if err != ErrTimeout {
+		t.Fatalf("got error %v, want ErrTimeout", err)
+	}
+	select {
+	case <-received:
+	case <-time.After(holdupMax):
+		t.Error("signal not delivered during wait")
+	}
+}
@@ -0,0 +1,28 @@
+//go:build linux
+
+package fdmom
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// Sigset is the kernel sigset_t, with a size of _NSIG/8 bytes. The unsigned
+// long words match the bit order of the kernel on big-endian platforms too.
+type sigset [nsig / 8 / unsafe.Sizeof(uintptr(0))]uintptr
+
+// SigsetOf returns the kernel sigset_t with each of the signals.
+func sigsetOf(signals []syscall.Signal) (sigset, error) {
+	const wordBits = 8 * unsafe.Sizeof(uintptr(0))
+
+	var set sigset
+	for _, sig := range signals {
+		if sig < 1 || sig > nsig {
+			return set, fmt.Errorf("signal %d out of range", sig)
+		}
+		bit := uintptr(sig - 1)
+		set[bit/wordBits] |= 1 << (bit % wordBits)
+	}
+	return set, nil
+}
@@ -0,0 +1,6 @@
+//go:build linux && !(mips || mipsle || mips64 || mips64le)
+
+package fdmom
+
+// Nsig is _NSIG from the kernel, the number of signals in a sigset_t.
+const nsig = 64
@@ -0,0 +1,6 @@
+//go:build linux && (mips || mipsle || mips64 || mips64le)
+
+package fdmom
+
+// Nsig is _NSIG from the kernel, which is twice the size for MIPS.
+const nsig = 128
@@ -0,0 +1,190 @@
+//go:build linux || darwin || netbsd || freebsd || openbsd || dragonfly
+
+package fdmom
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats has cumulative counts since OpenWatch, and the watch list size.
+type Stats struct {
+	Awaits        uint64 // invocations of any Await method
+	Events        uint64 // file descriptors returned by Await
+	Timeouts      uint64 // ErrTimeout returned by Await
+	Restarts      uint64 // waits interrupted by a signal (EINTR)
+	IncludeErrors uint64 // failed includes and modifications
+	WatchList     int    // file descriptors on the watch list
+}
+
+// Counters track Stats atomically.
+type counters struct {
+	awaits      atomic.Uint64
+	events      atomic.Uint64
+	timeouts    atomic.Uint64
+	restarts    atomic.Uint64
+	includeErrs atomic.Uint64
+
+	perFDMutex sync.Mutex
+	perFD      map[int]uint64 // nil without CountPerFD
+
+	latency *latencyTracker  // nil without TrackLatency
+	diag    func(Diagnostic) // nil without Diagnostics
+}
+
+// LatencyHistogram counts gaps per range of duration. Entry i counts the gaps
+// shorter than 1 µs << i, which were not counted by any of the entries before.
+// The last entry counts the remaining gaps, from 1 µs << 20 (about a second).
+type LatencyHistogram [22]uint64
+
+// LatencyTracker measures the gaps between a file descriptor returned and the
+// next Await.
+type latencyTracker struct {
+	slowGap   time.Duration
+	slowEvent func(int, time.Duration) // optional
+
+	mutex     sync.Mutex
+	returned  time.Time // zero when measured already
+	lastFD    int       // returned last
+	histogram LatencyHistogram
+}
+
+// Await accounts for an invocation of any Await method.
+func (c *counters) await() {
+	c.awaits.Add(1)
+	if c.latency == nil {
+		return
+	}
+
+	now := time.Now()
+	t := c.latency
+	t.mutex.Lock()
+	if t.returned.IsZero() {
+		t.mutex.Unlock()
+		return
+	}
+	gap := now.Sub(t.returned)
+	t.returned = time.Time{}
+	fd := t.lastFD
+	i := 0
+	for i < len(t.histogram)-1 && gap >= time.Microsecond<<i {
+		i++
+	}
+	t.histogram[i]++
+	t.mutex.Unlock()
+
+	if t.slowEvent != nil && gap > t.slowGap {
+		t.slowEvent(fd, gap)
+	}
+}
+
+// Event accounts for a file descriptor returned by Await.
+func (c *counters) event(fd int) {
+	c.events.Add(1)
+	if c.latency != nil {
+		c.latency.mutex.Lock()
+		c.latency.returned = time.Now()
+		c.latency.lastFD = fd
+		c.latency.mutex.Unlock()
+	}
+	if c.perFD != nil {
+		c.perFDMutex.Lock()
+		c.perFD[fd]++
+		c.perFDMutex.Unlock()
+	}
+}
+
+// Include accounts for an include or a modification, with op "include" or
+// "modify" respectively. The return is err.
+func (c *counters) include(op string, fd int, interest Interest, err error) error {
+	if err != nil && err != ErrClosed {
+		c.includeErrs.Add(1)
+	}
+	if c.diag != nil {
+		c.diag(Diagnostic{Op: op, FD: fd, Interest: interest, Err: err})
+	}
+	return err
+}
+
+// Exclude accounts for an exclusion. The return is err.
+func (c *counters) exclude(fd int, err error) error {
+	if c.diag != nil {
+		c.diag(Diagnostic{Op: "exclude", FD: fd, Err: err})
+	}
+	return err
+}
+
+// Restart accounts for a wait interrupted by a signal (EINTR).
+func (c *counters) restart() {
+	c.restarts.Add(1)
+	if c.diag != nil {
+		c.diag(Diagnostic{Op: "restart", FD: -1})
+	}
+}
+
+// Unavailable accounts for a kernel error which fails an Await. The return is
+// err.
+func (c *counters) unavailable(err error) error {
+	if c.diag != nil {
+		c.diag(Diagnostic{Op: "unavailable", FD: -1, Err: err})
+	}
+	return err
+}
+
+// Stats returns a snapshot of the counters. The numbers are read individually,
+// i.e., they may be off by the Await in progress, if any. Stats is safe for use
+// during an Await, from any goroutine.
+func (w *Watch) Stats() Stats {
+	w.mutex.Lock()
+	watchList := len(w.fds)
+	w.mutex.Unlock()
+	return Stats{
+		Awaits:        w.counters.awaits.Load(),
+		Events:        w.counters.events.Load(),
+		Timeouts:      w.counters.timeouts.Load(),
+		Restarts:      w.counters.restarts.Load(),
+		IncludeErrors: w.counters.includeErrs.Load(),
+		WatchList:     watchList,
+	}
+}
+
+// EventCount returns the number of times Await returned the file descriptor
+// (or timer identifier) since OpenWatch. Counts are per number, i.e., they are
+// not reset when a file descriptor leaves the watch list and the number gets
+// reused. EventCount always returns zero without the CountPerFD option.
+func (w *Watch) EventCount(fd int) uint64 {
+	if w.counters.perFD == nil {
+		return 0
+	}
+	w.counters.perFDMutex.Lock()
+	defer w.counters.perFDMutex.Unlock()
+	return w.counters.perFD[fd]
+}
+
+// EventCounts returns a snapshot of each EventCount which is not zero, or nil
+// without the CountPerFD option.
+func (w *Watch) EventCounts() map[int]uint64 {
+	if w.counters.perFD == nil {
+		return nil
+	}
+	w.counters.perFDMutex.Lock()
+	defer w.counters.perFDMutex.Unlock()
+	snapshot := make(map[int]uint64, len(w.counters.perFD))
+	for fd, n := range w.counters.perFD {
+		snapshot[fd] = n
+	}
+	return snapshot
+}
+
+// Latencies returns a snapshot of the gaps measured, or nil without the
+// TrackLatency option.
+func (w *Watch) Latencies() *LatencyHistogram {
+	if w.counters.latency == nil {
+		return nil
+	}
+	w.counters.latency.mutex.Lock()
+	defer w.counters.latency.mutex.Unlock()
+	snapshot := w.counters.latency.histogram
+	return &snapshot
+}
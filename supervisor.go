@@ -0,0 +1,121 @@
+//go:build linux || darwin || netbsd || freebsd || openbsd || dragonfly
+
+package fdmom
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// Supervisor maps the file descriptors on a Watch back to their connection.
+// Connections register with IncludeConn semantics, and they deregister on Close
+// automatically.
+type Supervisor struct {
+	w *Watch
+
+	mutex sync.Mutex
+	conns map[int]*supervisedConn // registered per file descriptor
+}
+
+// NewSupervisor returns a Supervisor on the Watch. Other file descriptors may
+// be on the same Watch, which Await then reports as an *FDError.
+func NewSupervisor(w *Watch) *Supervisor {
+	return &Supervisor{
+		w:     w,
+		conns: make(map[int]*supervisedConn),
+	}
+}
+
+// Register adds the connection to the watch list, like IncludeConn does. The
+// return wraps conn such that Close excludes the file descriptor before the
+// connection closes. Await reports the wrapper, which must be used in place of
+// conn from now on. The wrapper provides the original with a NetConn method.
+func (s *Supervisor) Register(conn net.Conn) (net.Conn, error) {
+	c, err := syscallConn(conn)
+	if err != nil {
+		return nil, err
+	}
+	wrapper := &supervisedConn{Conn: conn, s: s}
+	_, err = controlFD(c, func(fd int) error {
+		// lock before include to beat any Await on fd
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		err := s.w.IncludeFD(fd)
+		if err != nil {
+			return err
+		}
+		wrapper.fd = fd
+		s.conns[fd] = wrapper
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return wrapper, nil
+}
+
+// Deregister removes the connection from the watch list, without Close. The
+// connection must be one from Register. Absence is ignored silently.
+func (s *Supervisor) Deregister(conn net.Conn) error {
+	wrapper, ok := conn.(*supervisedConn)
+	if !ok || wrapper.s != s {
+		return errors.New("connection not from Register of the Supervisor")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.conns[wrapper.fd] != wrapper {
+		return nil // absent
+	}
+	delete(s.conns, wrapper.fd)
+	return s.w.ExcludeFD(wrapper.fd)
+}
+
+// Len returns the number of connections registered.
+func (s *Supervisor) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.conns)
+}
+
+// Await is like AwaitEvent, yet with the connection from Register in addition.
+// File descriptors which are not from Register come as an *FDError.
+func (s *Supervisor) Await(timeout time.Duration) (net.Conn, Event, error) {
+	event, err := s.w.AwaitEvent(timeout)
+	if err != nil {
+		return nil, Event{}, err
+	}
+
+	s.mutex.Lock()
+	wrapper, ok := s.conns[event.FD]
+	s.mutex.Unlock()
+	if !ok {
+		return nil, Event{}, &FDError{FD: event.FD, Err: errors.New("not a connection from Register")}
+	}
+	return wrapper, event, nil
+}
+
+// SupervisedConn is a connection from Register.
+type supervisedConn struct {
+	net.Conn
+	s  *Supervisor
+	fd int // registered
+}
+
+// NetConn returns the connection as passed to Register.
+func (c *supervisedConn) NetConn() net.Conn { return c.Conn }
+
+// Close deregisters the connection before it closes. The file descriptor may be
+// reused by the Go runtime any time after.
+func (c *supervisedConn) Close() error {
+	err := c.s.Deregister(c)
+	if err == ErrClosed {
+		err = nil // Watch gone already
+	}
+	if closeErr := c.Conn.Close(); closeErr != nil {
+		return closeErr
+	}
+	return err
+}
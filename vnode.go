@@ -0,0 +1,102 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+
+package fdmom
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// VnodeNote is a set of file change conditions from EVFILT_VNODE.
+type VnodeNote uint32
+
+// File changes are bit flags.
+const (
+	NoteWrite  VnodeNote = syscall.NOTE_WRITE  // content written
+	NoteDelete VnodeNote = syscall.NOTE_DELETE // file unlinked
+	NoteRename VnodeNote = syscall.NOTE_RENAME // file renamed
+	NoteExtend VnodeNote = syscall.NOTE_EXTEND // file size increased
+)
+
+// WatchVnode adds the file descriptor to the watch list for changes on the file
+// system. Any of the notes fired get the file descriptor reported by the Await
+// methods, and VnodeNotes tells which ones. Calls on file descriptors already
+// watched replace their notes. Use UnwatchVnode to remove the file descriptor.
+//
+// WatchVnode is available on the BSDs only, including Darwin. WatchPath has a
+// portable equivalent, with inotify(7) on Linux.
+func (w *Watch) WatchVnode(fd int, notes VnodeNote) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+
+	var change syscall.Kevent_t
+	// edge-triggered as notes can not be read off
+	syscall.SetKevent(&change, fd, syscall.EVFILT_VNODE, syscall.EV_ADD|syscall.EV_CLEAR)
+	change.Fflags = uint32(notes)
+	errno, err := w.apply(&change)
+	if err != nil {
+		if err == syscall.EBADF {
+			return ErrClosed
+		}
+		return fmt.Errorf("Watch WatchVnode lost on kevent(2) error %w", err)
+	}
+	switch errno {
+	case 0:
+		break
+	case syscall.EBADF:
+		return ErrBadFD
+	default:
+		return fmt.Errorf("Watch WatchVnode denied by kevent(2) with error %w", errno)
+	}
+	w.vnodes[fd] = notes
+	return nil
+}
+
+// UnwatchVnode removes the file descriptor from the watch list of WatchVnode.
+// Absence is ignored silently.
+func (w *Watch) UnwatchVnode(fd int) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+
+	var change syscall.Kevent_t
+	syscall.SetKevent(&change, fd, syscall.EVFILT_VNODE, syscall.EV_DELETE)
+	errno, err := w.apply(&change)
+	if err != nil {
+		if err == syscall.EBADF {
+			return ErrClosed
+		}
+		return fmt.Errorf("Watch UnwatchVnode lost on kevent(2) error %w", err)
+	}
+	if errno != 0 && errno != syscall.ENOENT && errno != syscall.EBADF {
+		return fmt.Errorf("Watch UnwatchVnode denied by kevent(2) with error %w", errno)
+	}
+	delete(w.vnodes, fd)
+	delete(w.vnodesFired, fd)
+	w.unstash(fd, false)
+	return nil
+}
+
+// VnodeNotes takes the notes fired on a file descriptor of WatchVnode since the
+// previous call. The return is zero when none fired.
+func (w *Watch) VnodeNotes(fd int) VnodeNote {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	notes := w.vnodesFired[fd]
+	delete(w.vnodesFired, fd)
+	return notes
+}
+
+// VnodeFired records the notes of an EVFILT_VNODE event.
+func (w *Watch) vnodeFired(event *syscall.Kevent_t) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if _, ok := w.vnodes[int(event.Ident)]; ok {
+		w.vnodesFired[int(event.Ident)] |= VnodeNote(event.Fflags)
+	}
+}
@@ -0,0 +1,58 @@
+//go:build darwin || netbsd || freebsd || openbsd || dragonfly
+
+package fdmom
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWatchVnode(t *testing.T) {
+	p := newPipe(t)
+
+	path := filepath.Join(t.TempDir(), "vnode")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fd := int(f.Fd())
+
+	err = p.Watch.WatchVnode(fd, NoteWrite|NoteExtend|NoteDelete)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.Watch.AwaitFDWithRead(0)
+	if err != ErrTimeout {
+		t.Fatalf("got error %v before any change, want ErrTimeout", err)
+	}
+
+	_, err = f.WriteString("Hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.Watch.AwaitFDWithRead(holdupMax)
+	if err != nil || got != fd {
+		t.Fatalf("got FD %#x with error %v, want FD %#x", got, err, fd)
+	}
+	if notes := p.Watch.VnodeNotes(fd); notes&NoteWrite == 0 || notes&NoteDelete != 0 {
+		t.Errorf("got notes %#x after write, want NoteWrite without NoteDelete", notes)
+	}
+	if notes := p.Watch.VnodeNotes(fd); notes != 0 {
+		t.Errorf("got notes %#x again, want none", notes)
+	}
+
+	err = p.Watch.UnwatchVnode(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteString("Hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.Watch.AwaitFDWithRead(0)
+	if err != ErrTimeout {
+		t.Errorf("got error %v after UnwatchVnode, want ErrTimeout", err)
+	}
+}
@@ -0,0 +1,815 @@
+//go:build linux || darwin || netbsd || freebsd || openbsd || dragonfly
+
+package fdmom
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// IsClosed returns whether Close was called, without locking the mutex.
+func (w *Watch) isClosed() bool {
+	select {
+	case <-w.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// AwaitFDWithReadTimed is like AwaitFDWithRead, yet it also reports how long it
+// took. The duration includes any restarts from signal interruption.
+func (w *Watch) AwaitFDWithReadTimed(timeout time.Duration) (fd int, waited time.Duration, err error) {
+	start := time.Now()
+	fd, err = w.AwaitFDWithRead(timeout)
+	return fd, time.Since(start), err
+}
+
+// RawConn implements syscall.RawConn for the kernel descriptor of a Watch.
+type rawConn struct {
+	w  *Watch
+	fd int
+}
+
+// Control implements the syscall.RawConn interface.
+func (c rawConn) Control(f func(fd uintptr)) error {
+	if !c.w.enter() {
+		return ErrClosed
+	}
+	defer c.w.leave()
+	f(uintptr(c.fd))
+	return nil
+}
+
+// Read implements the syscall.RawConn interface. The descriptor is readable
+// while events are pending.
+func (c rawConn) Read(f func(fd uintptr) (done bool)) error {
+	if !c.w.enter() {
+		return ErrClosed
+	}
+	defer c.w.leave()
+	for !f(uintptr(c.fd)) {
+		select {
+		case <-c.w.done:
+			return ErrClosed
+		default:
+		}
+		// Close wakes with an event pending
+		_, err := PollFD(c.fd, -1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write implements the syscall.RawConn interface.
+func (c rawConn) Write(f func(fd uintptr) (done bool)) error {
+	return errors.New("Watch descriptor not writable")
+}
+
+// Paused marks file descriptors from PauseFD on the watch list. The bit is out
+// of range for the conditions and modes.
+const paused Interest = 1 << 31
+
+// RearmFD applies the interest of a file descriptor on the watch list once more,
+// which enables reporting after a disarm from the OneShot option. Absence from
+// the watch list gets ErrNotWatched.
+func (w *Watch) RearmFD(fd int) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	interest, ok := w.fds[fd]
+	if !ok {
+		return ErrNotWatched
+	}
+	return w.add(fd, interest)
+}
+
+// EdgeTriggered returns whether interest gets edge-triggered delivery, with the
+// Edge and Level modes over the EdgeTriggered option.
+func (w *Watch) edgeTriggered(interest Interest) bool {
+	if interest&Edge != 0 {
+		return true
+	}
+	return w.config.edgeTriggered && interest&Level == 0
+}
+
+// Wakeup interrupts an Await in progress with ErrWoken, or the next Await when
+// none is in progress. Wakeups pending coalesce into one. Linux signals with an
+// eventfd(2), and the BSDs signal with a pipe(2), as EVFILT_USER is not
+// available on all of them.
+func (w *Watch) Wakeup() error {
+	if !w.enter() {
+		return ErrClosed
+	}
+	defer w.leave()
+	w.wake()
+	return nil
+}
+
+// WakeOpen is like wake, yet without effect after Close, as the descriptor may
+// be reused by then.
+func (w *Watch) wakeOpen() {
+	if w.enter() {
+		w.wake()
+		w.leave()
+	}
+}
+
+// Post queues token for AwaitEvent, which reports it as an Event with Posted
+// set, in order of Post, before any readiness. The wakeup from Wakeup delivers
+// the token, which means that the other Await methods get ErrWoken instead, and
+// they leave the token pending for AwaitEvent. Applications may use tokens for
+// work queued or for shutdown requests, alongside readiness in the same loop.
+func (w *Watch) Post(token uint64) error {
+	w.mutex.Lock()
+	if w.closed {
+		w.mutex.Unlock()
+		return ErrClosed
+	}
+	w.posted = append(w.posted, token)
+	w.mutex.Unlock()
+	return w.Wakeup()
+}
+
+// TakePosted returns the first token pending from Post, if any.
+func (w *Watch) takePosted() (token uint64, ok bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if len(w.posted) == 0 {
+		return 0, false
+	}
+	token = w.posted[0]
+	w.posted = w.posted[1:]
+	return token, true
+}
+
+// AwaitEvent is like AwaitFD, yet with the result in one Event. File descriptors
+// included for both read and Write report both in one Event when both are ready
+// at the same time. Kqueue(2) has distinct filters for read and Write, which get
+// merged when they are read in the same batch. KernelOrder reads one event per
+// batch, i.e., the two come as separate events.
+func (w *Watch) AwaitEvent(timeout time.Duration) (Event, error) {
+	if token, ok := w.takePosted(); ok {
+		return Event{FD: -1, Token: token, Posted: true, Available: -1}, nil
+	}
+	fd, ready, err := w.AwaitFD(timeout)
+	if err != nil {
+		if err == ErrWoken {
+			if token, ok := w.takePosted(); ok {
+				return Event{FD: -1, Token: token, Posted: true, Available: -1}, nil
+			}
+		}
+		return Event{}, err
+	}
+	available := w.readable(fd, ready)
+	w.mutex.Lock()
+	token, excluded := w.tokenOf(fd, ready)
+	w.mutex.Unlock()
+	return Event{FD: fd, Ready: ready, Token: token, Available: available, Excluded: excluded}, nil
+}
+
+// AwaitEvents is like AwaitEvent, yet it fills buf with the events from a single
+// batch, as AwaitFDs does with file descriptors. The return is the number of
+// events in buf, which is at least one on success. Tokens from Post fill buf
+// without a wait. Buffers of up to 64 events get the results without any heap
+// allocation, including the ErrTimeout, ErrWoken and ErrClosed errors, which
+// suits event loops on the hot path. Events beyond capacity remain for the next
+// await.
+func (w *Watch) AwaitEvents(buf []Event, timeout time.Duration) (n int, err error) {
+	n = w.takePostedInto(buf)
+	if n != 0 {
+		return n, nil
+	}
+	n, err = w.awaitBatch(awaitBuf{events: buf}, timeout)
+	if err == ErrWoken {
+		if n := w.takePostedInto(buf); n != 0 {
+			return n, nil
+		}
+	}
+	return n, err
+}
+
+// TakePostedInto fills buf with the tokens pending from Post, if any. The return
+// is the number of events in buf.
+func (w *Watch) takePostedInto(buf []Event) (n int) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for n < len(buf) && len(w.posted) != 0 {
+		buf[n] = Event{FD: -1, Token: w.posted[0], Posted: true, Available: -1}
+		w.posted = w.posted[1:]
+		n++
+	}
+	return n
+}
+
+// AwaitBuf is the destination of awaitBatch, with either file descriptors, or
+// events when fds is nil.
+type awaitBuf struct {
+	fds    []int
+	events []Event
+}
+
+// Len returns the capacity in number of results.
+func (b awaitBuf) len() int {
+	if b.fds != nil {
+		return len(b.fds)
+	}
+	return len(b.events)
+}
+
+// Put sets result i of buf. Available is only used for events, with -1 for
+// unknown.
+func (w *Watch) put(buf awaitBuf, i, fd int, ready Interest, available int) {
+	if buf.fds != nil {
+		buf.fds[i] = fd
+		return
+	}
+	w.mutex.Lock()
+	token, excluded := w.tokenOf(fd, ready)
+	w.mutex.Unlock()
+	buf.events[i] = Event{FD: fd, Ready: ready, Token: token, Available: available, Excluded: excluded}
+}
+
+// ExcludeHungUp removes fd from the watch list for ExcludeOnHangup. Any token
+// remains for the terminal event.
+func (w *Watch) excludeHungUp(fd int) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return
+	}
+	token, ok := w.tokens[fd]
+	// errors are for ExcludeFD to report
+	w.counters.exclude(fd, w.exclude(fd))
+	if !ok {
+		delete(w.hungUp, fd) // from a previous use of the number
+		return
+	}
+	if w.hungUp == nil {
+		w.hungUp = make(map[int]uint64)
+	}
+	w.hungUp[fd] = token
+}
+
+// ResetAssociations drops the children, the listeners and the tokens pending
+// from ExcludeOnHangup of file descriptors which are no longer on the watch list,
+// for Reset. The mutex must be held.
+func (w *Watch) resetAssociations() {
+	for fd := range w.children {
+		if _, ok := w.fds[fd]; !ok {
+			delete(w.children, fd)
+		}
+	}
+	for fd := range w.listeners {
+		if _, ok := w.fds[fd]; !ok {
+			delete(w.listeners, fd)
+		}
+	}
+	for fd := range w.hungUp {
+		delete(w.hungUp, fd)
+	}
+}
+
+// TokenOf returns the token for an event of fd, with true for the terminal
+// event from ExcludeOnHangup. The mutex must be held.
+func (w *Watch) tokenOf(fd int, ready Interest) (token uint64, excluded bool) {
+	if ready&Hangup == 0 || !w.config.excludeOnHangup {
+		return w.tokens[fd], false
+	}
+	token = w.hungUp[fd]
+	delete(w.hungUp, fd)
+	return token, true
+}
+
+// IncludeFDToken is like IncludeFD, yet AwaitEvent reports token with each event
+// of the file descriptor, until it leaves the watch list. The typical use is an
+// index to the connection state, without a lookup table on the side. Tokens are
+// kept by the Watch, as the user data field of epoll(7) is taken by the file
+// descriptor already.
+func (w *Watch) IncludeFDToken(fd int, token uint64) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	err := w.counters.include("include", fd, Read, w.add(fd, Read|w.fds[fd]))
+	if err != nil {
+		return err
+	}
+	if w.tokens == nil {
+		w.tokens = make(map[int]uint64)
+	}
+	w.tokens[fd] = token
+	return nil
+}
+
+// IncludeFDRank is like IncludeFD, yet with a rank until the file descriptor
+// leaves the watch list. Higher ranks go first. The default rank is zero, which
+// means that negative ranks go after any file descriptor without. AwaitFDs and
+// AwaitEvents fill their buffer by rank, also when more are ready than the buffer
+// can hold, for a batch of up to 64 events from the kernel, with io_uring(7)
+// excluded. AwaitFD and its derivatives follow ranks with RankOrder only.
+func (w *Watch) IncludeFDRank(fd int, rank int) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	err := w.counters.include("include", fd, Read, w.add(fd, Read|w.fds[fd]))
+	if err != nil {
+		return err
+	}
+	if w.ranks == nil {
+		w.ranks = make(map[int]int)
+	}
+	w.ranks[fd] = rank
+	return nil
+}
+
+// SweepFDs removes the file descriptors which are not open anymore from the
+// watch list, i.e., the ones closed without ExcludeFD. Registrations vanish on
+// close(2) with epoll(7), while the watch list would keep them otherwise, and
+// so would the tokens, the ranks and the io_uring(7) requests. Numbers which
+// are in use again can not be told apart from the original. The return has each
+// file descriptor removed, in no particular order.
+func (w *Watch) SweepFDs() ([]int, error) {
+	w.mutex.Lock()
+	if w.closed {
+		w.mutex.Unlock()
+		return nil, ErrClosed
+	}
+	var removed []int
+	for fd := range w.fds {
+		_, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_GETFD, 0)
+		if errno == syscall.EBADF {
+			w.forget(fd)
+			removed = append(removed, fd)
+		}
+	}
+	w.mutex.Unlock()
+
+	if f := w.config.deadFD; f != nil {
+		for _, fd := range removed {
+			f(fd)
+		}
+	}
+	return removed, nil
+}
+
+// Sweep runs SweepFDs every interval until Close.
+func (w *Watch) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := w.SweepFDs(); err != nil {
+				return
+			}
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// Ranked returns whether any file descriptor has a rank from IncludeFDRank.
+func (w *Watch) ranked() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return len(w.ranks) != 0
+}
+
+// AwaitFDWithWrite blocks until it finds a file descriptor with Write available,
+// as included with IncludeFDForWrite or IncludeFDInterest. A pending Error or
+// Hangup also counts, as writes fail without blocking then. Timeouts apply as
+// with AwaitFDWithRead. Each call registers the file descriptors with Write
+// interest in a new Watch of its own, for Write only, which costs a few system
+// calls, like AwaitAny does. Changes to the watch list do not apply to the call
+// in progress. Watches with both read and write interest should use AwaitFD
+// instead.
+func (w *Watch) AwaitFDWithWrite(timeout time.Duration) (fd int, err error) {
+	w.counters.await()
+	w.mutex.Lock()
+	if w.closed {
+		w.mutex.Unlock()
+		return 0, ErrClosed
+	}
+	var fds []int
+	for fd, interest := range w.fds {
+		if interest&Write != 0 && interest&paused == 0 {
+			fds = append(fds, fd)
+		}
+	}
+	w.mutex.Unlock()
+
+	// wake descriptor remains open until leave
+	if !w.enter() {
+		return 0, ErrClosed
+	}
+	defer w.leave()
+
+	writes, err := OpenWatch()
+	if err != nil {
+		return 0, err
+	}
+	defer writes.Close()
+	wakeFD := w.wakeReadFD()
+	err = writes.IncludeFD(wakeFD)
+	if err != nil {
+		return 0, err
+	}
+	for _, fd := range fds {
+		err := writes.IncludeFDInterest(fd, Write)
+		if err != nil {
+			return 0, &FDError{FD: fd, Err: err}
+		}
+	}
+
+	// restarts continue with the remainder
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	for {
+		fd, ready, err := writes.AwaitFD(timeout)
+		if err != nil {
+			if err == ErrTimeout {
+				w.counters.timeouts.Add(1)
+			}
+			return 0, err
+		}
+		if fd == wakeFD {
+			err := w.woken()
+			if err != nil {
+				return 0, err
+			}
+			// wakeup taken by another routine
+			timeout = remaining(timeout, deadline)
+			continue
+		}
+		if ready&(Write|Error|Hangup) == 0 {
+			timeout = remaining(timeout, deadline)
+			continue
+		}
+		w.counters.event(fd)
+		w.deadlineReady(fd)
+		return fd, nil
+	}
+}
+
+// AwaitAny is like AwaitFDWithRead on each of the watches simultaneously. The
+// return has the Watch with the file descriptor ready. Each call nests the
+// watches in a new Watch of its own, which costs a few system calls. See
+// IncludeWatch for a lasting setup.
+func AwaitAny(timeout time.Duration, watches ...*Watch) (w *Watch, fd int, err error) {
+	parent, err := OpenWatch()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer parent.Close()
+
+	byFD := make(map[int]*Watch, len(watches))
+	for _, child := range watches {
+		f, err := child.File()
+		if err != nil {
+			return nil, 0, err
+		}
+		defer f.Close()
+		fd := int(f.Fd())
+		err = parent.IncludeFD(fd)
+		if err != nil {
+			return nil, 0, err
+		}
+		byFD[fd] = child
+	}
+
+	// restarts continue with the remainder
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	for {
+		childFD, err := parent.AwaitFDWithRead(timeout)
+		if err != nil {
+			return nil, 0, err
+		}
+		w = byFD[childFD]
+		fd, err = w.AwaitFDWithRead(0)
+		switch err {
+		case nil:
+			return w, fd, nil
+		case ErrTimeout, ErrWoken:
+			// event taken by another routine
+			timeout = remaining(timeout, deadline)
+			continue
+		}
+		return w, 0, err
+	}
+}
+
+// IncludeWatch adds the descriptor of child, as in FD, to the watch list, such
+// that subsystems can maintain a private Watch which a top-level loop drains.
+// Await reports the descriptor ready for Read when child has events, including
+// any read ahead, which the kernel descriptor does not reflect. Child gets the
+// Watch of such descriptor, to be drained with a zero timeout until ErrTimeout.
+// A Watch can not include itself, nor a child which includes it in turn. The
+// kernel drops closed children, yet they remain on the watch list until
+// ExcludeWatch.
+func (w *Watch) IncludeWatch(child *Watch) error {
+	fd := child.FD()
+	if fd < 0 {
+		return ErrClosed
+	}
+	if child == w {
+		return opError("IncludeWatch", fd, syscall.EINVAL)
+	}
+	if child.includes(w) {
+		return opError("IncludeWatch", fd, syscall.ELOOP)
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	err := w.counters.include("include", fd, Read, w.add(fd, Read|w.fds[fd]))
+	if err != nil {
+		return err
+	}
+	if w.children == nil {
+		w.children = make(map[int]*Watch)
+	}
+	w.children[fd] = child
+	return nil
+}
+
+// ExcludeWatch removes a child from IncludeWatch, which may be closed already.
+// Absence is ignored silently.
+func (w *Watch) ExcludeWatch(child *Watch) error {
+	childClosed := child.FD() < 0
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	for fd, c := range w.children {
+		if c != child {
+			continue
+		}
+		delete(w.children, fd)
+		if childClosed {
+			w.forget(fd)
+			return nil
+		}
+		return w.counters.exclude(fd, w.exclude(fd))
+	}
+	return nil
+}
+
+// Child returns the Watch from IncludeWatch with fd as its descriptor, or nil
+// when fd is not a child on the watch list.
+func (w *Watch) Child(fd int) *Watch {
+	w.mutex.Lock()
+	child := w.children[fd]
+	_, ok := w.fds[fd]
+	w.mutex.Unlock()
+	// closed children may have their number in use again
+	if !ok || child == nil || child.FD() != fd {
+		return nil
+	}
+	return child
+}
+
+// Includes returns whether other is a child from IncludeWatch, either directly
+// or through any of the children.
+func (w *Watch) includes(other *Watch) bool {
+	w.mutex.Lock()
+	children := make([]*Watch, 0, len(w.children))
+	for _, c := range w.children {
+		children = append(children, c)
+	}
+	w.mutex.Unlock()
+
+	for _, c := range children {
+		if c == other || c.includes(other) {
+			return true
+		}
+	}
+	return false
+}
+
+// PendingChild returns the descriptor of a child from IncludeWatch with events
+// read ahead, or -1 for none. The kernel descriptor of such child may not be
+// ready.
+func (w *Watch) pendingChild() int {
+	w.mutex.Lock()
+	if len(w.children) == 0 {
+		w.mutex.Unlock()
+		return -1
+	}
+	fds := make([]int, 0, len(w.children))
+	children := make([]*Watch, 0, len(w.children))
+	for fd, c := range w.children {
+		if interest, ok := w.fds[fd]; ok && interest&paused == 0 {
+			fds = append(fds, fd)
+			children = append(children, c)
+		}
+	}
+	w.mutex.Unlock()
+
+	// one mutex at a time
+	for i, c := range children {
+		if c.hasReadAhead() {
+			return fds[i]
+		}
+	}
+	return -1
+}
+
+// IsWatched returns whether the file descriptor is on the watch list, which
+// includes removals by ExcludeOnHangup. Timers do not count. IsWatched is safe
+// for use during an Await.
+func (w *Watch) IsWatched(fd int) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	_, ok := w.fds[fd]
+	return ok
+}
+
+// Contains is an alias of IsWatched.
+func (w *Watch) Contains(fd int) bool {
+	return w.IsWatched(fd)
+}
+
+// IncludeTimer is an alias of AddTimer. The identifier returned is a timerfd(2)
+// on Linux, which allows for sub-millisecond intervals. Use RemoveTimer to
+// release the timer.
+func (w *Watch) IncludeTimer(d time.Duration, periodic bool) (id int, err error) {
+	return w.AddTimer(d, periodic)
+}
+
+// FDs returns the file descriptors on the watch list in ascending order. Timers
+// do not count. The kernel has no means to list its registrations, so the list
+// is bookkeeping from the Watch, which includes any file descriptors closed
+// without ExcludeFD. FDs is safe for use during an Await.
+func (w *Watch) FDs() []int {
+	w.mutex.Lock()
+	fds := make([]int, 0, len(w.fds))
+	for fd := range w.fds {
+		fds = append(fds, fd)
+	}
+	w.mutex.Unlock()
+	sort.Ints(fds)
+	return fds
+}
+
+// IncludeFDer adds the file descriptor of v to the watch list, like IncludeFD
+// does, for types such as os.File. The caller must keep v alive while on the
+// watch list, as a finalizer, such as the one of os.File, may close the file
+// descriptor otherwise. Note that os.File puts the file in blocking mode on Fd.
+func (w *Watch) IncludeFDer(v interface{ Fd() uintptr }) error {
+	return w.IncludeFD(int(v.Fd()))
+}
+
+// ExcludeFDer removes the file descriptor of v from the watch list, like
+// ExcludeFD does. Absence is ignored silently.
+func (w *Watch) ExcludeFDer(v interface{ Fd() uintptr }) error {
+	return w.ExcludeFD(int(v.Fd()))
+}
+
+// ExcludeFDStrict is like ExcludeFD, yet absence from the watch list gets
+// ErrNotWatched instead of silence. The check and the removal are atomic, i.e.,
+// of multiple routines excluding the same file descriptor only one succeeds.
+func (w *Watch) ExcludeFDStrict(fd int) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	if _, ok := w.fds[fd]; !ok {
+		return ErrNotWatched
+	}
+	return w.exclude(fd)
+}
+
+// Drain discards events ready, without blocking, and it returns the number of
+// events discarded. Readiness is level-triggered, which means that files report
+// again for as long as their condition holds, such as unread data. Drain stops
+// after as many events as there are entries on the watch list for that reason.
+func (w *Watch) Drain() (n int, err error) {
+	w.mutex.Lock()
+	closed := w.closed
+	max := len(w.fds) + len(w.timers)
+	if w.deadlines.armed {
+		max-- // internal timer
+	}
+	w.mutex.Unlock()
+	if closed {
+		return 0, ErrClosed
+	}
+
+	for n < max {
+		_, _, err := w.AwaitFD(0)
+		switch err {
+		case nil:
+			n++
+		case ErrTimeout, ErrWoken:
+			return n, nil
+		default:
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// SetNonBlock puts the file descriptor in non-blocking mode, with O_NONBLOCK. A
+// read without data available then gets syscall.EAGAIN instead of a wait. Event
+// loops which read until nothing is left depend on it, as a blocking read would
+// stall the loop. Watch does not alter file descriptors, which leaves the mode
+// to the caller. SetNonBlock is merely a convenience.
+func SetNonBlock(fd int) error {
+	err := syscall.SetNonblock(fd, true)
+	if err != nil {
+		if err == syscall.EBADF {
+			return ErrBadFD
+		}
+		return fmt.Errorf("SetNonBlock lost on fcntl(2) error %w", err)
+	}
+	return nil
+}
+
+// SocketError takes the error pending on a socket, with SO_ERROR, such as the
+// cause of an Error condition from Await. The return is nil when no error is
+// pending, or a syscall.Errno otherwise, e.g., syscall.ECONNRESET for a peer
+// which reset the connection. Reading clears the error.
+func SocketError(fd int) error {
+	errno, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_ERROR)
+	if err != nil {
+		if err == syscall.EBADF {
+			return ErrBadFD
+		}
+		return fmt.Errorf("SocketError lost on getsockopt(2) error %w", err)
+	}
+	if errno == 0 {
+		return nil
+	}
+	return syscall.Errno(errno)
+}
+
+// Describe formats the state of a Watch for String.
+func describe(facility string, kernelFD int, closed bool, wakeFDs []int, fds map[int]Interest, timers map[int]struct{}) string {
+	buf := make([]byte, 0, 64+8*(len(fds)+len(timers)))
+	buf = append(buf, "Watch with "...)
+	buf = append(buf, facility...)
+	if closed {
+		return string(append(buf, " closed"...))
+	}
+	buf = append(buf, " on FD "...)
+	buf = strconv.AppendInt(buf, int64(kernelFD), 10)
+	buf = append(buf, ", wakeup on FD"...)
+	for _, fd := range wakeFDs {
+		buf = append(buf, ' ')
+		buf = strconv.AppendInt(buf, int64(fd), 10)
+	}
+
+	list := make([]int, 0, len(fds)+len(timers))
+	for fd := range fds {
+		list = append(list, fd)
+	}
+	sort.Ints(list)
+	buf = append(buf, ", file count "...)
+	buf = strconv.AppendInt(buf, int64(len(list)), 10)
+	buf = append(buf, " ["...)
+	for i, fd := range list {
+		if i != 0 {
+			buf = append(buf, ' ')
+		}
+		buf = strconv.AppendInt(buf, int64(fd), 10)
+	}
+
+	list = list[:0]
+	for id := range timers {
+		list = append(list, id)
+	}
+	sort.Ints(list)
+	buf = append(buf, "], timer count "...)
+	buf = strconv.AppendInt(buf, int64(len(list)), 10)
+	buf = append(buf, " ["...)
+	for i, id := range list {
+		if i != 0 {
+			buf = append(buf, ' ')
+		}
+		buf = strconv.AppendInt(buf, int64(id), 10)
+	}
+	buf = append(buf, ']')
+	return string(buf)
+}
@@ -1,14 +1,24 @@
+//go:build linux || darwin || netbsd || freebsd || openbsd || dragonfly
+
 package fdmom
 
 import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
 	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 )
 
-// Leeway for no delay expectations.
-const holdupMax = 100 * time.Millisecond
-
 // Pipe contains a test setup. Shutdown is taken care of.
 type pipe struct {
 	// test subject
@@ -74,160 +84,3269 @@ func TestWatchTimeoutRecover(t *testing.T) {
 	}
 }
 
-func TestWatchPendingWrite(t *testing.T) {
+// Signals may not extend the timeout.
+func TestWatchWrite(t *testing.T) {
 	p := newPipe(t)
 
-	// before inclusion on watch list
-	_, err := p.w.WriteString("Hello")
+	wFD := int(p.w.Fd())
+	err := p.Watch.IncludeFDForWrite(wFD)
 	if err != nil {
-		t.Fatal("test data lost:", err)
+		t.Fatal(err)
+	}
+	got, err := p.Watch.AwaitEvent(holdupMax)
+	if err != nil || got.FD != wFD || got.Ready&Write == 0 {
+		t.Errorf("got event %+v with error %v, want FD %#x with Write",
+			got, err, wFD)
+	}
+}
+
+func TestAwaitFDWithWrite(t *testing.T) {
+	p := newPipe(t)
+
+	wFD := int(p.w.Fd())
+	got, err := p.Watch.AwaitFDWithWrite(0)
+	if err != ErrTimeout {
+		t.Errorf("got FD %#x with error %v before include, want ErrTimeout", got, err)
+	}
+
+	err = p.Watch.IncludeFDForWrite(wFD)
+	if err != nil {
+		t.Fatal(err)
 	}
+	// read end ready too
 	err = p.Watch.IncludeFD(p.rFD)
 	if err != nil {
 		t.Fatal(err)
 	}
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	for i := 0; i < 3; i++ {
+		got, err = p.Watch.AwaitFDWithWrite(holdupMax)
+		if err != nil || got != wFD {
+			t.Errorf("got FD %#x with error %v, want write end %#x", got, err, wFD)
+		}
+	}
+}
 
-	// should work on all timeout options, and repeatedly
-	got, err := p.Watch.AwaitFDWithRead(0)
-	if err != nil || got != p.rFD {
-		t.Errorf("non-blocking got FD %#x with error %v, want FD %#x",
-			got, err, p.rFD)
+func TestAwaitFDWithWriteTimeout(t *testing.T) {
+	p := newPipe(t)
+	wFD := int(p.w.Fd())
+	err := syscall.SetNonblock(wFD, true)
+	if err != nil {
+		t.Fatal(err)
 	}
-	got, err = p.Watch.AwaitFDWithRead(time.Millisecond)
-	if err != nil || got != p.rFD {
-		t.Errorf("await 1 ms got FD %#x with error %v, want FD %#x",
-			got, err, p.rFD)
+	// fill the pipe buffer, which leaves the read end ready
+	buf := make([]byte, 4096)
+	for {
+		_, err := syscall.Write(wFD, buf)
+		if err == syscall.EAGAIN {
+			break
+		}
+		if err != nil {
+			t.Fatal("pipe fill:", err)
+		}
 	}
-	got, err = p.Watch.AwaitFDWithRead(-1)
-	if err != nil || got != p.rFD {
-		t.Errorf("indefinite wait got FD %#x with error %v, want FD %#x",
-			got, err, p.rFD)
+	for _, fd := range []int{p.rFD, wFD} {
+		err := p.Watch.IncludeFDInterest(fd, Read|Write)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, timeout := range []time.Duration{0, 20 * time.Millisecond} {
+		start := time.Now()
+		got, err := p.Watch.AwaitFDWithWrite(timeout)
+		if err != ErrTimeout {
+			t.Errorf("got FD %#x with error %v on full pipe, want ErrTimeout", got, err)
+		}
+		if d := time.Since(start); d > timeout+holdupMax {
+			t.Errorf("timeout %s took %s", timeout, d)
+		}
+	}
+
+	err = p.Watch.Wakeup()
+	if err != nil {
+		t.Fatal("wakeup error:", err)
+	}
+	got, err := p.Watch.AwaitFDWithWrite(holdupMax)
+	if err != ErrWoken {
+		t.Errorf("got FD %#x with error %v after Wakeup, want ErrWoken", got, err)
 	}
 }
 
-func TestWatchIncludeDupe(t *testing.T) {
+func TestAwaitFDs(t *testing.T) {
 	p := newPipe(t)
 
-	fd := p.rFD
-	err := p.Watch.IncludeFD(fd)
-	if err != nil {
-		t.Fatal("error on initial inclusion:", err)
+	var buf [8]int
+	n, err := p.Watch.AwaitFDs(buf[:], 0)
+	if err != ErrTimeout {
+		t.Errorf("got %d file descriptors with error %v, want ErrTimeout", n, err)
 	}
-	err = p.Watch.IncludeFD(fd)
+
+	want := make(map[int]bool)
+	for i := 0; i < 3; i++ {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		defer w.Close()
+		_, err = w.WriteString("Hello")
+		if err != nil {
+			t.Fatal("test data lost:", err)
+		}
+		err = p.Watch.IncludeFD(int(r.Fd()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[int(r.Fd())] = true
+	}
+
+	n, err = p.Watch.AwaitFDs(buf[:], holdupMax)
 	if err != nil {
-		t.Fatal("error on redundant inclusion:", err)
+		t.Fatal(err)
+	}
+	if n != len(want) {
+		t.Errorf("got %d file descriptors %v, want %d", n, buf[:n], len(want))
+	}
+	for _, fd := range buf[:n] {
+		if !want[fd] {
+			t.Errorf("got file descriptor %#x, want any of %v", fd, want)
+		}
+	}
+
+	n, err = p.Watch.AwaitFDs(buf[:2], holdupMax)
+	if err != nil || n != 2 {
+		t.Errorf("got %d file descriptors with error %v, want buffer of 2 full", n, err)
+	}
+}
+
+func TestAwaitEvents(t *testing.T) {
+	p := newPipe(t)
+
+	var buf [8]Event
+	n, err := p.Watch.AwaitEvents(buf[:0], 0)
+	if err != ErrEmptyBuffer {
+		t.Errorf("got %d events with error %v on an empty buffer, want ErrEmptyBuffer", n, err)
+	}
+	n, err = p.Watch.AwaitEvents(buf[:], 0)
+	if err != ErrTimeout {
+		t.Errorf("got %d events with error %v, want ErrTimeout", n, err)
 	}
 
+	wFD := int(p.w.Fd())
+	err = p.Watch.IncludeFDToken(p.rFD, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Watch.IncludeFDForWrite(wFD)
+	if err != nil {
+		t.Fatal(err)
+	}
 	_, err = p.w.WriteString("Hello")
 	if err != nil {
 		t.Fatal("test data lost:", err)
 	}
+	err = p.Watch.Post(99)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	got, err := p.Watch.AwaitFDWithRead(-1)
-	if err != nil || got != fd {
-		t.Errorf("got FD %#x and error %v, want FD %d",
-			got, err, fd)
+	n, err = p.Watch.AwaitEvents(buf[:], holdupMax)
+	if err != nil || n != 1 || buf[0] != (Event{FD: -1, Token: 99, Posted: true, Available: -1}) {
+		t.Errorf("got events %+v with error %v, want the post only", buf[:n], err)
+	}
+
+	got := make(map[int]Event)
+	for len(got) < 2 {
+		n, err = p.Watch.AwaitEvents(buf[:], holdupMax)
+		if err == ErrWoken {
+			continue // from Post
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range buf[:n] {
+			got[e.FD] = e
+		}
+	}
+	if e := got[p.rFD]; e.Ready&Read == 0 || e.Token != 42 || e.Available != len("Hello") {
+		t.Errorf("got read end event %+v, want Read with token 42 and 5 bytes available", e)
+	}
+	if e := got[wFD]; e.Ready&Write == 0 || e.Token != 0 || e.Available != -1 {
+		t.Errorf("got write end event %+v, want Write without token nor bytes available", e)
 	}
 }
 
-func TestWatchExcludeDupe(t *testing.T) {
+func TestAwaitEventAvailable(t *testing.T) {
 	p := newPipe(t)
 
-	fd := p.rFD
-	err := p.Watch.IncludeFD(fd)
+	err := p.Watch.IncludeFD(p.rFD)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = p.Watch.ExcludeFD(fd)
+	_, err = p.w.WriteString("Hello")
 	if err != nil {
-		t.Fatal("error on initial exclusion:", err)
+		t.Fatal("test data lost:", err)
 	}
-	err = p.Watch.ExcludeFD(fd)
+	e, err := p.Watch.AwaitEvent(holdupMax)
 	if err != nil {
-		t.Fatal("error on redundant exclusion:", err)
+		t.Fatal(err)
+	}
+	if e.FD != p.rFD || e.Available != len("Hello") {
+		t.Errorf("got event %+v, want read end with 5 bytes available", e)
+	}
+
+	// end of file has no bytes available
+	var buf [8]byte
+	if n, err := p.r.Read(buf[:]); err != nil || n != len("Hello") {
+		t.Fatalf("read got %d bytes with error %v, want the test data", n, err)
+	}
+	p.w.Close()
+	e, err = p.Watch.AwaitEvent(holdupMax)
+	if err != nil {
+		t.Fatal(err)
 	}
+	if e.FD != p.rFD || e.Available != 0 {
+		t.Errorf("got event %+v after close of the write end, want read end with none available", e)
+	}
+}
 
+func TestWatchRankOrder(t *testing.T) {
+	p := newPipe(t, WithOrdering(RankOrder))
+
+	files := map[int]*os.File{p.rFD: p.r}
+	err := p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
 	_, err = p.w.WriteString("Hello")
 	if err != nil {
 		t.Fatal("test data lost:", err)
 	}
+	want := []int{0, p.rFD, 0}
+	for i, rank := range []int{5, -1} {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		defer w.Close()
+		fd := int(r.Fd())
+		files[fd] = r
+		err = p.Watch.IncludeFDRank(fd, rank)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = w.WriteString("Hello")
+		if err != nil {
+			t.Fatal("test data lost:", err)
+		}
+		want[i*2] = fd
+	}
 
-	got, err := p.Watch.AwaitFDWithRead(50 * time.Millisecond)
-	if err != ErrTimeout {
-		t.Errorf("got FD %#x with error %v, want ErrTimeout",
-			got, err)
+	for i, wantFD := range want {
+		fd, err := p.Watch.AwaitFDWithRead(holdupMax)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fd != wantFD {
+			t.Errorf("await %d got file descriptor %d, want %d", i+1, fd, wantFD)
+		}
+		// consume the data for level-triggered
+		var buf [8]byte
+		files[fd].Read(buf[:])
 	}
 }
 
-func TestClosed(t *testing.T) {
-	w, err := OpenWatch()
+func TestAwaitEventsRank(t *testing.T) {
+	p := newPipe(t)
+
+	err := p.Watch.IncludeFD(p.rFD)
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer w.Close()
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	want := []int{0, p.rFD, 0}
+	for i, rank := range []int{9, -3} {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		defer w.Close()
+		fd := int(r.Fd())
+		err = p.Watch.IncludeFDRank(fd, rank)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = w.WriteString("Hello")
+		if err != nil {
+			t.Fatal("test data lost:", err)
+		}
+		want[i*2] = fd
+	}
 
-	time.Sleep(50 * time.Millisecond)
-	err = w.Close()
+	// highest rank beyond capacity
+	var fds [1]int
+	n, err := p.Watch.AwaitFDs(fds[:], holdupMax)
 	if err != nil {
-		t.Error("Close got error:", err)
+		t.Fatal(err)
+	}
+	if n != 1 || fds[0] != want[0] {
+		t.Errorf("got file descriptors %d, want [%d]", fds[:n], want[0])
 	}
 
-	_, err = w.AwaitFDWithRead(0)
-	if err != ErrClosed {
-		t.Errorf("await got error %v, want ErrClosed", err)
+	var buf [8]Event
+	n, err = p.Watch.AwaitEvents(buf[:], holdupMax)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int
+	for _, e := range buf[:n] {
+		got = append(got, e.FD)
+	}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("got events for file descriptors %d, want %d", got, want)
 	}
+}
 
-	stdin := int(os.Stdin.Fd())
-	err = w.IncludeFD(stdin)
-	if err != ErrClosed {
-		t.Errorf("include got error %v, want ErrClosed", err)
+func TestWatchReadyOrder(t *testing.T) {
+	p := newPipe(t, WithOrdering(ReadyOrder))
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
 	}
-	err = w.ExcludeFD(stdin)
-	if err != ErrClosed {
-		t.Errorf("exclude got error %v, want ErrClosed", err)
+	defer r.Close()
+	defer w.Close()
+	fds := []int{p.rFD, int(r.Fd())}
+	for _, fd := range fds {
+		err := p.Watch.IncludeFD(fd)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []*os.File{p.w, w} {
+		_, err := f.WriteString("Hello")
+		if err != nil {
+			t.Fatal("test data lost:", err)
+		}
+	}
+
+	// file descriptors which remain ready get in line again
+	counts := make(map[int]int)
+	for i := 0; i < 4; i++ {
+		fd, err := p.Watch.AwaitFDWithRead(holdupMax)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[fd]++
+	}
+	for _, fd := range fds {
+		if counts[fd] != 2 {
+			t.Errorf("got file descriptor counts %v, want 2 for each of %v", counts, fds)
+			break
+		}
 	}
 }
 
-func newPipe(t *testing.T) pipe {
-	t.Parallel()
-	const testTimeout = 2 * time.Second
+func TestWatchEventBatch(t *testing.T) {
+	p := newPipe(t, EventBatch(8))
 
-	w, err := OpenWatch()
+	fds := []int{p.rFD}
+	_, err := p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	for i := 0; i < 2; i++ {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		defer w.Close()
+		_, err = w.WriteString("Hello")
+		if err != nil {
+			t.Fatal("test data lost:", err)
+		}
+		fds = append(fds, int(r.Fd()))
+	}
+	for _, fd := range fds {
+		err := p.Watch.IncludeFD(fd)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// one read from the kernel, and two from the batch
+	seen := make(map[int]bool)
+	for range fds {
+		fd, err := p.Watch.AwaitFDWithRead(holdupMax)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[fd] = true
+	}
+	if len(seen) != len(fds) {
+		t.Errorf("got file descriptors %v, want each of %v", seen, fds)
+	}
+
+	// batch keeps file descriptors which leave the watch list
+	_, err = p.Watch.AwaitFDWithRead(holdupMax)
 	if err != nil {
 		t.Fatal(err)
 	}
-	// close watch on test timeout or test completion
-	timeout := time.AfterFunc(testTimeout, func() {
-		t.Error("closing watch on test timeout")
-		err := w.Close()
+	for _, fd := range fds {
+		err := p.Watch.ExcludeFD(fd)
 		if err != nil {
-			t.Error(err)
+			t.Fatal(err)
 		}
-	})
-	t.Cleanup(func() {
-		if timeout.Stop() {
-			err := w.Close()
-			if err != nil {
-				t.Error(err)
-			}
+	}
+	got, err := p.Watch.AwaitFDWithRead(0)
+	if err != ErrTimeout {
+		t.Errorf("got file descriptor %#x with error %v after exclusion, want ErrTimeout", got, err)
+	}
+}
+
+func TestWatchWakeup(t *testing.T) {
+	p := newPipe(t)
+
+	// pending wakeups coalesce
+	for i := 0; i < 2; i++ {
+		err := p.Watch.Wakeup()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	fd, err := p.Watch.AwaitFDWithRead(0)
+	if err != ErrWoken {
+		t.Errorf("got FD %#x with error %v after Wakeup, want ErrWoken", fd, err)
+	}
+	fd, err = p.Watch.AwaitFDWithRead(0)
+	if err != ErrTimeout {
+		t.Errorf("got FD %#x with error %v after ErrWoken, want ErrTimeout", fd, err)
+	}
+
+	time.AfterFunc(10*time.Millisecond, func() {
+		err := p.Watch.Wakeup()
+		if err != nil {
+			t.Error(err)
 		}
 	})
+	fd, err = p.Watch.AwaitFDWithRead(-1)
+	if err != ErrWoken {
+		t.Errorf("got FD %#x with error %v during Wakeup, want ErrWoken", fd, err)
+	}
 
-	p := pipe{Watch: w}
-	p.r, p.w, err = os.Pipe()
+	p.Watch.Close()
+	err = p.Watch.Wakeup()
+	if err != ErrClosed {
+		t.Errorf("Wakeup after Close got error %v, want ErrClosed", err)
+	}
+}
+
+func TestWatchEdgeTriggered(t *testing.T) {
+	p := newPipe(t, EdgeTriggered())
+	err := p.Watch.IncludeFD(p.rFD)
 	if err != nil {
 		t.Fatal(err)
 	}
-	t.Cleanup(func() {
-		p.r.Close()
-		p.w.Close()
-	})
-	// timeout pipe I/O too
-	testDeadline := time.Now().Add(testTimeout)
-	for _, f := range []*os.File{p.r, p.w} {
-		f.SetDeadline(testDeadline)
+
+	for i := 0; i < 2; i++ {
+		_, err = p.w.WriteString("Hello")
+		if err != nil {
+			t.Fatal("test data lost:", err)
+		}
+		fd, err := p.Watch.AwaitFDWithRead(holdupMax)
+		if err != nil || fd != p.rFD {
+			t.Fatalf("got FD %#x with error %v after write %d, want FD %#x",
+				fd, err, i+1, p.rFD)
+		}
+		// data remains unread
+		fd, err = p.Watch.AwaitFDWithRead(0)
+		if err != ErrTimeout {
+			t.Errorf("got FD %#x with error %v without change, want ErrTimeout",
+				fd, err)
+		}
 	}
 
-	p.rFD = int(p.r.Fd())
+	// each once when ready at the same time
+	want := make(map[int]bool)
+	for i := 0; i < 3; i++ {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		defer w.Close()
+		err = p.Watch.IncludeFD(int(r.Fd()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = w.WriteString("Hello")
+		if err != nil {
+			t.Fatal("test data lost:", err)
+		}
+		want[int(r.Fd())] = true
+	}
+	for len(want) != 0 {
+		fd, err := p.Watch.AwaitFDWithRead(holdupMax)
+		if err != nil {
+			t.Fatalf("got error %v with %d pipes pending", err, len(want))
+		}
+		if !want[fd] {
+			t.Fatalf("got FD %#x, want any of %v", fd, want)
+		}
+		delete(want, fd)
+	}
+	fd, err := p.Watch.AwaitFDWithRead(0)
+	if err != ErrTimeout {
+		t.Errorf("got FD %#x with error %v after all reported, want ErrTimeout",
+			fd, err)
+	}
+}
+
+func TestWatchTriggerModes(t *testing.T) {
+	p := newPipe(t)
+	err := p.Watch.IncludeFDInterest(p.rFD, Read|Edge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	levelFD := int(r.Fd())
+	err = p.Watch.IncludeFD(levelFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range []*os.File{p.w, w} {
+		_, err = f.WriteString("Hello")
+		if err != nil {
+			t.Fatal("test data lost:", err)
+		}
+	}
+	seen := make(map[int]int)
+	for {
+		fd, err := p.Watch.AwaitFDWithRead(10 * time.Millisecond)
+		if err == ErrTimeout {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[fd]++
+		if seen[levelFD] > 2 {
+			break // data remains unread
+		}
+	}
+	if seen[p.rFD] != 1 {
+		t.Errorf("got edge-triggered FD %d times, want once", seen[p.rFD])
+	}
+	if seen[levelFD] <= 2 {
+		t.Errorf("got level-triggered FD %d times, want more than twice", seen[levelFD])
+	}
+
+	// switch to level-triggered with data still unread
+	err = p.Watch.ModifyFD(p.rFD, Read|Level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Watch.ExcludeFD(levelFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		fd, err := p.Watch.AwaitFDWithRead(holdupMax)
+		if err != nil || fd != p.rFD {
+			t.Errorf("got FD %#x with error %v after mode change, want FD %#x", fd, err, p.rFD)
+		}
+	}
+}
+
+func TestWatchOneShot(t *testing.T) {
+	p := newPipe(t, OneShot())
+	err := p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		fd, err := p.Watch.AwaitFDWithRead(holdupMax)
+		if err != nil || fd != p.rFD {
+			t.Fatalf("got FD %#x with error %v, want FD %#x", fd, err, p.rFD)
+		}
+		fd, err = p.Watch.AwaitFDWithRead(0)
+		if err != ErrTimeout {
+			t.Errorf("got FD %#x with error %v while disarmed, want ErrTimeout",
+				fd, err)
+		}
+		if !p.Watch.IsWatched(p.rFD) {
+			t.Error("disarmed file descriptor left the watch list")
+		}
+
+		err = p.Watch.RearmFD(p.rFD)
+		if err != nil {
+			t.Fatal("rearm error:", err)
+		}
+	}
+
+	err = p.Watch.RearmFD(int(p.w.Fd()))
+	if err != ErrNotWatched {
+		t.Errorf("rearm of write end got error %v, want ErrNotWatched", err)
+	}
+}
+
+func TestWatchToken(t *testing.T) {
+	p := newPipe(t)
+	err := p.Watch.IncludeFDToken(p.rFD, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	got, err := p.Watch.AwaitEvent(holdupMax)
+	if err != nil || got.FD != p.rFD || got.Token != 42 {
+		t.Errorf("got event %+v with error %v, want FD %#x with token 42",
+			got, err, p.rFD)
+	}
+
+	// token leaves with the file descriptor
+	err = p.Watch.ExcludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = p.Watch.AwaitEvent(holdupMax)
+	if err != nil || got.FD != p.rFD || got.Token != 0 {
+		t.Errorf("got event %+v with error %v after exclude, want FD %#x without token",
+			got, err, p.rFD)
+	}
+}
+
+func TestWatchConnReset(t *testing.T) {
+	p := newPipe(t)
+	client, server := newTCPFiles(t)
+	fd := int(client.Fd())
+
+	err := p.Watch.IncludeFD(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SocketError(fd); err != nil {
+		t.Errorf("got socket error %v before reset, want none", err)
+	}
+
+	// close with RST
+	err = syscall.SetsockoptLinger(int(server.Fd()), syscall.SOL_SOCKET, syscall.SO_LINGER, &syscall.Linger{Onoff: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	got, err := p.Watch.AwaitEvent(holdupMax)
+	if err != nil || got.FD != fd || got.Ready&Hangup == 0 {
+		t.Fatalf("got event %+v with error %v, want FD %#x with Hangup",
+			got, err, fd)
+	}
+	if got.Ready&Error == 0 {
+		t.Errorf("got event %+v, want Error", got)
+	}
+	if err := SocketError(fd); err != syscall.ECONNRESET {
+		t.Errorf("got socket error %v, want ECONNRESET", err)
+	}
+	if err := SocketError(math.MaxInt32); err != ErrBadFD {
+		t.Errorf("got socket error %v for bad file descriptor, want ErrBadFD", err)
+	}
+}
+
+func TestWatchIncludeConn(t *testing.T) {
+	p := newPipe(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	fd, err := p.Watch.IncludeConn(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Write([]byte("Hello"))
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	got, err := p.Watch.AwaitFDWithRead(holdupMax)
+	if err != nil || got != fd {
+		t.Fatalf("got FD %#x with error %v, want FD %#x", got, err, fd)
+	}
+	var buf [8]byte
+	n, err := server.Read(buf[:])
+	if err != nil || string(buf[:n]) != "Hello" {
+		t.Errorf("connection read got %q with error %v, want Hello", buf[:n], err)
+	}
+
+	err = p.Watch.ExcludeConn(server)
+	if err != nil {
+		t.Fatal("exclude error:", err)
+	}
+	if p.Watch.IsWatched(fd) {
+		t.Error("connection still on watch list after ExcludeConn")
+	}
+	server.Close()
+	_, err = p.Watch.IncludeConn(server)
+	if err == nil {
+		t.Error("include of closed connection got no error")
+	}
+}
+
+func TestSupervisor(t *testing.T) {
+	p := newPipe(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	s := NewSupervisor(p.Watch)
+	conn, err := s.Register(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Write([]byte("Hello"))
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	got, event, err := s.Await(holdupMax)
+	if err != nil || got != conn || event.Ready&Read == 0 {
+		t.Fatalf("got connection %v, event %+v, error %v; want %v ready Read",
+			got, event, err, conn)
+	}
+
+	err = p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	err = conn.Close()
+	if err != nil {
+		t.Fatal("close error:", err)
+	}
+	if n := s.Len(); n != 0 {
+		t.Errorf("got %d connections after close, want 0", n)
+	}
+	_, _, err = s.Await(holdupMax)
+	var fdErr *FDError
+	if !errors.As(err, &fdErr) || fdErr.FD != p.rFD {
+		t.Errorf("got error %v for pipe, want an *FDError for FD %#x", err, p.rFD)
+	}
+}
+
+func TestWatchAwaitAccept(t *testing.T) {
+	p := newPipe(t)
+	var listeners [3]net.Listener
+	for i := range listeners {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		_, err = p.Watch.IncludeListener(l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners[i] = l
+	}
+	if l, err := p.Watch.AwaitAccept(0); err != ErrTimeout {
+		t.Fatalf("got listener %v with error %v while idle, want ErrTimeout", l, err)
+	}
+
+	busy := listeners[1]
+	conn, err := net.Dial("tcp", busy.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	got, err := p.Watch.AwaitAccept(holdupMax)
+	if err != nil || got != busy {
+		t.Fatalf("got listener %v with error %v, want %v", got, err, busy)
+	}
+	// the listener reported leaves the watch list
+	if n := len(p.Watch.FDs()); n != len(listeners)-1 {
+		t.Errorf("got %d file descriptors on watch list, want %d", n, len(listeners)-1)
+	}
+	accepted, err := got.Accept()
+	if err != nil {
+		t.Fatal("accept error:", err)
+	}
+	accepted.Close()
+	if l, err := p.Watch.AwaitAccept(0); err != ErrTimeout {
+		t.Errorf("got listener %v with error %v after accept, want ErrTimeout", l, err)
+	}
+
+	err = p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	_, err = p.Watch.AwaitAccept(holdupMax)
+	var fdErr *FDError
+	if !errors.As(err, &fdErr) || fdErr.FD != p.rFD {
+		t.Errorf("got error %v for pipe, want an *FDError for FD %#x", err, p.rFD)
+	}
+}
+
+func TestListener(t *testing.T) {
+	p := newPipe(t)
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewListener(tcp)
+	if err != nil {
+		tcp.Close()
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	start := time.Now()
+	conn, err := l.AcceptTimeout(10 * time.Millisecond)
+	if err != ErrTimeout {
+		t.Fatalf("got connection %v with error %v while idle, want ErrTimeout", conn, err)
+	}
+	if waited := time.Since(start); waited < 10*time.Millisecond {
+		t.Errorf("timeout after %s, want at least 10ms", waited)
+	}
+
+	dial := func() {
+		conn, err := net.Dial("tcp", tcp.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+	}
+	dial()
+	conn, err = l.AcceptTimeout(holdupMax)
+	if err != nil {
+		t.Fatal("accept error:", err)
+	}
+	conn.Close()
+
+	err = l.Park(p.Watch)
+	if err != nil {
+		t.Fatal("park error:", err)
+	}
+	if got, err := p.Watch.AwaitAccept(0); err != ErrTimeout {
+		t.Fatalf("got listener %v with error %v while parked idle, want ErrTimeout", got, err)
+	}
+	dial()
+	got, err := p.Watch.AwaitAccept(holdupMax)
+	if err != nil || got != l {
+		t.Fatalf("got listener %v with error %v, want the parked one", got, err)
+	}
+	conn, err = got.Accept()
+	if err != nil {
+		t.Fatal("accept error:", err)
+	}
+	conn.Close()
+
+	done := make(chan error)
+	go func() {
+		_, err := l.Accept()
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	err = l.Close()
+	if err != nil {
+		t.Error("close error:", err)
+	}
+	select {
+	case err := <-done:
+		if err != ErrClosed {
+			t.Errorf("accept in progress got error %v on close, want ErrClosed", err)
+		}
+	case <-time.After(holdupMax):
+		t.Error("accept in progress not interrupted by close")
+	}
+}
+
+func TestWatchDeadline(t *testing.T) {
+	p := newPipe(t)
+	const delay = 20 * time.Millisecond
+	err := p.Watch.IncludeFDWithDeadline(p.rFD, time.Now().Add(delay))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fd, err := p.Watch.AwaitFDWithRead(delay + holdupMax)
+	var fdErr *FDError
+	if !errors.As(err, &fdErr) || fdErr.FD != p.rFD || !errors.Is(err, ErrTimeout) {
+		t.Fatalf("got FD %#x with error %v, want an *FDError with ErrTimeout for FD %#x",
+			fd, err, p.rFD)
+	}
+	// expiries apply once only
+	fd, err = p.Watch.AwaitFDWithRead(2 * delay)
+	if err != ErrTimeout {
+		t.Errorf("got FD %#x with error %v after expiry, want ErrTimeout", fd, err)
+	}
+
+	err = p.Watch.SetFDDeadline(p.rFD, time.Now().Add(delay))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Watch.SetFDDeadline(p.rFD, time.Time{})
+	if err != nil {
+		t.Fatal("deadline removal:", err)
+	}
+	fd, err = p.Watch.AwaitFDWithRead(2 * delay)
+	if err != ErrTimeout {
+		t.Errorf("got FD %#x with error %v after deadline removal, want ErrTimeout",
+			fd, err)
+	}
+
+	err = p.Watch.SetFDDeadline(p.rFD, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	fd, err = p.Watch.AwaitFDWithRead(holdupMax)
+	if err != nil || fd != p.rFD {
+		t.Errorf("got FD %#x with error %v before deadline, want FD %#x",
+			fd, err, p.rFD)
+	}
+
+	err = p.Watch.SetFDDeadline(math.MaxInt32, time.Now())
+	if err != ErrNotWatched {
+		t.Errorf("got error %v for bad file descriptor, want ErrNotWatched", err)
+	}
+}
+
+// Readiness pushes the deadline back, such that silence expires only.
+func TestWatchDeadlineReady(t *testing.T) {
+	p := newPipe(t)
+	const delay = 40 * time.Millisecond
+	err := p.Watch.IncludeFDWithDeadline(p.rFD, time.Now().Add(delay))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+
+	var lastReady time.Time
+	for i := 0; i < 4; i++ {
+		time.Sleep(delay / 2)
+		fd, err := p.Watch.AwaitFDWithRead(holdupMax)
+		if err != nil || fd != p.rFD {
+			t.Fatalf("await #%d got FD %#x with error %v, want FD %#x",
+				i+1, fd, err, p.rFD)
+		}
+		lastReady = time.Now()
+	}
+
+	var buf [5]byte
+	if _, err := syscall.Read(p.rFD, buf[:]); err != nil {
+		t.Fatal("read error:", err)
+	}
+	fd, err := p.Watch.AwaitFDWithRead(delay + holdupMax)
+	var fdErr *FDError
+	if !errors.As(err, &fdErr) || fdErr.FD != p.rFD || !errors.Is(err, ErrTimeout) {
+		t.Fatalf("got FD %#x with error %v after silence, want an *FDError with ErrTimeout for FD %#x",
+			fd, err, p.rFD)
+	}
+	if d := time.Since(lastReady); d < delay {
+		t.Errorf("expired %s after the last readiness, want at least %s", d, delay)
+	}
+}
+
+func TestWatchDeadlineReset(t *testing.T) {
+	p := newPipe(t)
+	const delay = 20 * time.Millisecond
+	err := p.Watch.IncludeFDWithDeadline(p.rFD, time.Now().Add(delay))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// internal timer is not an event
+	if n, err := p.Watch.Drain(); n != 0 || err != nil {
+		t.Errorf("drain got %d with error %v, want 0 with nil", n, err)
+	}
+
+	err = p.Watch.Reset()
+	if err != nil {
+		t.Fatal("reset error:", err)
+	}
+	err = p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, err := p.Watch.AwaitFDWithRead(delay + holdupMax)
+	if err != ErrTimeout {
+		t.Errorf("got FD %#x with error %v after reset, want ErrTimeout", fd, err)
+	}
+
+	// deadlines arm again after reset
+	err = p.Watch.SetFDDeadline(p.rFD, time.Now().Add(delay))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, err = p.Watch.AwaitFDWithRead(delay + holdupMax)
+	var fdErr *FDError
+	if !errors.As(err, &fdErr) || fdErr.FD != p.rFD || !errors.Is(err, ErrTimeout) {
+		t.Errorf("got FD %#x with error %v, want an *FDError with ErrTimeout for FD %#x",
+			fd, err, p.rFD)
+	}
+}
+
+func TestIdleReaper(t *testing.T) {
+	p := newPipe(t)
+	const idle = 50 * time.Millisecond
+	r := NewIdleReaper(p.Watch, idle)
+	err := r.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(idle / 2)
+	_, err = p.w.WriteString("x")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	fd, err := r.AwaitFDWithRead(holdupMax)
+	if err != nil || fd != p.rFD {
+		t.Fatalf("got FD %#x with error %v, want FD %#x", fd, err, p.rFD)
+	}
+	activity := time.Now()
+	var buf [1]byte
+	_, err = p.r.Read(buf[:])
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+
+	_, err = r.AwaitFDWithRead(idle + holdupMax)
+	var fdErr *FDError
+	if !errors.As(err, &fdErr) || fdErr.FD != p.rFD || !errors.Is(err, ErrTimeout) {
+		t.Fatalf("got error %v, want an *FDError with ErrTimeout for FD %#x",
+			err, p.rFD)
+	}
+	if d := time.Since(activity); d < idle {
+		t.Errorf("reaped after %s of inactivity, want %s at least", d, idle)
+	}
+	if p.Watch.IsWatched(p.rFD) {
+		t.Error("reaped file descriptor still on watch list")
+	}
+	if last, ok := r.LastReady(p.rFD); ok {
+		t.Errorf("reaped file descriptor got last ready %s", last)
+	}
+}
+
+func TestWatchPath(t *testing.T) {
+	p := newPipe(t)
+	dir := t.TempDir()
+	fd, err := p.Watch.WatchPath(dir, PathCreate|PathDelete)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := p.Watch.AwaitFDWithRead(0); err != ErrTimeout {
+		t.Fatalf("got FD %#x with error %v without change, want ErrTimeout", got, err)
+	}
+
+	f, err := os.Create(dir + "/new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	got, err := p.Watch.AwaitFDWithRead(holdupMax)
+	if err != nil || got != fd {
+		t.Fatalf("got FD %#x with error %v after create, want FD %#x", got, err, fd)
+	}
+	ops, err := p.Watch.PathOps(fd)
+	if err != nil || ops&PathCreate == 0 {
+		t.Errorf("got %s with error %v after create, want Create", ops, err)
+	}
+
+	err = p.Watch.UnwatchPath(fd)
+	if err != nil {
+		t.Fatal("unwatch error:", err)
+	}
+	_, err = p.Watch.PathOps(fd)
+	var fdErr *FDError
+	if !errors.As(err, &fdErr) || fdErr.FD != fd {
+		t.Errorf("got error %v after unwatch, want an *FDError for FD %#x", err, fd)
+	}
+}
+
+func TestWatchProcess(t *testing.T) {
+	p := newPipe(t)
+	cmd := exec.Command("cat")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cmd.Start()
+	if err != nil {
+		t.Skip("no child process:", err)
+	}
+	defer cmd.Wait()
+	defer stdin.Close()
+
+	id, err := p.Watch.IncludeProcess(cmd.Process.Pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := p.Watch.AwaitFDWithRead(10 * time.Millisecond); err != ErrTimeout {
+		t.Fatalf("got FD %#x with error %v while running, want ErrTimeout", got, err)
+	}
+
+	// cat(1) exits on end of input
+	stdin.Close()
+	got, err := p.Watch.AwaitFDWithRead(holdupMax)
+	if err != nil || got != id {
+		t.Fatalf("got FD %#x with error %v on exit, want identifier %#x", got, err, id)
+	}
+	err = p.Watch.ExcludeProcess(id)
+	if err != nil {
+		t.Fatal("exclude error:", err)
+	}
+	if got, err := p.Watch.AwaitFDWithRead(0); err != ErrTimeout {
+		t.Errorf("got FD %#x with error %v after exclude, want ErrTimeout", got, err)
+	}
+}
+
+func TestWatchPost(t *testing.T) {
+	p := newPipe(t)
+	for token := uint64(1); token <= 2; token++ {
+		err := p.Watch.Post(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for want := uint64(1); want <= 2; want++ {
+		event, err := p.Watch.AwaitEvent(0)
+		if err != nil || !event.Posted || event.Token != want || event.FD != -1 {
+			t.Errorf("got event %+v with error %v, want posted token %d", event, err, want)
+		}
+	}
+	// wakeups from Post coalesce
+	if event, err := p.Watch.AwaitEvent(0); err != ErrWoken {
+		t.Errorf("got event %+v with error %v after posts taken, want ErrWoken", event, err)
+	}
+
+	done := make(chan Event)
+	go func() {
+		event, err := p.Watch.AwaitEvent(holdupMax)
+		if err != nil {
+			t.Error("await error:", err)
+		}
+		done <- event
+	}()
+	time.Sleep(10 * time.Millisecond)
+	err := p.Watch.Post(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event := <-done; !event.Posted || event.Token != 42 {
+		t.Errorf("got event %+v during await, want posted token 42", event)
+	}
+}
+
+func TestWatchReadWrite(t *testing.T) {
+	p := newPipe(t)
+	client, server := newTCPFiles(t)
+
+	fd := int(client.Fd())
+	err := p.Watch.IncludeFD(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Watch.IncludeFDForWrite(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = server.Write([]byte("Hello"))
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+
+	// both conditions in one event
+	deadline := time.Now().Add(holdupMax)
+	for {
+		got, err := p.Watch.AwaitEvent(holdupMax)
+		if err != nil || got.FD != fd {
+			t.Fatalf("got event %+v with error %v, want FD %#x", got, err, fd)
+		}
+		if got.Ready&(Read|Write) == Read|Write {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got conditions %#x, want Read and Write", got.Ready)
+		}
+	}
+}
+
+func TestWatchIncludeFDInterest(t *testing.T) {
+	p := newPipe(t)
+	client, _ := newTCPFiles(t)
+	fd := int(client.Fd())
+
+	err := p.Watch.IncludeFDInterest(fd, Read|Write)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.Watch.AwaitEvent(holdupMax)
+	if err != nil || got.FD != fd || got.Ready&Write == 0 {
+		t.Errorf("got event %+v with error %v, want FD %#x with Write",
+			got, err, fd)
+	}
+
+	// replace interest
+	err = p.Watch.IncludeFDInterest(fd, Read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = p.Watch.AwaitEvent(0)
+	if err != ErrTimeout {
+		t.Errorf("got event %+v with error %v after Write removal, want ErrTimeout",
+			got, err)
+	}
+
+	err = p.Watch.IncludeFDInterest(fd, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Watch.IsWatched(fd) {
+		t.Error("watched after zero interest")
+	}
+}
+
+func TestWatchModifyFD(t *testing.T) {
+	p := newPipe(t)
+	client, _ := newTCPFiles(t)
+	fd := int(client.Fd())
+
+	err := p.Watch.ModifyFD(fd, Write)
+	if err != ErrNotWatched {
+		t.Errorf("modify before include got error %v, want ErrNotWatched", err)
+	}
+
+	err = p.Watch.IncludeFD(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Watch.ModifyFD(fd, Write)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.Watch.AwaitEvent(holdupMax)
+	if err != nil || got.FD != fd || got.Ready != Write {
+		t.Errorf("got event %+v with error %v, want FD %#x with Write only",
+			got, err, fd)
+	}
+
+	err = p.Watch.ModifyFD(fd, Read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = p.Watch.AwaitEvent(0)
+	if err != ErrTimeout {
+		t.Errorf("got event %+v with error %v after Write removal, want ErrTimeout",
+			got, err)
+	}
+	if !p.Watch.IsWatched(fd) {
+		t.Error("not watched after modification")
+	}
+}
+
+func TestWatchTimeoutRestart(t *testing.T) {
+	p := newPipe(t)
+
+	// SIGURG is ignored by default, and the Go runtime uses it already
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Millisecond):
+				interruptThreads(t)
+			}
+		}
+	}()
+
+	const timeout = 200 * time.Millisecond
+	start := time.Now()
+	got, err := p.Watch.AwaitFDWithRead(timeout)
+	if err != ErrTimeout {
+		t.Errorf("got FD %#x with error %v, want ErrTimeout", got, err)
+	} else if age := time.Since(start); age < timeout || age > timeout+holdupMax {
+		t.Errorf("wait up to %s took %s, want in range [%s, %s]",
+			timeout, age, timeout, timeout+holdupMax)
+	}
+	t.Logf("%d restarts", p.Watch.Stats().Restarts)
+}
+
+func TestWatchAwaitTimed(t *testing.T) {
+	p := newPipe(t)
+
+	const timeout = 20 * time.Millisecond
+	got, waited, err := p.Watch.AwaitFDWithReadTimed(timeout)
+	if err != ErrTimeout {
+		t.Errorf("got FD %#x with error %v, want ErrTimeout", got, err)
+	}
+	if waited < timeout || waited > timeout+holdupMax {
+		t.Errorf("timeout of %s waited %s", timeout, waited)
+	}
+
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	err = p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, waited, err = p.Watch.AwaitFDWithReadTimed(timeout)
+	if err != nil || got != p.rFD {
+		t.Errorf("got FD %#x with error %v, want FD %#x", got, err, p.rFD)
+	}
+	if waited >= timeout {
+		t.Errorf("pending read waited %s", waited)
+	}
+}
+
+func TestWatchPendingWrite(t *testing.T) {
+	p := newPipe(t)
+
+	// before inclusion on watch list
+	_, err := p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	err = p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// should work on all timeout options, and repeatedly
+	got, err := p.Watch.AwaitFDWithRead(0)
+	if err != nil || got != p.rFD {
+		t.Errorf("non-blocking got FD %#x with error %v, want FD %#x",
+			got, err, p.rFD)
+	}
+	got, err = p.Watch.AwaitFDWithRead(time.Millisecond)
+	if err != nil || got != p.rFD {
+		t.Errorf("await 1 ms got FD %#x with error %v, want FD %#x",
+			got, err, p.rFD)
+	}
+	got, err = p.Watch.AwaitFDWithRead(-1)
+	if err != nil || got != p.rFD {
+		t.Errorf("indefinite wait got FD %#x with error %v, want FD %#x",
+			got, err, p.rFD)
+	}
+}
+
+func TestWatchIncludeDupe(t *testing.T) {
+	p := newPipe(t)
+
+	fd := p.rFD
+	err := p.Watch.IncludeFD(fd)
+	if err != nil {
+		t.Fatal("error on initial inclusion:", err)
+	}
+	err = p.Watch.IncludeFD(fd)
+	if err != nil {
+		t.Fatal("error on redundant inclusion:", err)
+	}
+
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+
+	got, err := p.Watch.AwaitFDWithRead(-1)
+	if err != nil || got != fd {
+		t.Errorf("got FD %#x and error %v, want FD %d",
+			got, err, fd)
+	}
+}
+
+func TestWatchIncludeFDs(t *testing.T) {
+	p := newPipe(t)
+
+	// not open for sure
+	const badFD = math.MaxInt32
+
+	_, err := p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+
+	err = p.Watch.IncludeFDs(badFD, p.rFD)
+	var fdErr *FDError
+	if !errors.As(err, &fdErr) {
+		t.Fatalf("got error %v, want an FDError", err)
+	}
+	if fdErr.FD != badFD {
+		t.Errorf("got error for FD %#x, want FD %#x", fdErr.FD, badFD)
+	}
+	if !errors.Is(err, ErrBadFD) {
+		t.Errorf("got error %v, want ErrBadFD", err)
+	}
+
+	got, err := p.Watch.AwaitFDWithRead(0)
+	if err != nil || got != p.rFD {
+		t.Errorf("await got FD %#x with error %v, want FD %#x",
+			got, err, p.rFD)
+	}
+}
+
+func TestWatchExcludeFDs(t *testing.T) {
+	p := newPipe(t)
+
+	// not open for sure
+	const badFD = math.MaxInt32
+
+	_, err := p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	wFD := int(p.w.Fd())
+	err = p.Watch.IncludeFDForWrite(wFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = p.Watch.ExcludeFDs(p.rFD, badFD, wFD)
+	var fdErr *FDError
+	if !errors.As(err, &fdErr) {
+		t.Fatalf("got error %v, want an FDError", err)
+	}
+	if fdErr.FD != badFD || !errors.Is(err, ErrBadFD) {
+		t.Errorf("got error %v, want ErrBadFD for FD %#x", err, badFD)
+	}
+	if n := len(p.Watch.FDs()); n != 0 {
+		t.Errorf("got %d file descriptors on the watch list after exclusion, want none", n)
+	}
+
+	got, err := p.Watch.AwaitFDWithRead(0)
+	if err != ErrTimeout {
+		t.Errorf("await got FD %#x with error %v, want ErrTimeout", got, err)
+	}
+	err = p.Watch.ExcludeFDs(p.rFD, wFD)
+	if err != nil {
+		t.Errorf("got error %v for absent file descriptors", err)
+	}
+}
+
+func TestWatchPauseFD(t *testing.T) {
+	p := newPipe(t)
+
+	err := p.Watch.PauseFD(p.rFD)
+	if err != ErrNotWatched {
+		t.Errorf("got error %v before include, want ErrNotWatched", err)
+	}
+	err = p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+
+	err = p.Watch.PauseFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.Watch.AwaitFDWithRead(10 * time.Millisecond)
+	if err != ErrTimeout {
+		t.Errorf("got FD %#x with error %v while paused, want ErrTimeout", got, err)
+	}
+	// interest applies on resume
+	err = p.Watch.ModifyFD(p.rFD, Read|Priority)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = p.Watch.AwaitFDWithRead(0)
+	if err != ErrTimeout {
+		t.Errorf("got FD %#x with error %v after ModifyFD while paused, want ErrTimeout", got, err)
+	}
+	if fds := p.Watch.FDs(); len(fds) != 1 || fds[0] != p.rFD {
+		t.Errorf("got watch list %v while paused, want FD %#x", fds, p.rFD)
+	}
+
+	err = p.Watch.ResumeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = p.Watch.AwaitFDWithRead(holdupMax)
+	if err != nil || got != p.rFD {
+		t.Errorf("got FD %#x with error %v after resume, want FD %#x", got, err, p.rFD)
+	}
+}
+
+func TestWatchIncludeFDer(t *testing.T) {
+	p := newPipe(t)
+	err := p.Watch.IncludeFDer(p.r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Watch.IsWatched(p.rFD) {
+		t.Error("file descriptor of os.File not on watch list")
+	}
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	got, err := p.Watch.AwaitFDWithRead(holdupMax)
+	if err != nil || got != p.rFD {
+		t.Errorf("got FD %#x with error %v, want FD %#x", got, err, p.rFD)
+	}
+
+	err = p.Watch.ExcludeFDer(p.r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Watch.IsWatched(p.rFD) {
+		t.Error("file descriptor of os.File still on watch list")
+	}
+}
+
+func TestWatchBadFD(t *testing.T) {
+	p := newPipe(t)
+
+	// not open for sure
+	const badFD = math.MaxInt32
+
+	err := p.Watch.IncludeFD(badFD)
+	if err != ErrBadFD {
+		t.Errorf("include got error %v, want ErrBadFD", err)
+	}
+
+	// Watch remains operational
+	err = p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	got, err := p.Watch.AwaitFDWithRead(holdupMax)
+	if err != nil || got != p.rFD {
+		t.Errorf("await got FD %#x with error %v, want FD %#x",
+			got, err, p.rFD)
+	}
+}
+
+func TestPollFD(t *testing.T) {
+	p := newPipe(t)
+
+	ready, err := PollFD(p.rFD, 0)
+	if err != nil || ready {
+		t.Errorf("got ready %t with error %v without data, want not ready", ready, err)
+	}
+	ready, err = PollFD(p.rFD, time.Millisecond)
+	if err != nil || ready {
+		t.Errorf("got ready %t with error %v after wait without data, want not ready", ready, err)
+	}
+
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	ready, err = PollFD(p.rFD, holdupMax)
+	if err != nil || !ready {
+		t.Errorf("got ready %t with error %v with data, want ready", ready, err)
+	}
+
+	// not open for sure
+	const badFD = math.MaxInt32
+	_, err = PollFD(badFD, 0)
+	if err != ErrBadFD {
+		t.Errorf("got error %v for bad FD, want ErrBadFD", err)
+	}
+}
+
+func TestSetNonBlock(t *testing.T) {
+	var fds [2]int
+	err := syscall.Pipe(fds[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fds[0])
+	defer syscall.Close(fds[1])
+
+	err = SetNonBlock(fds[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf [1]byte
+	_, err = syscall.Read(fds[0], buf[:])
+	if err != syscall.EAGAIN {
+		t.Errorf("read on empty pipe got error %v, want EAGAIN", err)
+	}
+
+	// not open for sure
+	err = SetNonBlock(math.MaxInt32)
+	if err != ErrBadFD {
+		t.Errorf("got error %v for bad FD, want ErrBadFD", err)
+	}
+}
+
+func TestActivationFiles(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	_, err = w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+
+	// high numbers in sequence, as passed by systemd from 3
+	const start = 910
+	for i, f := range []*os.File{r, w} {
+		r1, _, errno := syscall.Syscall(syscall.SYS_FCNTL, f.Fd(), syscall.F_DUPFD, start+uintptr(i))
+		if errno == 0 && int(r1) != start+i {
+			syscall.Close(int(r1))
+			errno = syscall.EBUSY
+		}
+		if errno != 0 {
+			for j := 0; j < i; j++ {
+				syscall.Close(start + j)
+			}
+			t.Skip("no high file descriptor number:", errno)
+		}
+	}
+
+	watch, err := OpenWatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Close()
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "2")
+	t.Setenv("LISTEN_FDNAMES", "in")
+	files, err := activationFiles(watch, start)
+	if err != nil {
+		syscall.Close(start)
+		syscall.Close(start + 1)
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		defer f.Close()
+	}
+	if len(files) != 2 {
+		t.Fatalf("got %d files, want 2", len(files))
+	}
+	if got := files[0].Name(); got != "in" {
+		t.Errorf("got name %q, want %q", got, "in")
+	}
+	if got := files[1].Name(); got != "unknown" {
+		t.Errorf("got name %q without LISTEN_FDNAMES entry, want %q", got, "unknown")
+	}
+	flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, start, syscall.F_GETFD, 0)
+	if errno != 0 || flags&syscall.FD_CLOEXEC == 0 {
+		t.Errorf("got flags %#x with error %v, want FD_CLOEXEC", flags, errno)
+	}
+
+	got, err := watch.AwaitFDWithRead(holdupMax)
+	if err != nil || got != start {
+		t.Errorf("got FD %#x with error %v, want FD %#x", got, err, start)
+	}
+
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		if v, ok := os.LookupEnv(name); ok {
+			t.Errorf("environment variable %s=%q remains", name, v)
+		}
+	}
+	files, err = activationFiles(watch, start)
+	if err != nil || len(files) != 0 {
+		t.Errorf("got %d files with error %v from second call, want none", len(files), err)
+	}
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "2")
+	files, err = activationFiles(nil, start)
+	if err != nil || len(files) != 0 {
+		t.Errorf("got %d files with error %v for another process, want none", len(files), err)
+	}
+}
+
+func TestHandoff(t *testing.T) {
+	if os.Getenv(handoffEnv) != "" {
+		testAdoptFDs(t)
+		return
+	}
+
+	p := newPipe(t)
+	err := p.Watch.IncludeFDToken(p.rFD, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Watch.IncludeFDRank(p.rFD, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	err = p.Watch.IncludeFD(int(r.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Watch.PauseFD(int(r.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []*os.File{p.w, w} {
+		_, err = f.WriteString("Hello")
+		if err != nil {
+			t.Fatal("test data lost:", err)
+		}
+	}
+
+	// the test binary adopts in testAdoptFDs
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHandoff$", "-test.count=1")
+	err = p.Watch.Handoff(cmd)
+	if err != nil {
+		t.Fatal("handoff error:", err)
+	}
+	if len(cmd.ExtraFiles) != 2 {
+		t.Fatalf("got %d extra files, want 2", len(cmd.ExtraFiles))
+	}
+	out, err := cmd.CombinedOutput()
+	for _, f := range cmd.ExtraFiles {
+		f.Close()
+	}
+	if err != nil {
+		t.Errorf("child process error %v with output:\n%s", err, out)
+	}
+	if !p.Watch.Contains(p.rFD) {
+		t.Error("handoff removed the file descriptor from the watch list")
+	}
+}
+
+// TestAdoptFDs is the child process of TestHandoff.
+func testAdoptFDs(t *testing.T) {
+	w, err := OpenWatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	adopted, err := w.AdoptFDs()
+	if err != nil {
+		t.Fatal("adopt error:", err)
+	}
+	if len(adopted) != 2 {
+		t.Fatalf("got %d file descriptors adopted, want 2", len(adopted))
+	}
+	if a := adopted[0]; a.FD != 3 || a.Interest != Read || a.Paused || a.Token != 42 || a.Rank != 7 {
+		t.Errorf("got %+v, want FD 3 with Read, token 42 and rank 7", a)
+	}
+	if a := adopted[1]; a.FD != 4 || a.Interest != Read || !a.Paused || a.Token != 0 || a.Rank != 0 {
+		t.Errorf("got %+v, want FD 4 with Read paused", a)
+	}
+	if _, ok := os.LookupEnv(handoffEnv); ok {
+		t.Error("handoff environment variable remains")
+	}
+
+	e, err := w.AwaitEvent(holdupMax)
+	if err != nil || e.FD != 3 || e.Token != 42 {
+		t.Errorf("got event %+v with error %v, want FD 3 with token 42", e, err)
+	}
+	var buf [8]byte
+	if _, err := syscall.Read(3, buf[:]); err != nil {
+		t.Fatal("read of adopted file descriptor:", err)
+	}
+	got, err := w.AwaitFDWithRead(10 * time.Millisecond)
+	if err != ErrTimeout {
+		t.Errorf("got FD %#x with error %v, want ErrTimeout as paused", got, err)
+	}
+}
+
+func TestWatchSweepFDs(t *testing.T) {
+	swept := make(chan int, 4)
+	p := newPipe(t, SweepDeadFDs(time.Millisecond, func(fd int) { swept <- fd }))
+
+	// high number against reuse by tests in parallel
+	r1, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(p.rFD), syscall.F_DUPFD, 900)
+	if errno != 0 {
+		t.Skip("no high file descriptor number:", errno)
+	}
+	fd := int(r1)
+	syscall.CloseOnExec(fd)
+	err := p.Watch.IncludeFDToken(fd, 42)
+	if err != nil {
+		syscall.Close(fd)
+		t.Fatal(err)
+	}
+	err = p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// close without ExcludeFD
+	syscall.Close(fd)
+
+	select {
+	case got := <-swept:
+		if got != fd {
+			t.Errorf("swept file descriptor %d, want %d", got, fd)
+		}
+	case <-time.After(holdupMax):
+		t.Fatal("no sweep of the closed file descriptor")
+	}
+	if p.Watch.Contains(fd) {
+		t.Error("closed file descriptor still on the watch list")
+	}
+	if !p.Watch.Contains(p.rFD) {
+		t.Error("open file descriptor lost in sweep")
+	}
+	removed, err := p.Watch.SweepFDs()
+	if err != nil || len(removed) != 0 {
+		t.Errorf("sweep again got %v with error %v, want none", removed, err)
+	}
+}
+
+func TestWatchFD(t *testing.T) {
+	p := newPipe(t)
+	err := p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outer, err := OpenWatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outer.Close()
+	innerFD := p.Watch.FD()
+	err = outer.IncludeFD(innerFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	got, err := outer.AwaitFDWithRead(holdupMax)
+	if err != nil || got != innerFD {
+		t.Fatalf("outer got FD %#x with error %v, want the inner Watch %#x", got, err, innerFD)
+	}
+	got, err = p.Watch.AwaitFDWithRead(0)
+	if err != nil || got != p.rFD {
+		t.Errorf("inner got FD %#x with error %v, want read end %#x", got, err, p.rFD)
+	}
+
+	err = outer.ExcludeFD(innerFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Watch.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fd := p.Watch.FD(); fd != -1 {
+		t.Errorf("got FD %d after Close, want -1", fd)
+	}
+}
+
+func TestWatchFDs(t *testing.T) {
+	p := newPipe(t)
+	if got := p.Watch.FDs(); len(got) != 0 {
+		t.Errorf("new Watch got FDs %v, want none", got)
+	}
+
+	wFD := int(p.w.Fd())
+	err := p.Watch.IncludeFDForWrite(wFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.Watch.AddTimer(time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int{p.rFD, wFD}
+	if want[0] > want[1] {
+		want[0], want[1] = want[1], want[0]
+	}
+	if got := p.Watch.FDs(); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got FDs %v, want %v", got, want)
+	}
+	if !p.Watch.Contains(p.rFD) || !p.Watch.Contains(wFD) {
+		t.Error("included file descriptors not contained")
+	}
+
+	err = p.Watch.ExcludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := p.Watch.FDs(); len(got) != 1 || got[0] != wFD {
+		t.Errorf("got FDs %v after exclude, want [%d]", got, wFD)
+	}
+	if p.Watch.Contains(p.rFD) {
+		t.Error("excluded file descriptor contained")
+	}
+}
+
+func TestWatchExcludeFDStrict(t *testing.T) {
+	p := newPipe(t)
+
+	err := p.Watch.ExcludeFDStrict(p.rFD)
+	if err != ErrNotWatched {
+		t.Errorf("exclude before include got error %v, want ErrNotWatched", err)
+	}
+	err = p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Watch.ExcludeFDStrict(p.rFD)
+	if err != nil {
+		t.Errorf("exclude got error %v", err)
+	}
+	err = p.Watch.ExcludeFDStrict(p.rFD)
+	if err != ErrNotWatched {
+		t.Errorf("second exclude got error %v, want ErrNotWatched", err)
+	}
+}
+
+func TestWatchExcludeDupe(t *testing.T) {
+	p := newPipe(t)
+
+	fd := p.rFD
+	err := p.Watch.IncludeFD(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Watch.ExcludeFD(fd)
+	if err != nil {
+		t.Fatal("error on initial exclusion:", err)
+	}
+	err = p.Watch.ExcludeFD(fd)
+	if err != nil {
+		t.Fatal("error on redundant exclusion:", err)
+	}
+
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+
+	got, err := p.Watch.AwaitFDWithRead(50 * time.Millisecond)
+	if err != ErrTimeout {
+		t.Errorf("got FD %#x with error %v, want ErrTimeout",
+			got, err)
+	}
+}
+
+// TCP urgent data is delivered out-of-band.
+func TestWatchPriority(t *testing.T) {
+	switch runtime.GOOS {
+	case "linux", "darwin", "dragonfly", "openbsd":
+		// supported
+	default:
+		t.Skip("no out-of-band detection on", runtime.GOOS)
+	}
+	p := newPipe(t)
+	client, server := newTCPFiles(t)
+
+	fd := int(server.Fd())
+	err := p.Watch.IncludeFDForPriority(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = syscall.Sendto(int(client.Fd()), []byte{'!'}, syscall.MSG_OOB, nil)
+	if err != nil {
+		t.Fatal("urgent data lost:", err)
+	}
+
+	got, ready, err := p.Watch.AwaitFD(holdupMax)
+	if err != nil || got != fd || ready&Priority == 0 {
+		t.Errorf("got FD %#x with conditions %#x and error %v, want FD %#x with Priority",
+			got, ready, err, fd)
+	}
+
+	// Priority without read interest
+	err = p.Watch.IncludeFDInterest(fd, Priority)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ready, err = p.Watch.AwaitFD(holdupMax)
+	if err != nil || got != fd || ready != Priority {
+		t.Errorf("got FD %#x with conditions %s and error %v, want FD %#x with Priority only",
+			got, ready, err, fd)
+	}
+}
+
+// Continuously ready descriptors must all get their turn.
+func TestWatchFairness(t *testing.T) {
+	p := newPipe(t)
+
+	const pipeCount = 50
+	want := make(map[int]bool, pipeCount)
+	for i := 0; i < pipeCount; i++ {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			r.Close()
+			w.Close()
+		})
+		_, err = w.WriteString("Hello")
+		if err != nil {
+			t.Fatal("test data lost:", err)
+		}
+
+		fd := int(r.Fd())
+		err = p.Watch.IncludeFD(fd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[fd] = true
+	}
+
+	// data remains unread, i.e., all descriptors stay ready
+	seen := make(map[int]bool, pipeCount)
+	for i := 0; i < pipeCount; i++ {
+		got, err := p.Watch.AwaitFDWithRead(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !want[got] {
+			t.Fatalf("got FD %#x not on watch list", got)
+		}
+		if seen[got] {
+			t.Fatalf("got FD %#x again after %d awaits, want each of the %d in turn",
+				got, i, pipeCount)
+		}
+		seen[got] = true
+	}
+}
+
+func TestWatchDetectHalfClose(t *testing.T) {
+	p := newPipe(t, DetectHalfClose())
+
+	pair, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(pair[0])
+	defer syscall.Close(pair[1])
+
+	err = p.Watch.IncludeFD(pair[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = syscall.Shutdown(pair[1], syscall.SHUT_WR)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, ready, err := p.Watch.AwaitFD(holdupMax)
+	if err != nil || got != pair[0] || ready&Hangup == 0 {
+		t.Errorf("got FD %#x with conditions %#x and error %v, want FD %#x with Hangup",
+			got, ready, err, pair[0])
+	}
+}
+
+func TestWatchKernelOrder(t *testing.T) {
+	p := newPipe(t, WithOrdering(KernelOrder))
+	err := p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+
+	// data remains unread, i.e., the descriptor stays ready
+	for i := 0; i < 3; i++ {
+		got, err := p.Watch.AwaitFDWithRead(0)
+		if err != nil || got != p.rFD {
+			t.Fatalf("await %d got FD %#x with error %v, want FD %#x",
+				i+1, got, err, p.rFD)
+		}
+	}
+}
+
+func TestWatchDrain(t *testing.T) {
+	p := newPipe(t)
+
+	n, err := p.Watch.Drain()
+	if err != nil || n != 0 {
+		t.Errorf("got %d events with error %v from empty watch list, want none", n, err)
+	}
+
+	err = p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err = p.Watch.Drain()
+	if err != nil || n != 0 {
+		t.Errorf("got %d events with error %v without data, want none", n, err)
+	}
+
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	// data remains unread, i.e., the descriptor stays ready
+	n, err = p.Watch.Drain()
+	if err != nil || n != 1 {
+		t.Errorf("got %d events with error %v, want 1", n, err)
+	}
+
+	p.Watch.Close()
+	_, err = p.Watch.Drain()
+	if err != ErrClosed {
+		t.Errorf("got error %v after Close, want ErrClosed", err)
+	}
+}
+
+func TestWatchWorkers(t *testing.T) {
+	p := newPipe(t, ExclusiveWakeup())
+
+	const pipeCount = 8
+	for i := 0; i < pipeCount; i++ {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			r.Close()
+			w.Close()
+		})
+		_, err = w.WriteString("Hello")
+		if err != nil {
+			t.Fatal("test data lost:", err)
+		}
+		err = p.Watch.IncludeFD(int(r.Fd()))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// data remains unread, i.e., all descriptors stay ready
+	const workerCount = 4
+	var budget atomic.Int64
+	budget.Store(400)
+	counts := make(chan int, workerCount)
+	for i := 0; i < workerCount; i++ {
+		go func() {
+			var n int
+			for budget.Add(-1) >= 0 {
+				_, err := p.Watch.AwaitFDWithRead(holdupMax)
+				if err != nil {
+					t.Error(err)
+					break
+				}
+				n++
+				runtime.Gosched()
+			}
+			counts <- n
+		}()
+	}
+	var busy int
+	for i := 0; i < workerCount; i++ {
+		if <-counts != 0 {
+			busy++
+		}
+	}
+	if busy < 2 {
+		t.Errorf("%d workers out of %d got events", busy, workerCount)
+	}
+}
+
+// Workers on a shared Watch get one of them woken per connection.
+func TestWatchExclusiveAccept(t *testing.T) {
+	p := newPipe(t, ExclusiveWakeup(), EdgeTriggered())
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	f, err := ListenerFile(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	err = p.Watch.IncludeFD(int(f.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const workerCount = 4
+	errs := make(chan error, workerCount)
+	for i := 0; i < workerCount; i++ {
+		go func() {
+			_, err := p.Watch.AwaitFDWithRead(holdupMax)
+			errs <- err
+		}()
+	}
+	time.Sleep(10 * time.Millisecond) // all blocked
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var woken int
+	for i := 0; i < workerCount; i++ {
+		switch err := <-errs; err {
+		case nil:
+			woken++
+		case ErrTimeout:
+			break
+		default:
+			t.Error("await error:", err)
+		}
+	}
+	if woken != 1 {
+		t.Errorf("%d workers out of %d got the connection, want 1", woken, workerCount)
+	}
+}
+
+func TestWatchExcludeOnHangup(t *testing.T) {
+	p := newPipe(t, ExcludeOnHangup())
+	err := p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = p.w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !p.Watch.IsWatched(p.rFD) {
+		t.Error("read end not watched after include")
+	}
+	got, ready, err := p.Watch.AwaitFD(holdupMax)
+	if err != nil || got != p.rFD || ready&Hangup == 0 {
+		t.Fatalf("got FD %#x with conditions %#x and error %v, want FD %#x with Hangup",
+			got, ready, err, p.rFD)
+	}
+	if p.Watch.IsWatched(p.rFD) {
+		t.Error("read end still watched after hangup")
+	}
+	got, err = p.Watch.AwaitFDWithRead(10 * time.Millisecond)
+	if err != ErrTimeout {
+		t.Errorf("await after hangup got FD %#x with error %v, want ErrTimeout",
+			got, err)
+	}
+}
+
+func TestAwaitEventExcludeOnHangup(t *testing.T) {
+	p := newPipe(t, ExcludeOnHangup())
+	err := p.Watch.IncludeFDToken(p.rFD, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+
+	e, err := p.Watch.AwaitEvent(holdupMax)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.FD != p.rFD || e.Excluded || e.Token != 42 {
+		t.Errorf("got %+v before hangup, want read end with token 42, not Excluded", e)
+	}
+
+	err = p.w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err = p.Watch.AwaitEvent(holdupMax)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.FD != p.rFD || e.Ready&Hangup == 0 || !e.Excluded || e.Token != 42 {
+		t.Errorf("got %+v after hangup, want read end with Hangup, Excluded and token 42", e)
+	}
+	if p.Watch.IsWatched(p.rFD) {
+		t.Error("read end still watched after the terminal event")
+	}
+	_, err = p.Watch.AwaitEvent(10 * time.Millisecond)
+	if err != ErrTimeout {
+		t.Errorf("await after the terminal event got error %v, want ErrTimeout", err)
+	}
+}
+
+func TestWatchTimer(t *testing.T) {
+	p := newPipe(t)
+
+	const delay = 10 * time.Millisecond
+	start := time.Now()
+	id, err := p.Watch.AddTimer(delay, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.Watch.AwaitFDWithRead(delay + holdupMax)
+	if err != nil || got != id {
+		t.Fatalf("got FD %#x with error %v, want timer %#x", got, err, id)
+	}
+	if age := time.Since(start); age < delay {
+		t.Errorf("one-shot timer of %s expired after %s", delay, age)
+	}
+	got, err = p.Watch.AwaitFDWithRead(2 * delay)
+	if err != ErrTimeout {
+		t.Errorf("expired one-shot timer got FD %#x with error %v, want ErrTimeout",
+			got, err)
+	}
+	err = p.Watch.RemoveTimer(id)
+	if err != nil {
+		t.Error("remove one-shot timer:", err)
+	}
+
+	id, err = p.Watch.AddTimer(delay, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		got, err := p.Watch.AwaitFDWithRead(delay + holdupMax)
+		if err != nil || got != id {
+			t.Fatalf("periodic timer expiry %d got FD %#x with error %v, want timer %#x",
+				i+1, got, err, id)
+		}
+	}
+	err = p.Watch.RemoveTimer(id)
+	if err != nil {
+		t.Error("remove periodic timer:", err)
+	}
+	got, err = p.Watch.AwaitFDWithRead(2 * delay)
+	if err != ErrTimeout {
+		t.Errorf("removed periodic timer got FD %#x with error %v, want ErrTimeout",
+			got, err)
+	}
+}
+
+func TestWatchReset(t *testing.T) {
+	p := newPipe(t)
+
+	err := p.Watch.Reset()
+	if err != nil {
+		t.Fatal("reset of empty watch:", err)
+	}
+
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	err = p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.Watch.AddTimer(time.Millisecond, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = p.Watch.Reset()
+	if err != nil {
+		t.Fatal("reset error:", err)
+	}
+	got, err := p.Watch.AwaitFDWithRead(10 * time.Millisecond)
+	if err != ErrTimeout {
+		t.Errorf("await after reset got FD %#x with error %v, want ErrTimeout",
+			got, err)
+	}
+
+	// watch remains operational
+	err = p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal("include after reset:", err)
+	}
+	got, err = p.Watch.AwaitFDWithRead(0)
+	if err != nil || got != p.rFD {
+		t.Errorf("await after reset and include got FD %#x with error %v, want FD %#x",
+			got, err, p.rFD)
+	}
+}
+
+func TestWatchResetAssociations(t *testing.T) {
+	p := newPipe(t)
+	child, err := OpenWatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer child.Close()
+	if err := p.Watch.IncludeWatch(child); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if _, err := p.Watch.IncludeListener(l); err != nil {
+		t.Fatal(err)
+	}
+
+	err = p.Watch.Reset()
+	if err != nil {
+		t.Fatal("reset error:", err)
+	}
+	if got := p.Watch.Child(child.FD()); got != nil {
+		t.Error("child remains after reset")
+	}
+	// no loop without the child
+	if err := child.IncludeWatch(p.Watch); err != nil {
+		t.Error("include of parent after reset got error:", err)
+	}
+	p.Watch.mutex.Lock()
+	listenerN, hungUpN := len(p.Watch.listeners), len(p.Watch.hungUp)
+	p.Watch.mutex.Unlock()
+	if listenerN != 0 || hungUpN != 0 {
+		t.Errorf("got %d listeners and %d hang-up tokens after reset, want none",
+			listenerN, hungUpN)
+	}
+}
+
+func TestWatchStats(t *testing.T) {
+	p := newPipe(t)
+
+	if got := p.Watch.Stats(); got != (Stats{}) {
+		t.Errorf("initial stats %+v, want all zero", got)
+	}
+
+	_, err := p.Watch.AwaitFDWithRead(0)
+	if err != ErrTimeout {
+		t.Fatalf("got error %v, want ErrTimeout", err)
+	}
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	err = p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		_, err = p.Watch.AwaitFDWithRead(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := p.Watch.IncludeFD(math.MaxInt32); err == nil {
+		t.Error("include of bad file descriptor got no error")
+	}
+	// each inclusion method counts
+	if err := p.Watch.IncludeFDs(math.MaxInt32); err == nil {
+		t.Error("IncludeFDs of bad file descriptor got no error")
+	}
+	if err := p.Watch.IncludeFDToken(math.MaxInt32, 42); err == nil {
+		t.Error("IncludeFDToken of bad file descriptor got no error")
+	}
+	if err := p.Watch.IncludeFDRank(math.MaxInt32, 42); err == nil {
+		t.Error("IncludeFDRank of bad file descriptor got no error")
+	}
+
+	got := p.Watch.Stats()
+	want := Stats{Awaits: 3, Events: 2, Timeouts: 1, IncludeErrors: 4, WatchList: 1}
+	want.Restarts = got.Restarts // signals are out of our control
+	if got != want {
+		t.Errorf("got stats %+v, want %+v", got, want)
+	}
+}
+
+func TestWatchSlowEvent(t *testing.T) {
+	const threshold = 10 * time.Millisecond
+	var slowFD atomic.Int64
+	slowFD.Store(-1)
+	p := newPipe(t, SlowEvent(threshold, func(fd int, gap time.Duration) {
+		if gap <= threshold {
+			t.Errorf("slow event on gap %s, want over %s", gap, threshold)
+		}
+		slowFD.Store(int64(fd))
+	}))
+	err := p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		fd, err := p.Watch.AwaitFDWithRead(holdupMax)
+		if err != nil || fd != p.rFD {
+			t.Fatalf("got FD %#x with error %v, want FD %#x", fd, err, p.rFD)
+		}
+	}
+	if got := slowFD.Load(); got != -1 {
+		t.Errorf("slow event on FD %#x without delay", got)
+	}
+	time.Sleep(2 * threshold)
+	_, err = p.Watch.AwaitFDWithRead(holdupMax)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := slowFD.Load(); got != int64(p.rFD) {
+		t.Errorf("got slow event on FD %#x, want FD %#x", got, p.rFD)
+	}
+
+	h := p.Watch.Latencies()
+	var total, slow uint64
+	for i, n := range h {
+		total += n
+		if time.Microsecond<<i > 2*threshold {
+			slow += n
+		}
+	}
+	if total != 2 || slow != 1 {
+		t.Errorf("got %d gaps with %d over %s, want 2 with 1 over", total, slow, 2*threshold)
+	}
+}
+
+func TestWatchDiagnostics(t *testing.T) {
+	var mutex sync.Mutex
+	var got []Diagnostic
+	p := newPipe(t, Diagnostics(func(d Diagnostic) {
+		if d.Op == "restart" {
+			return // signals are out of our control
+		}
+		mutex.Lock()
+		got = append(got, d)
+		mutex.Unlock()
+	}))
+
+	if err := p.Watch.IncludeFD(p.rFD); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Watch.ModifyFD(p.rFD, Read|Hangup); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Watch.ExcludeFD(p.rFD); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Watch.IncludeFD(math.MaxInt32); err == nil {
+		t.Fatal("include of bad file descriptor got no error")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	want := []Diagnostic{
+		{Op: "include", FD: p.rFD, Interest: Read},
+		{Op: "modify", FD: p.rFD, Interest: Read | Hangup},
+		{Op: "exclude", FD: p.rFD},
+		{Op: "include", FD: math.MaxInt32, Interest: Read, Err: ErrBadFD},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got diagnostics %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i].Op != want[i].Op || got[i].FD != want[i].FD ||
+			got[i].Interest != want[i].Interest || !errors.Is(got[i].Err, want[i].Err) {
+			t.Errorf("got diagnostic %d %+v, want %+v", i+1, got[i], want[i])
+		}
+	}
+}
+
+func TestWatchEventCount(t *testing.T) {
+	p := newPipe(t, CountPerFD())
+	err := p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err = p.Watch.AwaitFDWithRead(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := p.Watch.EventCount(p.rFD); got != 3 {
+		t.Errorf("got event count %d, want 3", got)
+	}
+	if got := p.Watch.EventCounts(); len(got) != 1 || got[p.rFD] != 3 {
+		t.Errorf("got event counts %v, want only FD %#x with 3", got, p.rFD)
+	}
+
+	// disabled by default
+	w, err := OpenWatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if got := w.EventCounts(); got != nil {
+		t.Errorf("got event counts %v without CountPerFD, want nil", got)
+	}
+}
+
+func TestWatchFile(t *testing.T) {
+	p := newPipe(t)
+	err := p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := p.Watch.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outer, err := OpenWatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outer.Close()
+	err = outer.IncludeFD(int(f.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	got, err := outer.AwaitFDWithRead(holdupMax)
+	if err != nil || got != int(f.Fd()) {
+		t.Errorf("outer Watch got FD %#x with error %v, want FD %#x of File",
+			got, err, f.Fd())
+	}
+
+	// independent of Watch
+	outer.ExcludeFD(int(f.Fd()))
+	err = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = p.Watch.AwaitFDWithRead(holdupMax)
+	if err != nil || got != p.rFD {
+		t.Errorf("got FD %#x with error %v after File close, want FD %#x",
+			got, err, p.rFD)
+	}
+
+	p.Watch.Close()
+	_, err = p.Watch.File()
+	if err != ErrClosed {
+		t.Errorf("got error %v after Close, want ErrClosed", err)
+	}
+}
+
+func TestWatchSyscallConn(t *testing.T) {
+	p := newPipe(t)
+	err := p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := p.Watch.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kernelFD int
+	err = conn.Control(func(fd uintptr) { kernelFD = int(fd) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	ready, err := PollFD(kernelFD, 0)
+	if err != nil || ready {
+		t.Errorf("got ready %t with error %v without events, want not ready", ready, err)
+	}
+
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	var reads int
+	err = conn.Read(func(fd uintptr) bool {
+		reads++
+		ready, err := PollFD(int(fd), 0)
+		if err != nil {
+			t.Error(err)
+		}
+		return ready
+	})
+	if err != nil {
+		t.Error("read error:", err)
+	}
+	if reads > 2 {
+		t.Errorf("got %d read attempts, want at most 2", reads)
+	}
+
+	p.Watch.Close()
+	err = conn.Control(func(fd uintptr) { t.Error("control after Close") })
+	if err != ErrClosed {
+		t.Errorf("control after Close got error %v, want ErrClosed", err)
+	}
+}
+
+func TestAwaitAny(t *testing.T) {
+	p := newPipe(t)
+	err := p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := OpenWatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	_, _, err = AwaitAny(0, p.Watch, other)
+	if err != ErrTimeout {
+		t.Fatalf("got error %v without data, want ErrTimeout", err)
+	}
+
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	got, fd, err := AwaitAny(holdupMax, other, p.Watch)
+	if err != nil || got != p.Watch || fd != p.rFD {
+		t.Errorf("got Watch %p with FD %#x and error %v, want Watch %p with FD %#x",
+			got, fd, err, p.Watch, p.rFD)
+	}
+
+	other.Close()
+	_, _, err = AwaitAny(0, p.Watch, other)
+	if err != ErrClosed {
+		t.Errorf("got error %v with closed Watch, want ErrClosed", err)
+	}
+}
+
+func TestIncludeWatch(t *testing.T) {
+	// edge-triggered events read ahead do not make the child ready
+	p := newPipe(t, EdgeTriggered(), EventBatch(8))
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	for _, fd := range []int{p.rFD, int(r.Fd())} {
+		err := p.Watch.IncludeFD(fd)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	parent, err := OpenWatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer parent.Close()
+	err = parent.IncludeWatch(p.Watch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	childFD := p.Watch.FD()
+
+	if err := parent.IncludeWatch(parent); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("include of itself got error %v, want EINVAL", err)
+	}
+	if err := p.Watch.IncludeWatch(parent); !errors.Is(err, syscall.ELOOP) {
+		t.Errorf("include of parent got error %v, want ELOOP", err)
+	}
+
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	_, err = w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		got, err := parent.AwaitFDWithRead(holdupMax)
+		if err != nil || got != childFD {
+			t.Fatalf("got FD %#x with error %v, want child FD %#x", got, err, childFD)
+		}
+		child := parent.Child(got)
+		if child != p.Watch {
+			t.Fatalf("got child %p, want %p", child, p.Watch)
+		}
+		_, err = child.AwaitFDWithRead(0)
+		if err != nil {
+			t.Fatal("child await error:", err)
+		}
+	}
+	got, err := parent.AwaitFDWithRead(0)
+	if err != ErrTimeout {
+		t.Errorf("got FD %#x with error %v after drain, want ErrTimeout", got, err)
+	}
+	if got := parent.Child(p.rFD); got != nil {
+		t.Errorf("got child %p for a file descriptor of the child", got)
+	}
+
+	err = parent.ExcludeWatch(p.Watch)
+	if err != nil {
+		t.Fatal("exclude error:", err)
+	}
+	if got := parent.Child(childFD); got != nil {
+		t.Errorf("got child %p after exclude, want nil", got)
+	}
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	got, err = parent.AwaitFDWithRead(10 * time.Millisecond)
+	if err != ErrTimeout {
+		t.Errorf("got FD %#x with error %v after exclude, want ErrTimeout", got, err)
+	}
+}
+
+func TestWatchString(t *testing.T) {
+	p := newPipe(t)
+	err := p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := p.Watch.String()
+	want := fmt.Sprintf(", file count 1 [%d], timer count 0 []", p.rFD)
+	if !strings.HasPrefix(got, "Watch with ") || !strings.HasSuffix(got, want) {
+		t.Errorf("got %q, want suffix %q", got, want)
+	}
+
+	p.Watch.Close()
+	got = p.Watch.String()
+	if !strings.HasSuffix(got, " closed") {
+		t.Errorf("got %q after Close, want suffix %q", got, " closed")
+	}
+}
+
+// AllocsPerRun can not run in parallel, which rules out newPipe.
+func TestAwaitReadyAllocs(t *testing.T) {
+	w, err := OpenWatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	r, wr, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer wr.Close()
+	_, err = wr.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+	rFD := int(r.Fd())
+	err = w.IncludeFD(rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// data remains unread, i.e., the descriptor stays ready
+	allocs := testing.AllocsPerRun(100, func() {
+		fd, err := w.AwaitFDWithRead(0)
+		if err != nil || fd != rFD {
+			t.Fatalf("got FD %#x with error %v, want FD %#x", fd, err, rFD)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("got %f allocations per AwaitFDWithRead, want none", allocs)
+	}
+	allocs = testing.AllocsPerRun(100, func() {
+		w.AwaitFD(0)
+	})
+	if allocs != 0 {
+		t.Errorf("got %f allocations per AwaitFD, want none", allocs)
+	}
+}
+
+func BenchmarkAwaitReady(b *testing.B) {
+	w, err := OpenWatch()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer w.Close()
+
+	r, wr, err := os.Pipe()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer r.Close()
+	defer wr.Close()
+	_, err = wr.WriteString("Hello")
+	if err != nil {
+		b.Fatal("test data lost:", err)
+	}
+	err = w.IncludeFD(int(r.Fd()))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	// data remains unread, i.e., the descriptor stays ready
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := w.AwaitFDWithRead(0)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAwaitEvents(b *testing.B) {
+	w, err := OpenWatch()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer w.Close()
+
+	var buf [16]Event
+	// nothing on the watch list yet
+	allocs := testing.AllocsPerRun(100, func() {
+		w.AwaitEvents(buf[:], 0)
+	})
+	if allocs != 0 {
+		b.Errorf("got %f allocations per timeout, want none", allocs)
+	}
+
+	for i := 0; i < 4; i++ {
+		r, wr, err := os.Pipe()
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer r.Close()
+		defer wr.Close()
+		_, err = wr.WriteString("Hello")
+		if err != nil {
+			b.Fatal("test data lost:", err)
+		}
+		err = w.IncludeFDToken(int(r.Fd()), uint64(i))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	// data remains unread, i.e., the descriptors stay ready
+	allocs = testing.AllocsPerRun(100, func() {
+		w.AwaitEvents(buf[:], 0)
+	})
+	if allocs != 0 {
+		b.Errorf("got %f allocations per await, want none", allocs)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := w.AwaitEvents(buf[:], 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestClosed(t *testing.T) {
+	w, err := OpenWatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	time.Sleep(50 * time.Millisecond)
+	err = w.Close()
+	if err != nil {
+		t.Error("Close got error:", err)
+	}
+
+	_, err = w.AwaitFDWithRead(0)
+	if err != ErrClosed {
+		t.Errorf("await got error %v, want ErrClosed", err)
+	}
+
+	stdin := int(os.Stdin.Fd())
+	err = w.IncludeFD(stdin)
+	if err != ErrClosed {
+		t.Errorf("include got error %v, want ErrClosed", err)
+	}
+	err = w.ExcludeFD(stdin)
+	if err != ErrClosed {
+		t.Errorf("exclude got error %v, want ErrClosed", err)
+	}
+}
+
+// Close must interrupt an Await in progress.
+func TestCloseDuringAwait(t *testing.T) {
+	p := newPipe(t)
+	err := p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		fd  int
+		err error
+	}
+	done := make(chan result)
+	go func() {
+		fd, err := p.Watch.AwaitFDWithRead(-1)
+		done <- result{fd, err}
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	err = p.Watch.Close()
+	if err != nil {
+		t.Error("close error:", err)
+	}
+
+	select {
+	case got := <-done:
+		if got.err != ErrClosed {
+			t.Errorf("await got FD %#x with error %v, want ErrClosed",
+				got.fd, got.err)
+		}
+	case <-time.After(holdupMax):
+		t.Fatal("await still blocked after close")
+	}
+}
+
+// Close must interrupt each Await in progress, including those which keep on
+// getting file descriptors.
+func TestCloseDuringAwaits(t *testing.T) {
+	p := newPipe(t)
+	err := p.Watch.IncludeFD(p.rFD)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const routineCount = 8
+	done := make(chan error, routineCount)
+	for i := 0; i < routineCount; i++ {
+		busy := i%2 == 0
+		go func() {
+			for {
+				timeout := time.Duration(-1)
+				if busy {
+					timeout = 0
+				}
+				_, err := p.Watch.AwaitFDWithRead(timeout)
+				if err != nil && err != ErrTimeout {
+					done <- err
+					return
+				}
+			}
+		}()
+	}
+	_, err = p.w.WriteString("Hello")
+	if err != nil {
+		t.Fatal("test data lost:", err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	err = p.Watch.Close()
+	if err != nil {
+		t.Error("close error:", err)
+	}
+
+	timeout := time.After(holdupMax)
+	for i := 0; i < routineCount; i++ {
+		select {
+		case err := <-done:
+			if err != ErrClosed {
+				t.Errorf("await got error %v, want ErrClosed", err)
+			}
+		case <-timeout:
+			t.Fatalf("%d awaits still running after close", routineCount-i)
+		}
+	}
+}
+
+// Any combination of calls from multiple routines must be safe, including a
+// Close at any point.
+func TestWatchConcurrentUse(t *testing.T) {
+	p := newPipe(t)
+
+	const routineCount = 4
+	var files [routineCount]*os.File
+	for i := range files {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		defer w.Close()
+		_, err = w.WriteString("Hello") // readable for good
+		if err != nil {
+			t.Fatal("test data lost:", err)
+		}
+		files[i] = r
+	}
+
+	done := make(chan error, 2*routineCount)
+	for i := 0; i < routineCount; i++ {
+		fd := int(files[i].Fd())
+		go func() {
+			for {
+				err := p.Watch.IncludeFD(fd)
+				if err == nil {
+					err = p.Watch.Wakeup()
+				}
+				if err == nil {
+					err = p.Watch.ExcludeFD(fd)
+				}
+				if err != nil {
+					done <- err
+					return
+				}
+			}
+		}()
+		go func() {
+			for {
+				_, err := p.Watch.AwaitFDWithRead(time.Millisecond)
+				switch err {
+				case nil, ErrTimeout, ErrWoken:
+					continue
+				}
+				done <- err
+				return
+			}
+		}()
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	err := p.Watch.Close()
+	if err != nil {
+		t.Error("close error:", err)
+	}
+
+	timeout := time.After(holdupMax)
+	for i := 0; i < 2*routineCount; i++ {
+		select {
+		case err := <-done:
+			if err != ErrClosed {
+				t.Errorf("got error %v, want ErrClosed", err)
+			}
+		case <-timeout:
+			t.Fatalf("%d routines still running after close", 2*routineCount-i)
+		}
+	}
+}
+
+func newPipe(t *testing.T, opts ...Option) pipe {
+	p := pipe{Watch: newWatch(t, opts...)}
+	var err error
+	p.r, p.w, err = os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		p.r.Close()
+		p.w.Close()
+	})
+	// timeout pipe I/O too
+	testDeadline := time.Now().Add(testTimeout)
+	for _, f := range []*os.File{p.r, p.w} {
+		f.SetDeadline(testDeadline)
+	}
+
+	p.rFD = int(p.r.Fd())
 	return p
 }
+
+// NewTCPFiles returns both ends of a TCP connection on the loopback interface.
+func newTCPFiles(t *testing.T) (client, server *os.File) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	clientConn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientConn.Close()
+	serverConn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer serverConn.Close()
+
+	client, err = ConnFile(clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	server, err = ConnFile(serverConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return client, server
+}
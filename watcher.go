@@ -0,0 +1,6 @@
+//go:build linux || darwin || netbsd || freebsd || openbsd || dragonfly || solaris || windows
+
+package fdmom
+
+// Watch implements Watcher on each platform.
+var _ Watcher = (*Watch)(nil)
@@ -0,0 +1,41 @@
+//go:build linux || darwin || netbsd || freebsd || openbsd || dragonfly || solaris || windows
+
+package fdmom
+
+import (
+	"testing"
+	"time"
+)
+
+// Leeway for no delay expectations.
+const holdupMax = 100 * time.Millisecond
+
+// TestTimeout is the limit for each test with newWatch.
+const testTimeout = 2 * time.Second
+
+// NewWatch returns a Watch for a test in parallel. Shutdown is taken care of.
+func newWatch(t *testing.T, opts ...Option) *Watch {
+	t.Parallel()
+
+	w, err := OpenWatch(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// close watch on test timeout or test completion
+	timeout := time.AfterFunc(testTimeout, func() {
+		t.Error("closing watch on test timeout")
+		err := w.Close()
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	t.Cleanup(func() {
+		if timeout.Stop() {
+			err := w.Close()
+			if err != nil {
+				t.Error(err)
+			}
+		}
+	})
+	return w
+}
@@ -0,0 +1,413 @@
+//go:build windows
+
+package fdmom
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// ErrWatchable is only available on Linux and Windows. WSAPoll can not operate
+// on anything other than sockets.
+var ErrWatchable = errors.New("file type not suitable for Watch with WSAPoll")
+
+// ProcWSAPoll is missing in package syscall.
+var procWSAPoll = syscall.NewLazyDLL("ws2_32.dll").NewProc("WSAPoll")
+
+// WSAPollFD is struct WSAPOLLFD from <winsock2.h>.
+type wsaPollFD struct {
+	fd      uintptr // SOCKET
+	events  int16
+	revents int16
+}
+
+// WSAPoll flags from <winsock2.h>.
+const (
+	wsaPollErr    = 0x0001
+	wsaPollHup    = 0x0002
+	wsaPollNVal   = 0x0004
+	wsaPollWRNorm = 0x0010
+	wsaPollRDNorm = 0x0100
+	wsaPollRDBand = 0x0200
+)
+
+// Socket constants missing in package syscall.
+const (
+	wsaENotSock = syscall.Errno(10038) // WSAENOTSOCK
+	soType      = 0x1008               // SO_TYPE
+)
+
+// Watch monitors a list of sockets for read availability. Windows has no
+// readiness notification like epoll(7) or kqueue(2). Each Await submits the
+// entire watch list to WSAPoll instead, which costs linear time. Only the
+// portable part, as defined by the Watcher interface, plus a few is available.
+// Options other than ExcludeOnHangup and WithOrdering have no effect.
+//
+// WSAPoll did not report failed connects before Windows 10 version 2004.
+type Watch struct {
+	wakeSock syscall.Handle // UDP socket connected to itself
+	config   config
+
+	mutex      sync.Mutex
+	closed     bool
+	waiters    int              // number of Awaits in progress
+	fds        map[int]Interest // watch list
+	wokeUp     bool             // pending Wakeup
+	roundRobin int
+}
+
+// OpenWatch starts with an empty socket list.
+func OpenWatch(opts ...Option) (*Watch, error) {
+	sock, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, syscall.IPPROTO_UDP)
+	if err != nil {
+		return nil, fmt.Errorf("no Watch due socket error %w", err)
+	}
+	err = syscall.Bind(sock, &syscall.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}})
+	if err == nil {
+		var addr syscall.Sockaddr
+		addr, err = syscall.Getsockname(sock)
+		if err == nil {
+			err = syscall.Connect(sock, addr)
+		}
+	}
+	if err == nil {
+		// FIONBIO; syscall.SetNonblock does nothing on Windows
+		const fionbio = 0x8004667e
+		one := uint32(1)
+		var n uint32
+		err = syscall.WSAIoctl(sock, fionbio, (*byte)(unsafe.Pointer(&one)), 4, nil, 0, &n, nil, 0)
+	}
+	if err != nil {
+		syscall.Closesocket(sock)
+		return nil, fmt.Errorf("no Watch due wakeup socket error %w", err)
+	}
+
+	w := &Watch{
+		wakeSock: sock,
+		fds:      make(map[int]Interest),
+	}
+	for _, o := range opts {
+		o(&w.config)
+	}
+	return w, nil
+}
+
+// Close implements the io.Closer interface. Any Awaits in progress return with
+// ErrClosed. The wakeup socket is released on return of the last Await, in
+// which case any error from closesocket is lost.
+func (w *Watch) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	for fd := range w.fds {
+		delete(w.fds, fd)
+	}
+
+	if w.waiters != 0 {
+		// The wakeup socket is never read after Close, which
+		// means that it wakes each Await in progress.
+		w.wake()
+		return nil
+	}
+	return w.release()
+}
+
+// Release frees the kernel resources.
+func (w *Watch) release() error {
+	err := syscall.Closesocket(w.wakeSock)
+	if err != nil {
+		return fmt.Errorf("Watch stuck on closesocket error %w", err)
+	}
+	return nil
+}
+
+// Wake interrupts an Await in progress, if any, or the next one otherwise.
+func (w *Watch) wake() {
+	b := [1]byte{1}
+	buf := syscall.WSABuf{Len: 1, Buf: &b[0]}
+	var n uint32
+	syscall.WSASend(w.wakeSock, &buf, 1, &n, 0, nil, nil)
+}
+
+// Woken handles a wakeup event. The return is either ErrClosed or ErrWoken, or
+// nil when another routine took the wakeup already, or when the wakeup was for
+// a change in the watch list.
+func (w *Watch) woken() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+
+	// drain all to prevent repeated wakeups
+	var b [64]byte
+	buf := syscall.WSABuf{Len: uint32(len(b)), Buf: &b[0]}
+	for {
+		var n, flags uint32
+		err := syscall.WSARecv(w.wakeSock, &buf, 1, &n, &flags, nil, nil)
+		if err != nil {
+			break // WSAEWOULDBLOCK when empty
+		}
+	}
+	if !w.wokeUp {
+		return nil
+	}
+	w.wokeUp = false
+	return ErrWoken
+}
+
+// Enter registers an Await in progress. The return is false when closed.
+func (w *Watch) enter() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return false
+	}
+	w.waiters++
+	return true
+}
+
+// Leave unregisters an Await in progress, and it releases the kernel resources
+// when Close is pending on its completion.
+func (w *Watch) leave() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.waiters--
+	if w.closed && w.waiters == 0 {
+		w.release()
+	}
+}
+
+// Wakeup interrupts an Await in progress with ErrWoken, or the next Await when
+// none is in progress. Wakeups pending coalesce into one.
+func (w *Watch) Wakeup() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	w.wokeUp = true
+	w.wake()
+	return nil
+}
+
+// AwaitFDWithRead blocks until it finds a socket with read available. Positive
+// timeout values, including zero for non-blocking, cause an ErrTimeout on
+// expiry. Negative timeouts block indefinitely.
+func (w *Watch) AwaitFDWithRead(timeout time.Duration) (fd int, err error) {
+	fd, _, err = w.AwaitFD(timeout)
+	return fd, err
+}
+
+// AwaitFD is like AwaitFDWithRead, yet it also reports which conditions were
+// met on the socket. Sockets which are no longer open come as an *FDError with
+// ErrBadFD.
+func (w *Watch) AwaitFD(timeout time.Duration) (fd int, ready Interest, err error) {
+	if !w.enter() {
+		return 0, 0, ErrClosed
+	}
+	defer w.leave()
+
+	// restarts continue with the remainder
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	for {
+		// the watch list may change during the wait
+		w.mutex.Lock()
+		pollFDs := make([]wsaPollFD, 1, 1+len(w.fds))
+		pollFDs[0] = wsaPollFD{fd: uintptr(w.wakeSock), events: wsaPollRDNorm}
+		for fd, interest := range w.fds {
+			pollFDs = append(pollFDs, wsaPollFD{fd: uintptr(fd), events: wsaPollEvents(interest)})
+		}
+		w.mutex.Unlock()
+
+		r1, _, errno := procWSAPoll.Call(uintptr(unsafe.Pointer(&pollFDs[0])), uintptr(len(pollFDs)), uintptr(wsaPollMsec(timeout)))
+		switch n := int32(r1); {
+		case n < 0:
+			return 0, 0, fmt.Errorf("Watch unavailable due WSAPoll error %w", errno)
+		case n == 0:
+			if timeout > math.MaxInt32*time.Millisecond {
+				// wait was capped; continue with the remainder
+				timeout = remaining(timeout, deadline)
+				if timeout > 0 {
+					continue
+				}
+			}
+			return 0, 0, ErrTimeout
+		}
+
+		if pollFDs[0].revents != 0 {
+			err := w.woken()
+			if err != nil {
+				return 0, 0, err
+			}
+		}
+
+		// The cursor rotates over the watch list, which is in random
+		// order from the map, so each ready socket gets a turn.
+		sockets := pollFDs[1:]
+		w.mutex.Lock()
+		w.roundRobin++
+		for i := range sockets {
+			p := &sockets[(i+w.roundRobin)%len(sockets)]
+			if p.revents == 0 {
+				continue
+			}
+			fd = int(p.fd)
+			if _, ok := w.fds[fd]; !ok {
+				continue // excluded during the wait
+			}
+			if p.revents&wsaPollNVal != 0 {
+				w.mutex.Unlock()
+				return 0, 0, &FDError{FD: fd, Err: ErrBadFD}
+			}
+			ready = wsaReadyOf(p.revents)
+			if ready&Hangup != 0 && w.config.excludeOnHangup {
+				delete(w.fds, fd)
+			}
+			w.mutex.Unlock()
+			return fd, ready, nil
+		}
+		w.mutex.Unlock()
+		// wakeup only
+		timeout = remaining(timeout, deadline)
+	}
+}
+
+// WSAPollMsec returns the WSAPoll equivalent of timeout. Timeouts beyond the
+// range of an INT are capped, which makes the wait return early.
+func wsaPollMsec(timeout time.Duration) int32 {
+	if timeout < 0 {
+		return -1 // indefinite
+	}
+	// timeout rounds up as they are a minimum guarantee
+	msec := timeout / time.Millisecond
+	if timeout%time.Millisecond != 0 {
+		msec++
+	}
+	if msec > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int32(msec)
+}
+
+// WSAPollEvents maps conditions to their respective WSAPoll events. WSAPoll
+// rejects POLLPRI, which is why Priority maps to POLLRDBAND.
+func wsaPollEvents(interest Interest) int16 {
+	var events int16
+	if interest&(Read|Hangup) != 0 {
+		events |= wsaPollRDNorm
+	}
+	if interest&Priority != 0 {
+		events |= wsaPollRDBand
+	}
+	if interest&Write != 0 {
+		events |= wsaPollWRNorm
+	}
+	return events
+}
+
+// WSAReadyOf maps WSAPoll events to their respective conditions.
+func wsaReadyOf(revents int16) Interest {
+	var ready Interest
+	// hang-ups and errors make read return without blocking
+	if revents&(wsaPollRDNorm|wsaPollHup|wsaPollErr) != 0 {
+		ready |= Read
+	}
+	if revents&wsaPollHup != 0 {
+		ready |= Hangup
+	}
+	if revents&wsaPollRDBand != 0 {
+		ready |= Priority
+	}
+	if revents&wsaPollWRNorm != 0 {
+		ready |= Write
+	}
+	if revents&wsaPollErr != 0 {
+		ready |= Error
+	}
+	return ready
+}
+
+// IncludeFD adds the socket to the watch list. Duplicates are ignored silently.
+// Anything other than a socket gets ErrWatchable.
+func (w *Watch) IncludeFD(fd int) error {
+	return w.include(fd, Read)
+}
+
+// IncludeFDForWrite adds the socket to the watch list for Write availability.
+func (w *Watch) IncludeFDForWrite(fd int) error {
+	return w.include(fd, Write)
+}
+
+// Include adds interest to the watch list.
+func (w *Watch) include(fd int, interest Interest) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+
+	_, err := syscall.GetsockoptInt(syscall.Handle(fd), syscall.SOL_SOCKET, soType)
+	switch err {
+	case nil:
+		break
+	case wsaENotSock:
+		return ErrWatchable
+	default:
+		return opError("IncludeFD", fd, err)
+	}
+	w.fds[fd] |= interest
+	// Awaits in progress need to restart with the new list.
+	if w.waiters != 0 {
+		w.wake()
+	}
+	return nil
+}
+
+// ModifyFD sets the conditions of interest for a socket on the watch list, which
+// replaces any interest from before. Absence from the watch list gets
+// ErrNotWatched. Zero interest is equivalent to ExcludeFD.
+func (w *Watch) ModifyFD(fd int, interest Interest) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	if _, ok := w.fds[fd]; !ok {
+		return ErrNotWatched
+	}
+	if interest&(Read|Priority|Hangup|Write) == 0 {
+		delete(w.fds, fd)
+	} else {
+		w.fds[fd] = interest
+	}
+	// Awaits in progress need to restart with the new list.
+	if w.waiters != 0 {
+		w.wake()
+	}
+	return nil
+}
+
+// ExcludeFD removes the socket from the watch list. Absence is ignored
+// silently.
+func (w *Watch) ExcludeFD(fd int) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	delete(w.fds, fd)
+	return nil
+}
@@ -0,0 +1,160 @@
+//go:build windows
+
+package fdmom
+
+import (
+	"errors"
+	"math"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TCPPair returns a connected pair of TCP sockets on the loopback interface.
+func tcpPair(t *testing.T) (client, server *net.TCPConn) {
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	client, err = net.DialTCP("tcp", nil, l.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	server, err = l.AcceptTCP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return client, server
+}
+
+// SocketOf returns the handle of c, which remains in use by c.
+func socketOf(t *testing.T, c syscall.Conn) int {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fd int
+	err = raw.Control(func(h uintptr) { fd = int(h) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fd
+}
+
+func TestWSAPollMsec(t *testing.T) {
+	tests := []struct {
+		timeout time.Duration
+		want    int32
+	}{
+		{-1, -1},
+		{0, 0},
+		{1, 1},
+		{time.Millisecond, 1},
+		{time.Millisecond + 1, 2},
+		{24 * time.Hour, 86400000},
+		{30 * 24 * time.Hour, math.MaxInt32},
+		{math.MaxInt64, math.MaxInt32},
+	}
+	for _, test := range tests {
+		if got := wsaPollMsec(test.timeout); got != test.want {
+			t.Errorf("wsaPollMsec(%s) got %d, want %d", test.timeout, got, test.want)
+		}
+	}
+}
+
+func TestWSAPollRead(t *testing.T) {
+	w := newWatch(t)
+	client, server := tcpPair(t)
+	fd := socketOf(t, server)
+	if err := w.IncludeFD(fd); err != nil {
+		t.Fatal("include error:", err)
+	}
+
+	_, err := w.AwaitFDWithRead(0)
+	if err != ErrTimeout {
+		t.Fatalf("await without data got error %v, want ErrTimeout", err)
+	}
+
+	if _, err := client.Write([]byte{'x'}); err != nil {
+		t.Fatal("write error:", err)
+	}
+	got, ready, err := w.AwaitFD(testTimeout)
+	if err != nil {
+		t.Fatal("await error:", err)
+	}
+	if got != fd || ready&Read == 0 {
+		t.Errorf("await got file descriptor %d with %s, want %d with read", got, ready, fd)
+	}
+}
+
+func TestWSAPollModifyPriority(t *testing.T) {
+	w := newWatch(t)
+	_, server := tcpPair(t)
+	fd := socketOf(t, server)
+	if err := w.IncludeFD(fd); err != nil {
+		t.Fatal("include error:", err)
+	}
+
+	if err := w.ModifyFD(fd, Priority); err != nil {
+		t.Fatal("modify error:", err)
+	}
+	// still on the watch list
+	if err := w.ModifyFD(fd, Read); err != nil {
+		t.Errorf("modify after Priority got error %v, want nil", err)
+	}
+
+	if err := w.ModifyFD(fd, 0); err != nil {
+		t.Fatal("modify error:", err)
+	}
+	if err := w.ModifyFD(fd, Read); err != ErrNotWatched {
+		t.Errorf("modify after zero interest got error %v, want ErrNotWatched", err)
+	}
+}
+
+func TestWSAPollWatchable(t *testing.T) {
+	w := newWatch(t)
+	f, err := os.CreateTemp(t.TempDir(), "regular")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	err = w.IncludeFD(int(f.Fd()))
+	if !errors.Is(err, ErrWatchable) {
+		t.Errorf("include of regular file got error %v, want ErrWatchable", err)
+	}
+}
+
+func TestWSAPollWakeupCoalesce(t *testing.T) {
+	w := newWatch(t)
+	for i := 0; i < 2; i++ {
+		if err := w.Wakeup(); err != nil {
+			t.Fatal("wakeup error:", err)
+		}
+	}
+
+	_, err := w.AwaitFDWithRead(testTimeout)
+	if err != ErrWoken {
+		t.Errorf("await got error %v, want ErrWoken", err)
+	}
+	_, err = w.AwaitFDWithRead(0)
+	if err != ErrTimeout {
+		t.Errorf("second await got error %v, want ErrTimeout", err)
+	}
+}
+
+func TestWSAPollCloseDuringAwait(t *testing.T) {
+	w := newWatch(t)
+	time.AfterFunc(holdupMax/2, func() { w.Close() })
+
+	_, err := w.AwaitFDWithRead(testTimeout)
+	if err != ErrClosed {
+		t.Errorf("await got error %v, want ErrClosed", err)
+	}
+}